@@ -0,0 +1,272 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/search"
+	"github.com/martinlehoux/kagapass/internal/testor"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+const testPassword = "supersecret"
+
+func writeTestDatabase(t *testing.T, dir string, name string, titles ...string) {
+	t.Helper()
+
+	root := testor.NewGroup("Root")
+	for _, title := range titles {
+		root.Entries = append(root.Entries, testor.NewEntry(title, "octocat", title+"-pass"))
+	}
+
+	testor.WriteDatabase(t, filepath.Join(dir, name), testor.NewDatabase(gokeepasslib.NewPasswordCredentials(testPassword), root))
+}
+
+// startAgent serves an agent over personal.kdbx (GitHub, Gmail) and
+// work.kdbx (GitHub, Jira) until the end of the test.
+func startAgent(t *testing.T) (*Server, *Client, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "personal.kdbx", "GitHub", "Gmail")
+	writeTestDatabase(t, dir, "work.kdbx", "GitHub", "Jira")
+
+	socket := filepath.Join(dir, "agent", "agent.sock")
+
+	listener, err := Listen(socket)
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+
+	server := NewServer(keepass.NewLoader(keepass.DirFS(dir)), time.Minute)
+
+	served := make(chan error)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+
+		if err := <-served; err != nil {
+			t.Errorf("Serve() failed: %v", err)
+		}
+	})
+
+	client, err := Dial(socket)
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+
+	return server, client, socket
+}
+
+func TestAgent(t *testing.T) {
+	_, client, socket := startAgent(t)
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("Expected the socket to exist: %v", err)
+	}
+
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected socket mode 0600, got %o", info.Mode().Perm())
+	}
+
+	personal := types.Database{Name: "personal", Path: "personal.kdbx"}
+	work := types.Database{Name: "work", Path: "work.kdbx"}
+
+	if _, err := client.Get("", "GitHub", ""); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked before unlocking, got %v", err)
+	}
+
+	if err := client.Unlock(personal, keepass.PasswordCredentials([]byte("wrong"))); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+
+	for _, database := range []types.Database{personal, work} {
+		if err := client.Unlock(database, keepass.PasswordCredentials([]byte(testPassword))); err != nil {
+			t.Fatalf("Unlock() failed: %v", err)
+		}
+	}
+
+	databases, err := client.Status()
+	if err != nil || len(databases) != 2 || databases[0].Name != "personal" {
+		t.Errorf("Expected personal and work to be unlocked, got %+v, %v", databases, err)
+	}
+
+	value, err := client.Get("", "Jira", "")
+	if err != nil || value != "Jira-pass" {
+		t.Errorf("Expected the password of Jira, got '%s', %v", value, err)
+	}
+
+	if _, err := client.Get("", "GitHub", ""); !errors.Is(err, search.ErrAmbiguous) {
+		t.Errorf("Expected GitHub to be ambiguous across databases, got %v", err)
+	}
+
+	value, err = client.Get("work", "GitHub", "username")
+	if err != nil || value != "octocat" {
+		t.Errorf("Expected the username of GitHub in work, got '%s', %v", value, err)
+	}
+
+	if _, err := client.Get("", "Unknown", ""); !errors.Is(err, search.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	entries, err := client.Vault(personal).Entries()
+	if err != nil || len(entries) != 2 || entries[0].Database.Name != "personal" {
+		t.Errorf("Expected the entries of personal, got %+v, %v", entries, err)
+	}
+
+	if err := client.Lock("personal"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+
+	if _, err := client.Vault(personal).Entries(); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected personal to be locked, got %v", err)
+	}
+
+	if _, err := client.Get("work", "Jira", ""); err != nil {
+		t.Errorf("Expected work to stay unlocked, got %v", err)
+	}
+
+	// A second agent does not replace a running one
+	if _, err := Listen(socket); err == nil {
+		t.Error("Expected listening on the socket of a running agent to fail")
+	}
+}
+
+func TestAgentVault(t *testing.T) {
+	_, client, _ := startAgent(t)
+
+	personal := types.Database{Name: "personal", Path: "personal.kdbx"}
+	if err := client.Unlock(personal, keepass.PasswordCredentials([]byte(testPassword))); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	vault := client.Vault(personal)
+	other := client.Vault(personal)
+
+	entries, err := vault.Entries()
+	if err != nil {
+		t.Fatalf("Entries() failed: %v", err)
+	}
+
+	if _, err := other.Entries(); err != nil {
+		t.Fatalf("Entries() failed: %v", err)
+	}
+
+	github := entries[0]
+	github.Password = "changed"
+
+	saved, entries, err := vault.SaveEntry(github, false)
+	if err != nil || saved.Password != "changed" || saved.Raw.UUID != github.Raw.UUID || len(entries) != 2 {
+		t.Fatalf("Expected GitHub to be saved, got %+v, %v", saved, err)
+	}
+
+	if value, err := client.Get("personal", "GitHub", ""); err != nil || value != "changed" {
+		t.Errorf("Expected the agent to serve the saved password, got '%s', %v", value, err)
+	}
+
+	// Another client of the agent sees the write
+	if changed, err := vault.Changed(); err != nil || changed {
+		t.Errorf("Expected no change after its own write, got %v, %v", changed, err)
+	}
+
+	if changed, err := other.Changed(); err != nil || !changed {
+		t.Errorf("Expected a change after another client wrote, got %v, %v", changed, err)
+	}
+
+	entries, changed, err := other.Reload()
+	if err != nil || !changed || entries[0].Password != "changed" {
+		t.Errorf("Expected the saved password after reloading, got %+v, %v, %v", entries, changed, err)
+	}
+
+	if changed, err := other.Changed(); err != nil || changed {
+		t.Errorf("Expected no change once reloaded, got %v, %v", changed, err)
+	}
+
+	if _, err := vault.DeleteEntry(saved.Raw.UUID); err != nil {
+		t.Fatalf("DeleteEntry() failed: %v", err)
+	}
+
+	if _, err := vault.DeleteEntry(saved.Raw.UUID); err == nil {
+		t.Error("Expected deleting a missing entry to fail")
+	}
+
+	// An empty password is not sent, and is no master key
+	if err := vault.ChangeMasterKey(keepass.PasswordCredentials([]byte{}), keepass.DefaultKDF(keepass.AESKDF)); err == nil || err.Error() != keepass.ErrNoMasterKey.Error() {
+		t.Errorf("Expected an empty password to be refused, got %v", err)
+	}
+
+	// The new master key is only known to the agent and to the file
+	if err := vault.ChangeMasterKey(keepass.PasswordCredentials([]byte("new password")), keepass.DefaultKDF(keepass.AESKDF)); err != nil {
+		t.Fatalf("ChangeMasterKey() failed: %v", err)
+	}
+
+	info, err := vault.Info()
+	if err != nil || info.KDF.Type != keepass.AESKDF {
+		t.Errorf("Expected the database to use AES-KDF, got %+v, %v", info.KDF, err)
+	}
+
+	if err := client.Lock("personal"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+
+	if err := client.Unlock(personal, keepass.PasswordCredentials([]byte("new password"))); err != nil {
+		t.Errorf("Expected the new password to unlock the database, got %v", err)
+	}
+}
+
+func TestAgentProtocolVersion(t *testing.T) {
+	server, _, _ := startAgent(t)
+
+	response := server.Handle(Request{Version: ProtocolVersion + 1, Op: StatusOp})
+	if response.Code != BadRequestCode {
+		t.Errorf("Expected other protocol versions to be refused, got %+v", response)
+	}
+
+	response = server.Handle(Request{Version: ProtocolVersion, Op: "unknown"})
+	if response.Code != BadRequestCode {
+		t.Errorf("Expected unknown operations to be refused, got %+v", response)
+	}
+}
+
+func TestAgentIdleLock(t *testing.T) {
+	server, client, _ := startAgent(t)
+
+	now := time.Now()
+	server.now = func() time.Time { return now }
+
+	personal := types.Database{Name: "personal", Path: "personal.kdbx"}
+
+	err := client.Unlock(personal, keepass.PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	server.lockIfIdle()
+
+	if databases, _ := client.Status(); len(databases) != 1 {
+		t.Error("Expected the database to stay unlocked before the idle timeout")
+	}
+
+	// Polling requests from the interface are not activity
+	_, err = client.Vault(personal).Changed()
+	if err != nil {
+		t.Fatalf("Changed() failed: %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	server.lockIfIdle()
+
+	if databases, _ := client.Status(); len(databases) != 0 {
+		t.Error("Expected the database to be locked after the idle timeout")
+	}
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/search"
+	"github.com/martinlehoux/kagapass/internal/socket"
+	"github.com/martinlehoux/kagapass/internal/types"
+)
+
+var (
+	// ErrLocked is returned when the agent has not unlocked the database.
+	ErrLocked = errors.New("locked in agent")
+	// ErrNotRunning is returned when no agent listens on the socket.
+	ErrNotRunning = errors.New("agent is not running")
+)
+
+// codeErrors maps the codes of responses to the errors they match.
+var codeErrors = map[Code]error{
+	LockedCode:    ErrLocked,
+	NotFoundCode:  search.ErrNotFound,
+	AmbiguousCode: search.ErrAmbiguous,
+}
+
+// remoteError is an error answered by the agent.
+type remoteError struct {
+	code    Code
+	message string
+}
+
+func (e remoteError) Error() string {
+	return e.message
+}
+
+func (e remoteError) Is(target error) bool {
+	return codeErrors[e.code] == target
+}
+
+// Client talks to a running agent.
+type Client struct {
+	path string
+}
+
+// Dial connects to the agent listening on path, failing when none is running
+// or when it speaks another protocol version.
+func Dial(path string) (*Client, error) {
+	client := &Client{path: path}
+
+	_, err := client.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Status returns the databases unlocked in the agent.
+func (c *Client) Status() ([]types.Database, error) {
+	response, err := c.call(Request{Version: ProtocolVersion, Op: StatusOp}) //nolint:exhaustruct // Status has no arguments
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Databases, nil
+}
+
+// Unlock has the agent unlock the database and keep it.
+func (c *Client) Unlock(database types.Database, credentials keepass.Credentials) error {
+	_, err := c.call(Request{ //nolint:exhaustruct // Only the database and its credentials
+		Version:  ProtocolVersion,
+		Op:       UnlockOp,
+		Database: database.Path,
+		Name:     database.Name,
+		Password: credentials.Password,
+		KeyFile:  credentials.KeyFile,
+	})
+
+	return err
+}
+
+// Get returns a field of an entry, looked up in the database with the given
+// name or path, or in all unlocked databases when it is empty.
+func (c *Client) Get(database string, entry string, field string) (string, error) {
+	response, err := c.call(Request{ //nolint:exhaustruct // No credentials
+		Version:  ProtocolVersion,
+		Op:       GetOp,
+		Database: database,
+		Entry:    entry,
+		Field:    field,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return response.Value, nil
+}
+
+// Lock locks the database with the given name or path, or all of them.
+func (c *Client) Lock(database string) error {
+	_, err := c.call(Request{Version: ProtocolVersion, Op: LockOp, Database: database}) //nolint:exhaustruct // Only the database
+
+	return err
+}
+
+func (c *Client) call(request Request) (Response, error) {
+	var response Response
+
+	// Secrets are only sent to an agent run by the user
+	err := socket.Check(c.path)
+	if errors.Is(err, socket.ErrNotPrivate) {
+		return response, fmt.Errorf("refusing agent socket: %w", err)
+	} else if err != nil {
+		return response, fmt.Errorf("%w: %w", ErrNotRunning, err)
+	}
+
+	conn, err := net.DialTimeout("unix", c.path, requestTimeout)
+	if err != nil {
+		return response, fmt.Errorf("%w: %w", ErrNotRunning, err)
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(requestTimeout))
+	if err != nil {
+		return response, err
+	}
+
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		return response, err
+	}
+
+	// Operations deriving a key are waited for, so that a change the agent
+	// made is never reported as failed
+	if request.Op.derivesKey() {
+		err = conn.SetDeadline(time.Time{})
+		if err != nil {
+			return response, err
+		}
+	}
+
+	err = json.NewDecoder(conn).Decode(&response)
+	if err != nil {
+		return response, fmt.Errorf("invalid agent response: %w", err)
+	}
+
+	if response.Version != ProtocolVersion {
+		return response, fmt.Errorf("agent speaks protocol version %d, expected %d", response.Version, ProtocolVersion)
+	}
+
+	if response.Error != "" {
+		return response, remoteError{code: response.Code, message: response.Error}
+	}
+
+	return response, nil
+}
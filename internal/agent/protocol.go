@@ -0,0 +1,152 @@
+// Package agent keeps databases unlocked in a background process, serving
+// them over a Unix socket so that neither the interface nor scripts have to
+// decrypt them or store their master password again.
+//
+// Each connection carries one request and one response, both JSON objects
+// tagged with the protocol version. The interface reads and writes the
+// databases the agent holds through it, so that their master key never
+// leaves the agent.
+package agent
+
+import (
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/socket"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// ProtocolVersion is bumped on incompatible changes of Request or Response.
+const ProtocolVersion = 2
+
+// Op is the operation of a request.
+type Op string
+
+const (
+	// StatusOp lists the unlocked databases.
+	StatusOp Op = "status"
+	// UnlockOp unlocks a database with the given credentials and keeps it.
+	UnlockOp Op = "unlock"
+	// GetOp returns a field of an entry.
+	GetOp Op = "get"
+	// LockOp locks a database, or all of them.
+	LockOp Op = "lock"
+
+	// EntriesOp returns the entries of a database.
+	EntriesOp Op = "entries"
+	// GroupsOp returns the group tree of a database.
+	GroupsOp Op = "groups"
+	// InfoOp describes a database and how it is encrypted.
+	InfoOp Op = "info"
+	// AttachmentOp returns the content of an attachment of an entry.
+	AttachmentOp Op = "attachment"
+	// SaveEntryOp creates or updates an entry, then saves the database.
+	SaveEntryOp Op = "save_entry"
+	// RestoreOp makes a previous version of an entry its current one, then
+	// saves the database.
+	RestoreOp Op = "restore"
+	// DeleteEntryOp deletes an entry, then saves the database.
+	DeleteEntryOp Op = "delete_entry"
+	// ChangeMasterKeyOp re-encrypts a database with new credentials and KDF
+	// settings.
+	ChangeMasterKeyOp Op = "change_master_key"
+	// ChangedOp reports whether a database changed since the revision of the
+	// request.
+	ChangedOp Op = "changed"
+	// ReloadOp decrypts a database again when its file changed, and returns
+	// its entries.
+	ReloadOp Op = "reload"
+)
+
+// derivesKey reports whether the operation may decrypt or encrypt a database,
+// which takes as long as its KDF settings make it, up to seconds.
+func (op Op) derivesKey() bool {
+	switch op {
+	case UnlockOp, GetOp, EntriesOp, SaveEntryOp, RestoreOp, DeleteEntryOp, ChangeMasterKeyOp, ReloadOp:
+		return true
+	default:
+		return false
+	}
+}
+
+// isActivity reports whether the operation is made on behalf of the user.
+// Status, change checks and the reloads they trigger are polled by the
+// interface on its own, so they don't delay the idle lock.
+func (op Op) isActivity() bool {
+	switch op {
+	case StatusOp, ChangedOp, ReloadOp:
+		return false
+	default:
+		return true
+	}
+}
+
+// Code classifies the errors of a response.
+type Code string
+
+const (
+	BadRequestCode Code = "bad_request"
+	LockedCode     Code = "locked"
+	NotFoundCode   Code = "not_found"
+	AmbiguousCode  Code = "ambiguous"
+	FailedCode     Code = "failed"
+)
+
+// Request is sent by clients.
+type Request struct {
+	Version int `json:"version"`
+	Op      Op  `json:"op"`
+	// Database is the path of a database, or its name when looking up entries
+	Database string `json:"database,omitempty"`
+	// Name, Password and KeyFile unlock a database, Password and KeyFile are
+	// its new credentials when changing its master key
+	Name     string `json:"name,omitempty"`
+	Password []byte `json:"password,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// KeepPassword keeps the current password when changing the master key
+	KeepPassword bool         `json:"keep_password,omitempty"`
+	KDF          *keepass.KDF `json:"kdf,omitempty"`
+	// Entry is the title or full path of an entry, Field the one to get
+	Entry string `json:"entry,omitempty"`
+	Field string `json:"field,omitempty"`
+	// UUID identifies the entry to read or write, Attachment the file to read
+	// and Modified the version to restore
+	UUID       gokeepasslib.UUID `json:"uuid,omitzero"`
+	Attachment string            `json:"attachment,omitempty"`
+	Modified   time.Time         `json:"modified,omitzero"`
+	// Saved is the entry to save, created when New is set
+	Saved *types.Entry `json:"saved,omitempty"`
+	New   bool         `json:"new,omitempty"`
+	// Revision is the last revision of the database the client read
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// Response is sent back by the agent. Error is empty on success.
+type Response struct {
+	Version int    `json:"version"`
+	Error   string `json:"error,omitempty"`
+	Code    Code   `json:"code,omitempty"`
+	// Databases are the unlocked databases, for status requests
+	Databases []types.Database `json:"databases,omitempty"`
+	// Value is the field of a get request
+	Value string `json:"value,omitempty"`
+	// Entries, Entry, Groups, Info and Data are read from a database, Entry
+	// is the saved or restored one
+	Entries []types.Entry `json:"entries,omitempty"`
+	Entry   *types.Entry  `json:"entry,omitempty"`
+	Groups  *types.Group  `json:"groups,omitempty"`
+	Info    *keepass.Info `json:"info,omitempty"`
+	Data    []byte        `json:"data,omitempty"`
+	// Revision counts the changes of the database made or loaded by the
+	// agent. Changed reports, to changed and reload requests, that the
+	// database is not the one of the revision of the request
+	Revision uint64 `json:"revision,omitempty"`
+	Changed  bool   `json:"changed,omitempty"`
+}
+
+// SocketPath returns $KAGAPASS_AGENT_SOCK, or agent.sock in a kagapass
+// directory of $XDG_RUNTIME_DIR or of the temporary directory.
+func SocketPath() string {
+	return socket.Path("KAGAPASS_AGENT_SOCK", "agent.sock")
+}
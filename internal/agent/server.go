@@ -0,0 +1,543 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/search"
+	"github.com/martinlehoux/kagapass/internal/socket"
+	"github.com/martinlehoux/kagapass/internal/types"
+)
+
+const (
+	// lockCheckInterval is how often the idle timeout is checked.
+	lockCheckInterval = 10 * time.Second
+	// requestTimeout bounds the time a client may take to send its request or
+	// read the response, and the time quick operations take.
+	requestTimeout = 10 * time.Second
+	// maxRequestSize is far above any valid request.
+	maxRequestSize = 1 << 20
+)
+
+var (
+	errLocked     = errors.New("database is not unlocked in the agent")
+	errBadRequest = errors.New("invalid request")
+)
+
+// Server holds the unlocked databases and answers requests.
+type Server struct {
+	loader *keepass.Loader
+	// idleLock locks all databases once no user request came for that long, zero
+	// keeps them until the agent stops
+	idleLock time.Duration
+	now      func() time.Time
+
+	mu           sync.Mutex
+	unlocked     map[string]*unlockedDatabase
+	lastActivity time.Time
+}
+
+// unlockedDatabase is a database kept by the agent, by path.
+type unlockedDatabase struct {
+	database types.Database
+	vault    *keepass.Vault
+
+	// mu orders the operations on the vault along with the revisions they make
+	mu       sync.Mutex
+	revision uint64
+}
+
+// NewServer creates an agent loading databases with the loader.
+func NewServer(loader *keepass.Loader, idleLock time.Duration) *Server {
+	return &Server{
+		loader:       loader,
+		idleLock:     idleLock,
+		now:          time.Now,
+		mu:           sync.Mutex{},
+		unlocked:     map[string]*unlockedDatabase{},
+		lastActivity: time.Now(),
+	}
+}
+
+// Listen listens on the socket, only accessible to the user, see
+// socket.Listen.
+func Listen(path string) (net.Listener, error) {
+	listener, err := socket.Listen(path)
+	if errors.Is(err, socket.ErrInUse) {
+		return nil, fmt.Errorf("an agent is %w", err)
+	}
+
+	return listener, err
+}
+
+// Serve answers connections until the listener is closed, then locks all
+// databases.
+func (s *Server) Serve(listener net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	defer s.lock("")
+
+	go s.lockWhenIdle(done)
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	err := conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	if err != nil {
+		log.Printf("failed to set agent connection deadline: %v", err)
+
+		return
+	}
+
+	var (
+		request  Request
+		response Response
+	)
+
+	// Handling the request is not bounded, since deriving a key takes as long
+	// as the KDF settings of the database make it
+	err = json.NewDecoder(io.LimitReader(conn, maxRequestSize)).Decode(&request)
+	if err != nil {
+		response = errorResponse(BadRequestCode, fmt.Errorf("invalid request: %w", err))
+	} else {
+		response = s.Handle(request)
+	}
+
+	err = conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	if err != nil {
+		log.Printf("failed to set agent connection deadline: %v", err)
+
+		return
+	}
+
+	err = json.NewEncoder(conn).Encode(response)
+	if err != nil {
+		log.Printf("failed to answer agent request: %v", err)
+	}
+}
+
+// Handle answers one request.
+func (s *Server) Handle(request Request) Response {
+	if request.Version != ProtocolVersion {
+		return errorResponse(BadRequestCode, fmt.Errorf("unsupported protocol version %d, the agent speaks %d", request.Version, ProtocolVersion))
+	}
+
+	if request.Op.isActivity() {
+		s.mu.Lock()
+		s.lastActivity = s.now()
+		s.mu.Unlock()
+	}
+
+	switch request.Op {
+	case StatusOp:
+		return s.status()
+	case UnlockOp:
+		return s.unlock(request)
+	case EntriesOp, GroupsOp, InfoOp, AttachmentOp, SaveEntryOp, RestoreOp, DeleteEntryOp, ChangeMasterKeyOp, ChangedOp, ReloadOp:
+		return s.serveDatabase(request)
+	case GetOp:
+		return s.get(request)
+	case LockOp:
+		err := s.lock(request.Database)
+		if err != nil {
+			return errorResponse(NotFoundCode, err)
+		}
+
+		return okResponse()
+	default:
+		return errorResponse(BadRequestCode, fmt.Errorf("unknown operation %q", request.Op))
+	}
+}
+
+func (s *Server) status() Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	response := okResponse()
+	for _, unlocked := range s.unlocked {
+		response.Databases = append(response.Databases, unlocked.database)
+	}
+
+	sort.Slice(response.Databases, func(i, j int) bool {
+		return response.Databases[i].Name < response.Databases[j].Name
+	})
+
+	return response
+}
+
+func (s *Server) unlock(request Request) Response {
+	if request.Database == "" {
+		return errorResponse(BadRequestCode, errors.New("no database to unlock"))
+	}
+
+	credentials := keepass.Credentials{Password: request.Password, KeyFile: request.KeyFile}
+
+	// Decrypting may take seconds, other requests are served meanwhile
+	database, err := s.loader.Load(request.Database, credentials)
+	if err != nil {
+		return errorResponse(FailedCode, err)
+	}
+
+	name := request.Name
+	if name == "" {
+		name = filepath.Base(request.Database)
+	}
+
+	unlocked := &unlockedDatabase{
+		database: types.Database{
+			Name:           name,
+			Path:           request.Database,
+			LastAccessed:   time.Time{},
+			KeyFile:        request.KeyFile,
+			SecretStoredAt: time.Time{},
+		},
+		vault:    keepass.NewVault(database),
+		mu:       sync.Mutex{},
+		revision: 0,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if previous, ok := s.unlocked[request.Database]; ok {
+		closeDatabase(previous)
+	}
+
+	s.unlocked[request.Database] = unlocked
+
+	return okResponse()
+}
+
+// serveDatabase applies the request to the database with its path, one
+// request at a time.
+func (s *Server) serveDatabase(request Request) Response {
+	s.mu.Lock()
+	unlocked, ok := s.unlocked[request.Database]
+	s.mu.Unlock()
+
+	if !ok {
+		return errorResponse(LockedCode, fmt.Errorf("%w: %s", errLocked, request.Database))
+	}
+
+	unlocked.mu.Lock()
+	defer unlocked.mu.Unlock()
+
+	response, err := unlocked.apply(request)
+	if err != nil {
+		return vaultErrorResponse(err)
+	}
+
+	response.Revision = unlocked.revision
+
+	return response
+}
+
+func (u *unlockedDatabase) apply(request Request) (Response, error) {
+	response := okResponse()
+
+	var err error
+
+	switch request.Op {
+	case EntriesOp:
+		response.Entries, err = u.entries()
+	case GroupsOp:
+		var groups types.Group
+
+		groups, err = u.vault.Groups()
+		response.Groups = &groups
+	case InfoOp:
+		var info keepass.Info
+
+		info, err = u.vault.Info()
+		response.Info = &info
+	case AttachmentOp:
+		response.Data, err = u.vault.Attachment(request.UUID, request.Attachment)
+	case SaveEntryOp:
+		if request.Saved == nil {
+			return response, fmt.Errorf("%w: no entry to save", errBadRequest)
+		}
+
+		var saved types.Entry
+
+		saved, response.Entries, err = u.vault.SaveEntry(*request.Saved, request.New)
+		response.Entry = &saved
+		u.changed(err)
+	case RestoreOp:
+		var restored types.Entry
+
+		restored, response.Entries, err = u.vault.RestoreVersion(request.UUID, request.Modified)
+		response.Entry = &restored
+		u.changed(err)
+	case DeleteEntryOp:
+		response.Entries, err = u.vault.DeleteEntry(request.UUID)
+		u.changed(err)
+	case ChangeMasterKeyOp:
+		err = u.changeMasterKey(request)
+	case ChangedOp:
+		response.Changed, err = u.vault.Changed()
+		response.Changed = response.Changed || request.Revision != u.revision
+	case ReloadOp:
+		var reloaded bool
+
+		response.Entries, reloaded, err = u.vault.Reload()
+		if reloaded {
+			u.revision++
+		}
+
+		response.Changed = request.Revision != u.revision
+	default:
+		return response, fmt.Errorf("%w: unknown operation %q", errBadRequest, request.Op)
+	}
+
+	if err != nil {
+		return response, err
+	}
+
+	response.Entries = tagEntries(response.Entries, u.database)
+	if response.Entry != nil {
+		response.Entry.Database = u.database
+	}
+
+	return response, nil
+}
+
+// entries returns the entries of the database, decrypted again first when
+// another program wrote the file, so that they are never out of date.
+func (u *unlockedDatabase) entries() ([]types.Entry, error) {
+	entries, reloaded, err := u.vault.Reload()
+	if errors.Is(err, keepass.ErrVaultClosed) {
+		return nil, err
+	} else if err != nil {
+		log.Printf("failed to reload %s: %v", u.database.Name, err)
+
+		return u.vault.Entries()
+	}
+
+	if reloaded {
+		u.revision++
+	}
+
+	return entries, nil
+}
+
+func (u *unlockedDatabase) changeMasterKey(request Request) error {
+	if request.KDF == nil {
+		return fmt.Errorf("%w: no KDF settings", errBadRequest)
+	}
+
+	credentials := keepass.Credentials{Password: request.Password, KeyFile: request.KeyFile}
+	if request.KeepPassword {
+		credentials.Password = nil
+	} else if credentials.Password == nil {
+		// An empty password is not sent
+		credentials.Password = []byte{}
+	}
+
+	err := u.vault.ChangeMasterKey(credentials, *request.KDF)
+	if err != nil {
+		return err
+	}
+
+	u.revision++
+
+	return nil
+}
+
+// changed records a new revision once a write succeeded.
+func (u *unlockedDatabase) changed(err error) {
+	if err == nil {
+		u.revision++
+	}
+}
+
+// get looks the entry up in the given database, or in all of them.
+func (s *Server) get(request Request) Response {
+	var databases []*unlockedDatabase
+
+	s.mu.Lock()
+	for _, unlocked := range s.unlocked {
+		if request.Database == "" || request.Database == unlocked.database.Path || request.Database == unlocked.database.Name {
+			databases = append(databases, unlocked)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(databases) == 0 {
+		if request.Database == "" {
+			return errorResponse(LockedCode, errors.New("no database is unlocked in the agent"))
+		}
+
+		return errorResponse(LockedCode, fmt.Errorf("%w: %s", errLocked, request.Database))
+	}
+
+	var entries []types.Entry
+
+	for _, unlocked := range databases {
+		unlocked.mu.Lock()
+		databaseEntries, err := unlocked.entries()
+		unlocked.mu.Unlock()
+
+		if err != nil {
+			return vaultErrorResponse(err)
+		}
+
+		entries = append(entries, tagEntries(databaseEntries, unlocked.database)...)
+	}
+
+	entry, err := search.Lookup(entries, request.Entry)
+	if err != nil {
+		return lookupErrorResponse(err)
+	}
+
+	field := request.Field
+	if field == "" {
+		field = "password"
+	}
+
+	value, err := search.FieldValue(entry, field)
+	if err != nil {
+		return lookupErrorResponse(err)
+	}
+
+	response := okResponse()
+	response.Value = value
+
+	return response
+}
+
+// lock locks the database with the given path or name, or all of them.
+func (s *Server) lock(database string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+
+	for path, unlocked := range s.unlocked {
+		if database == "" || database == path || database == unlocked.database.Name {
+			closeDatabase(unlocked)
+			delete(s.unlocked, path)
+
+			found = true
+		}
+	}
+
+	if database != "" && !found {
+		return fmt.Errorf("%w: %s", errLocked, database)
+	}
+
+	return nil
+}
+
+func (s *Server) lockWhenIdle(done <-chan struct{}) {
+	if s.idleLock <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(lockCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.lockIfIdle()
+		}
+	}
+}
+
+// lockIfIdle locks all databases when no request came for the idle timeout.
+func (s *Server) lockIfIdle() {
+	s.mu.Lock()
+	idle := s.idleLock > 0 && len(s.unlocked) > 0 && s.now().Sub(s.lastActivity) >= s.idleLock
+	s.mu.Unlock()
+
+	if idle {
+		log.Printf("locking databases after %s of inactivity", s.idleLock)
+
+		_ = s.lock("")
+	}
+}
+
+func closeDatabase(unlocked *unlockedDatabase) {
+	unlocked.vault.Close()
+}
+
+// tagEntries records the database the entries come from.
+func tagEntries(entries []types.Entry, database types.Database) []types.Entry {
+	for i := range entries {
+		entries[i].Database = database
+	}
+
+	return entries
+}
+
+func okResponse() Response {
+	return Response{
+		Version:   ProtocolVersion,
+		Error:     "",
+		Code:      "",
+		Databases: nil,
+		Value:     "",
+		Entries:   nil,
+		Entry:     nil,
+		Groups:    nil,
+		Info:      nil,
+		Data:      nil,
+		Revision:  0,
+		Changed:   false,
+	}
+}
+
+func errorResponse(code Code, err error) Response {
+	response := okResponse()
+	response.Code = code
+	response.Error = err.Error()
+
+	return response
+}
+
+func vaultErrorResponse(err error) Response {
+	switch {
+	case errors.Is(err, errBadRequest):
+		return errorResponse(BadRequestCode, err)
+	case errors.Is(err, keepass.ErrVaultClosed):
+		return errorResponse(LockedCode, err)
+	case errors.Is(err, keepass.ErrEntryNotFound), errors.Is(err, keepass.ErrVersionNotFound), errors.Is(err, keepass.ErrAttachmentNotFound):
+		return errorResponse(NotFoundCode, err)
+	default:
+		return errorResponse(FailedCode, err)
+	}
+}
+
+func lookupErrorResponse(err error) Response {
+	switch {
+	case errors.Is(err, search.ErrNotFound):
+		return errorResponse(NotFoundCode, err)
+	case errors.Is(err, search.ErrAmbiguous):
+		return errorResponse(AmbiguousCode, err)
+	default:
+		return errorResponse(FailedCode, err)
+	}
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// Vault reads and writes a database the agent holds, like keepass.Vault does
+// for one unlocked in memory, without its master key leaving the agent.
+type Vault struct {
+	client   *Client
+	database types.Database
+	// revision is the last revision of the database read from the agent
+	revision atomic.Uint64
+}
+
+// Vault returns the database as held by the agent. Its operations fail with
+// ErrLocked once the agent no longer holds it.
+func (c *Client) Vault(database types.Database) *Vault {
+	return &Vault{client: c, database: database, revision: atomic.Uint64{}}
+}
+
+// Entries returns the entries of the database.
+func (v *Vault) Entries() ([]types.Entry, error) {
+	response, err := v.call(Request{Op: EntriesOp}) //nolint:exhaustruct // Only the operation
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Entries, nil
+}
+
+// Groups returns the group tree of the database.
+func (v *Vault) Groups() (types.Group, error) {
+	response, err := v.call(Request{Op: GroupsOp}) //nolint:exhaustruct // Only the operation
+	if err != nil || response.Groups == nil {
+		return types.Group{}, err //nolint:exhaustruct // No group
+	}
+
+	return *response.Groups, nil
+}
+
+// Info describes the database and how it is encrypted.
+func (v *Vault) Info() (keepass.Info, error) {
+	response, err := v.call(Request{Op: InfoOp}) //nolint:exhaustruct // Only the operation
+	if err != nil || response.Info == nil {
+		return keepass.Info{}, err //nolint:exhaustruct // No database
+	}
+
+	return *response.Info, nil
+}
+
+// Attachment returns the content of an attachment of the entry.
+func (v *Vault) Attachment(uuid gokeepasslib.UUID, name string) ([]byte, error) {
+	response, err := v.call(Request{Op: AttachmentOp, UUID: uuid, Attachment: name}) //nolint:exhaustruct // Only the attachment
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Data, nil
+}
+
+// SaveEntry creates the entry when isNew is set, or updates it, then saves the
+// database. It returns the saved entry and all entries.
+func (v *Vault) SaveEntry(entry types.Entry, isNew bool) (types.Entry, []types.Entry, error) {
+	response, err := v.call(Request{Op: SaveEntryOp, Saved: &entry, New: isNew}) //nolint:exhaustruct // Only the entry
+	if err != nil || response.Entry == nil {
+		return entry, nil, err
+	}
+
+	return *response.Entry, response.Entries, nil
+}
+
+// RestoreVersion makes the version of the entry modified at the given time its
+// current one, then saves the database. It returns the restored entry and all
+// entries.
+func (v *Vault) RestoreVersion(uuid gokeepasslib.UUID, modified time.Time) (types.Entry, []types.Entry, error) {
+	response, err := v.call(Request{Op: RestoreOp, UUID: uuid, Modified: modified}) //nolint:exhaustruct // Only the version
+	if err != nil || response.Entry == nil {
+		return types.Entry{}, nil, err //nolint:exhaustruct // No entry
+	}
+
+	return *response.Entry, response.Entries, nil
+}
+
+// DeleteEntry deletes the entry, then saves the database. It returns the
+// remaining entries.
+func (v *Vault) DeleteEntry(uuid gokeepasslib.UUID) ([]types.Entry, error) {
+	response, err := v.call(Request{Op: DeleteEntryOp, UUID: uuid}) //nolint:exhaustruct // Only the entry
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Entries, nil
+}
+
+// ChangeMasterKey re-encrypts the database with new credentials and KDF
+// settings. A nil password keeps the current one.
+func (v *Vault) ChangeMasterKey(credentials keepass.Credentials, kdf keepass.KDF) error {
+	_, err := v.call(Request{ //nolint:exhaustruct // Only the master key
+		Op:           ChangeMasterKeyOp,
+		Password:     credentials.Password,
+		KeyFile:      credentials.KeyFile,
+		KeepPassword: credentials.Password == nil,
+		KDF:          &kdf,
+	})
+
+	return err
+}
+
+// Changed reports whether the database changed since it was last read, by
+// another program or another client of the agent.
+func (v *Vault) Changed() (bool, error) {
+	response, err := v.call(Request{Op: ChangedOp, Revision: v.revision.Load()}) //nolint:exhaustruct // Only the revision
+	if err != nil {
+		return false, err
+	}
+
+	return response.Changed, nil
+}
+
+// Reload has the agent decrypt the file again when another program wrote it,
+// reporting whether the database changed since it was last read, and returns
+// the entries.
+func (v *Vault) Reload() ([]types.Entry, bool, error) {
+	response, err := v.call(Request{Op: ReloadOp, Revision: v.revision.Load()}) //nolint:exhaustruct // Only the revision
+	if err != nil {
+		return nil, false, err
+	}
+
+	return response.Entries, response.Changed, nil
+}
+
+// Close leaves the database unlocked in the agent, which locks it when idle.
+func (v *Vault) Close() {}
+
+func (v *Vault) call(request Request) (Response, error) {
+	request.Version = ProtocolVersion
+	request.Database = v.database.Path
+
+	response, err := v.client.call(request)
+	if err != nil {
+		return response, err
+	}
+
+	// The entries read are those of the revision
+	switch request.Op {
+	case EntriesOp, SaveEntryOp, RestoreOp, DeleteEntryOp, ReloadOp:
+		v.revision.Store(response.Revision)
+	default:
+	}
+
+	return response, nil
+}
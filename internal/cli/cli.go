@@ -0,0 +1,534 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/agent"
+	"github.com/martinlehoux/kagapass/internal/config"
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/search"
+	"github.com/martinlehoux/kagapass/internal/secretstore"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/models"
+)
+
+// Exit codes, stable for use in scripts.
+const (
+	ExitOK        = 0
+	ExitError     = 1
+	ExitUsage     = 2
+	ExitNotFound  = 3
+	ExitLocked    = 4
+	ExitAmbiguous = 5
+)
+
+const usage = `Usage:
+  kagapass                                     Start the interactive interface
+  kagapass get <db> <entry> [--field password]  Print a field of an entry
+  kagapass ls <db> [group]                     List entries, optionally within a group
+  kagapass show <db> <entry> [--reveal]        Print an entry
+  kagapass merge <db> <other>                  Merge the other database into db and save it
+  kagapass agent                               Run the agent, keeping databases unlocked
+  kagapass agent get <entry> [--field password] [--db <db>]
+                                               Print a field of an entry from the agent
+  kagapass agent status                        List the databases unlocked in the agent
+  kagapass agent lock [<db>]                   Lock databases in the agent
+
+<db> is the name or path of a configured database, <entry> is either the
+entry title or its full path (Group/Subgroup/Title). Databases are unlocked
+with the password stored in the keyring by the interactive interface.
+"merge" unlocks the other database with the password of db when none is
+stored for it, as for a copy left by a file synchronisation conflict.
+
+While the agent runs, the interactive interface hands it the databases it
+unlocks, which "agent get" then reads without decrypting them again.
+
+Exit codes:
+  1  error
+  2  invalid usage
+  3  database, entry or field not found
+  4  database locked (no stored password, or not unlocked in the agent)
+  5  ambiguous entry
+`
+
+var (
+	errNotFound  = search.ErrNotFound
+	errAmbiguous = search.ErrAmbiguous
+	errUsage     = errors.New("invalid usage")
+)
+
+// CLI runs the non-interactive subcommands.
+type CLI struct {
+	databases      types.DatabaseList
+	unlockDatabase *models.UnlockDatabase
+	keepassLoader  *keepass.Loader
+	// idleLock is how long the agent keeps databases without requests
+	idleLock    time.Duration
+	agentSocket string
+	stdout      io.Writer
+	stderr      io.Writer
+}
+
+// New creates a CLI using the configured databases and the keyring.
+func New(stdout io.Writer, stderr io.Writer) (*CLI, error) {
+	configMgr, err := config.New()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := configMgr.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	databases, err := configMgr.LoadDatabaseList()
+	if err != nil {
+		return nil, err
+	}
+
+	keepassLoader := keepass.NewLoader(keepass.RootFS())
+
+	// Without a secret store every database is reported as locked
+	secretStore, err := secretstore.Open(cfg.SecretStore, secretstore.OptionsFromEnv(configMgr.Dir()))
+	if err != nil {
+		secretStore = nil
+	} else if err := secretstore.MoveKeys(secretStore, configMgr.MigratedPaths()); err != nil {
+		fmt.Fprintf(stderr, "kagapass: failed to move passwords to the absolute path of their database: %v\n", err)
+	}
+
+	return &CLI{
+		databases:      databases,
+		unlockDatabase: models.NewUnlockDatabase(keepassLoader, secretStore, time.Duration(cfg.SessionTimeoutHours)*time.Hour),
+		keepassLoader:  keepassLoader,
+		idleLock:       time.Duration(cfg.IdleLockMinutes) * time.Minute,
+		agentSocket:    agent.SocketPath(),
+		stdout:         stdout,
+		stderr:         stderr,
+	}, nil
+}
+
+// Main runs the subcommand in args and returns the process exit code.
+func Main(args []string) int {
+	cli, err := New(os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kagapass: %v\n", err)
+
+		return ExitError
+	}
+
+	return cli.Run(args)
+}
+
+// Run runs the subcommand in args and returns the process exit code.
+func (c *CLI) Run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(c.stderr, usage)
+
+		return ExitUsage
+	}
+
+	var err error
+
+	switch args[0] {
+	case "get":
+		err = c.get(args[1:])
+	case "ls":
+		err = c.ls(args[1:])
+	case "show":
+		err = c.show(args[1:])
+	case "merge":
+		err = c.merge(args[1:])
+	case "agent":
+		err = c.agent(args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(c.stdout, usage)
+
+		return ExitOK
+	default:
+		err = fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+
+	if err == nil {
+		return ExitOK
+	}
+
+	fmt.Fprintf(c.stderr, "kagapass: %v\n", err)
+
+	switch {
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		fmt.Fprint(c.stderr, usage)
+
+		return ExitUsage
+	case errors.Is(err, errNotFound):
+		return ExitNotFound
+	case errors.Is(err, models.ErrNoStoredPassword), errors.Is(err, agent.ErrLocked):
+		return ExitLocked
+	case errors.Is(err, errAmbiguous):
+		return ExitAmbiguous
+	default:
+		return ExitError
+	}
+}
+
+func (c *CLI) get(args []string) error {
+	flags := newFlagSet("get")
+	field := flags.String("field", "password", "field to print: title, username, password, url, notes, totp or a custom field name")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 2 {
+		return fmt.Errorf("%w: get takes a database and an entry", errUsage)
+	}
+
+	entries, err := c.entries(positional[0])
+	if err != nil {
+		return err
+	}
+
+	entry, err := search.Lookup(entries, positional[1])
+	if err != nil {
+		return err
+	}
+
+	value, err := search.FieldValue(entry, *field)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, value)
+
+	return nil
+}
+
+func (c *CLI) ls(args []string) error {
+	positional, err := parseArgs(newFlagSet("ls"), args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) < 1 || len(positional) > 2 {
+		return fmt.Errorf("%w: ls takes a database and an optional group", errUsage)
+	}
+
+	entries, err := c.entries(positional[0])
+	if err != nil {
+		return err
+	}
+
+	group := ""
+	if len(positional) == 2 {
+		group = strings.Trim(positional[1], "/")
+	}
+
+	found := false
+
+	for _, entry := range entries {
+		if group == "" || entry.Group == group || strings.HasPrefix(entry.Group, group+"/") {
+			fmt.Fprintln(c.stdout, search.Path(entry))
+
+			found = true
+		}
+	}
+
+	if group != "" && !found {
+		return fmt.Errorf("%w: no entries in group %q", errNotFound, group)
+	}
+
+	return nil
+}
+
+func (c *CLI) show(args []string) error {
+	flags := newFlagSet("show")
+	reveal := flags.Bool("reveal", false, "print the password and protected fields")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 2 {
+		return fmt.Errorf("%w: show takes a database and an entry", errUsage)
+	}
+
+	entries, err := c.entries(positional[0])
+	if err != nil {
+		return err
+	}
+
+	entry, err := search.Lookup(entries, positional[1])
+	if err != nil {
+		return err
+	}
+
+	mask := func(value string, protected bool) string {
+		if protected && !*reveal && value != "" {
+			return strings.Repeat("*", 12)
+		}
+
+		return value
+	}
+
+	fmt.Fprintf(c.stdout, "Title: %s\n", entry.Title)
+	fmt.Fprintf(c.stdout, "Group: %s\n", entry.Group)
+	fmt.Fprintf(c.stdout, "Username: %s\n", entry.Username)
+	fmt.Fprintf(c.stdout, "Password: %s\n", mask(entry.Password, true))
+	fmt.Fprintf(c.stdout, "URL: %s\n", entry.URL)
+
+	for _, field := range entry.Fields {
+		fmt.Fprintf(c.stdout, "%s: %s\n", field.Key, mask(field.Value, field.Protected))
+	}
+
+	if entry.Notes != "" {
+		fmt.Fprintf(c.stdout, "Notes:\n%s\n", entry.Notes)
+	}
+
+	return nil
+}
+
+// merge merges the second database into the first one, as KeePass
+// synchronises databases, and saves the first one.
+func (c *CLI) merge(args []string) error {
+	positional, err := parseArgs(newFlagSet("merge"), args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 2 {
+		return fmt.Errorf("%w: merge takes the database to merge into and the database to merge", errUsage)
+	}
+
+	target, err := c.unlock(positional[0])
+	if err != nil {
+		return err
+	}
+	defer c.close(target)
+
+	source, err := c.unlock(positional[1])
+	if errors.Is(err, models.ErrNoStoredPassword) {
+		source, err = target.LoadWithSameCredentials(c.findDatabase(positional[1]).Path)
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("database %s: %w", positional[1], errNotFound)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	defer c.close(source)
+
+	err = target.Merge(source)
+	if err != nil {
+		return err
+	}
+
+	return target.MergeAndSave()
+}
+
+// agent runs the agent, or one of its subcommands.
+func (c *CLI) agent(args []string) error {
+	if len(args) == 0 {
+		return c.runAgent()
+	}
+
+	switch args[0] {
+	case "get":
+		return c.agentGet(args[1:])
+	case "status":
+		return c.agentStatus(args[1:])
+	case "lock":
+		return c.agentLock(args[1:])
+	default:
+		return fmt.Errorf("%w: unknown agent command %q", errUsage, args[0])
+	}
+}
+
+// runAgent serves the agent socket until interrupted.
+func (c *CLI) runAgent() error {
+	listener, err := agent.Listen(c.agentSocket)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	fmt.Fprintf(c.stderr, "kagapass: agent listening on %s\n", c.agentSocket)
+
+	return agent.NewServer(c.keepassLoader, c.idleLock).Serve(listener)
+}
+
+func (c *CLI) agentGet(args []string) error {
+	flags := newFlagSet("agent get")
+	field := flags.String("field", "password", "field to print: title, username, password, url, notes, totp or a custom field name")
+	database := flags.String("db", "", "name or path of the database, all unlocked ones when empty")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: agent get takes an entry", errUsage)
+	}
+
+	client, err := agent.Dial(c.agentSocket)
+	if err != nil {
+		return err
+	}
+
+	value, err := client.Get(*database, positional[0], *field)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, value)
+
+	return nil
+}
+
+func (c *CLI) agentStatus(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: agent status takes no argument", errUsage)
+	}
+
+	client, err := agent.Dial(c.agentSocket)
+	if err != nil {
+		return err
+	}
+
+	databases, err := client.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		fmt.Fprintf(c.stdout, "%s\t%s\n", database.Name, database.Path)
+	}
+
+	return nil
+}
+
+func (c *CLI) agentLock(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%w: agent lock takes an optional database", errUsage)
+	}
+
+	client, err := agent.Dial(c.agentSocket)
+	if err != nil {
+		return err
+	}
+
+	database := ""
+	if len(args) == 1 {
+		database = args[0]
+	}
+
+	return client.Lock(database)
+}
+
+// entries unlocks the database with its stored password and returns its entries.
+func (c *CLI) entries(name string) ([]types.Entry, error) {
+	unlocked, err := c.unlock(name)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close(unlocked)
+
+	return unlocked.Entries()
+}
+
+// unlock unlocks the database with its stored password.
+func (c *CLI) unlock(name string) (*keepass.KeePass, error) {
+	database := c.findDatabase(name)
+
+	switch msg := c.unlockDatabase.Handle(database, []byte{})().(type) {
+	case models.DatabaseUnlocked:
+		return msg.KeePass, nil
+	case models.DatabaseUnlockFailed:
+		if errors.Is(msg.Error, models.ErrNoStoredPassword) {
+			return nil, fmt.Errorf("database %s is locked, unlock it once in the interactive interface: %w", database.Name, msg.Error)
+		}
+
+		if errors.Is(msg.Error, os.ErrNotExist) {
+			return nil, fmt.Errorf("database %s: %w", database.Path, errNotFound)
+		}
+
+		return nil, msg.Error
+	default:
+		return nil, fmt.Errorf("unexpected unlock result %T", msg)
+	}
+}
+
+func (c *CLI) close(unlocked *keepass.KeePass) {
+	err := unlocked.Close()
+	if err != nil {
+		fmt.Fprintf(c.stderr, "kagapass: failed to close database: %v\n", err)
+	}
+}
+
+// findDatabase returns the configured database with the given name or path,
+// or an unconfigured one for the path.
+func (c *CLI) findDatabase(name string) types.Database {
+	path := name
+	// A missing file is reported by the unlock
+	if canonical, err := keepass.CanonicalPath(name); err == nil {
+		path = canonical
+	}
+
+	for _, database := range c.databases.Databases {
+		if database.Name == name || database.Path == name || database.Path == path {
+			return database
+		}
+	}
+
+	return types.Database{
+		Name:         filepath.Base(path),
+		Path:         path,
+		LastAccessed: time.Time{},
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	return flags
+}
+
+// parseArgs parses flags placed anywhere between the positional arguments.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errUsage, err)
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,246 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/martinlehoux/kagapass/internal/agent"
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/secretstore"
+	"github.com/martinlehoux/kagapass/internal/testor"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/models"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+const testPassword = "supersecret"
+
+// newTestCLI writes a database with Mail/Gmail, Work/Gmail and Work/Infra/AWS
+// entries and returns a CLI that can unlock it as "test".
+func newTestCLI(t *testing.T, storePassword bool) (*CLI, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	infra := testor.NewGroup("Infra", testor.NewEntry("AWS", "admin", "awspass",
+		gokeepasslib.ValueData{Key: "Account ID", Value: gokeepasslib.V{Content: "123456789012"}},
+	))
+
+	work := testor.NewGroup("Work", testor.NewEntry("Gmail", "work@gmail.com", "workpass"))
+	work.Groups = append(work.Groups, infra)
+
+	root := testor.NewGroup("Root")
+	root.Groups = append(root.Groups, testor.NewGroup("Mail", testor.NewEntry("Gmail", "personal@gmail.com", "mailpass")), work)
+
+	testor.WriteDatabase(t, filepath.Join(dir, "test.kdbx"), testor.NewDatabase(gokeepasslib.NewPasswordCredentials(testPassword), root))
+
+	secretStore := secretstore.NewMemory()
+	if storePassword {
+		if err := secretStore.Store("test.kdbx", []byte(testPassword)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	loader := keepass.NewLoader(keepass.DirFS(dir))
+
+	return &CLI{
+		databases: types.DatabaseList{
+			Databases: []types.Database{{Name: "test", Path: "test.kdbx"}},
+		},
+		unlockDatabase: models.NewUnlockDatabase(loader, secretStore, 0),
+		keepassLoader:  loader,
+		idleLock:       0,
+		agentSocket:    filepath.Join(dir, "agent", "agent.sock"),
+		stdout:         stdout,
+		stderr:         stderr,
+	}, stdout, stderr
+}
+
+func TestGet(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, true)
+
+	if code := cli.Run([]string{"get", "test", "Work/Infra/AWS"}); code != ExitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", ExitOK, code, stderr)
+	}
+
+	if stdout.String() != "awspass\n" {
+		t.Errorf("Expected password, got %q", stdout.String())
+	}
+
+	stdout.Reset()
+
+	if code := cli.Run([]string{"get", "test", "AWS", "--field", "Account ID"}); code != ExitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", ExitOK, code, stderr)
+	}
+
+	if stdout.String() != "123456789012\n" {
+		t.Errorf("Expected custom field, got %q", stdout.String())
+	}
+}
+
+func TestGetExitCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		stored   bool
+		exitCode int
+	}{
+		{"ambiguous title", []string{"get", "test", "Gmail"}, true, ExitAmbiguous},
+		{"unknown entry", []string{"get", "test", "GitHub"}, true, ExitNotFound},
+		{"unknown field", []string{"get", "test", "AWS", "--field", "otp"}, true, ExitNotFound},
+		{"no stored password", []string{"get", "test", "AWS"}, false, ExitLocked},
+		{"missing argument", []string{"get", "test"}, true, ExitUsage},
+		{"unknown command", []string{"rm", "test"}, true, ExitUsage},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cli, _, _ := newTestCLI(t, test.stored)
+
+			if code := cli.Run(test.args); code != test.exitCode {
+				t.Errorf("Expected exit code %d, got %d", test.exitCode, code)
+			}
+		})
+	}
+}
+
+func TestLs(t *testing.T) {
+	cli, stdout, _ := newTestCLI(t, true)
+
+	if code := cli.Run([]string{"ls", "test", "Work"}); code != ExitOK {
+		t.Fatalf("Expected exit code %d, got %d", ExitOK, code)
+	}
+
+	if stdout.String() != "Work/Gmail\nWork/Infra/AWS\n" {
+		t.Errorf("Unexpected listing: %q", stdout.String())
+	}
+
+	if code := cli.Run([]string{"ls", "test", "Personal"}); code != ExitNotFound {
+		t.Errorf("Expected exit code %d for unknown group, got %d", ExitNotFound, code)
+	}
+}
+
+func TestShow(t *testing.T) {
+	cli, stdout, _ := newTestCLI(t, true)
+
+	if code := cli.Run([]string{"show", "test", "Mail/Gmail"}); code != ExitOK {
+		t.Fatalf("Expected exit code %d, got %d", ExitOK, code)
+	}
+
+	if !strings.Contains(stdout.String(), "Username: personal@gmail.com") || strings.Contains(stdout.String(), "mailpass") {
+		t.Errorf("Expected masked entry, got %q", stdout.String())
+	}
+
+	stdout.Reset()
+	cli.Run([]string{"show", "--reveal", "test", "Mail/Gmail"})
+
+	if !strings.Contains(stdout.String(), "Password: mailpass") {
+		t.Errorf("Expected revealed password, got %q", stdout.String())
+	}
+}
+
+func TestAgentGet(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, false)
+
+	if code := cli.Run([]string{"agent", "get", "AWS"}); code != ExitError {
+		t.Errorf("Expected exit code %d without agent, got %d", ExitError, code)
+	}
+
+	listener, err := agent.Listen(cli.agentSocket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	go agent.NewServer(cli.keepassLoader, 0).Serve(listener) //nolint:errcheck // Stopped by closing the listener
+	defer listener.Close()
+
+	if code := cli.Run([]string{"agent", "get", "AWS"}); code != ExitLocked {
+		t.Errorf("Expected exit code %d before unlocking, got %d", ExitLocked, code)
+	}
+
+	client, err := agent.Dial(cli.agentSocket)
+	if err != nil {
+		t.Fatalf("Failed to dial agent: %v", err)
+	}
+
+	err = client.Unlock(types.Database{Name: "test", Path: "test.kdbx"}, keepass.PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Failed to unlock in agent: %v", err)
+	}
+
+	stdout.Reset()
+
+	if code := cli.Run([]string{"agent", "get", "Work/Infra/AWS", "--db", "test", "--field", "username"}); code != ExitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", ExitOK, code, stderr)
+	}
+
+	if stdout.String() != "admin\n" {
+		t.Errorf("Expected username, got %q", stdout.String())
+	}
+
+	if code := cli.Run([]string{"agent", "get", "Gmail"}); code != ExitAmbiguous {
+		t.Errorf("Expected exit code %d, got %d", ExitAmbiguous, code)
+	}
+
+	if code := cli.Run([]string{"agent", "get", "Unknown"}); code != ExitNotFound {
+		t.Errorf("Expected exit code %d, got %d", ExitNotFound, code)
+	}
+
+	stdout.Reset()
+
+	if code := cli.Run([]string{"agent", "status"}); code != ExitOK || stdout.String() != "test\ttest.kdbx\n" {
+		t.Errorf("Expected test to be listed, got %d: %q", code, stdout.String())
+	}
+
+	if code := cli.Run([]string{"agent", "lock"}); code != ExitOK {
+		t.Errorf("Expected exit code %d, got %d: %s", ExitOK, code, stderr)
+	}
+
+	if code := cli.Run([]string{"agent", "get", "AWS"}); code != ExitLocked {
+		t.Errorf("Expected exit code %d after locking, got %d", ExitLocked, code)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	cli, _, stderr := newTestCLI(t, true)
+	dir := filepath.Dir(filepath.Dir(cli.agentSocket))
+	loader := keepass.NewLoader(keepass.DirFS(dir))
+
+	// A synchronisation conflict left a copy with a new entry
+	data, err := os.ReadFile(filepath.Join(dir, "test.kdbx"))
+	if err != nil {
+		t.Fatalf("Failed to read database: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "conflict.kdbx"), data, 0o600); err != nil {
+		t.Fatalf("Failed to copy database: %v", err)
+	}
+
+	conflict, _ := loader.Load("conflict.kdbx", keepass.PasswordCredentials([]byte(testPassword)))
+	if _, err := conflict.CreateEntry(types.Entry{Title: "Bank", Group: "Finance"}); err != nil {
+		t.Fatalf("CreateEntry() failed: %v", err)
+	}
+
+	if err := conflict.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	if code := cli.Run([]string{"merge", "test", "conflict.kdbx"}); code != ExitOK {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	stdout := cli.stdout.(*bytes.Buffer)
+	if code := cli.Run([]string{"get", "test", "Finance/Bank", "--field", "title"}); code != ExitOK || stdout.String() != "Bank\n" {
+		t.Errorf("Expected the merged entry, got %d: %s", code, stderr.String())
+	}
+
+	if code := cli.Run([]string{"merge", "test", "missing.kdbx"}); code != ExitNotFound {
+		t.Errorf("Expected exit code 3 for a missing database, got %d", code)
+	}
+}
@@ -2,28 +2,131 @@ package clipboard
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
 	"time"
+)
+
+// Selection is the clipboard text is copied to.
+type Selection string
+
+const (
+	// ClipboardSelection is the clipboard pasted with Ctrl+V.
+	ClipboardSelection Selection = "clipboard"
+	// PrimarySelection is the X11 and Wayland selection pasted with the
+	// middle button.
+	PrimarySelection Selection = "primary"
+)
 
-	"github.com/atotto/clipboard"
+const (
+	// AutoBackend copies with the clipboard tools found, see Detect.
+	AutoBackend = "auto"
+	// X11Backend owns the X11 selections in process, so that sensitive copies
+	// are hidden from clipboard managers.
+	X11Backend = "x11"
 )
 
-// Clipboard handles clipboard operations with auto-clearing.
+// Backend writes to and reads from a clipboard.
+type Backend interface {
+	// Write replaces the content of the selection. Sensitive content is marked
+	// so that clipboard managers do not record it, as far as the backend can.
+	Write(selection Selection, text string, sensitive bool) error
+	Read(selection Selection) (string, error)
+}
+
+// Clipboard handles clipboard operations with auto-clearing. Everything it
+// copies is marked sensitive.
 type Clipboard struct {
+	backend    Backend
+	selection  Selection
 	clearTimer *time.Timer
+	// runClear runs the clears once due, see RunClearsWith
+	runClear func(clear func())
 }
 
-// New creates a new clipboard manager.
+// New creates a new clipboard manager on the detected backend, see Detect.
 func New() *Clipboard {
+	return NewWithBackend(Detect(), ClipboardSelection)
+}
+
+// NewWithBackend creates a new clipboard manager copying to the selection of
+// the backend.
+func NewWithBackend(backend Backend, selection Selection) *Clipboard {
 	return &Clipboard{
+		backend:    backend,
+		selection:  selection,
 		clearTimer: nil,
+		runClear:   func(clear func()) { clear() },
 	}
 }
 
+// RunClearsWith has run call the clears once due, instead of clearing from the
+// timer goroutine. A program writing to the terminal, which the OSC 52 backend
+// also writes to, runs them in its own event loop.
+func (m *Clipboard) RunClearsWith(run func(clear func())) {
+	m.runClear = run
+}
+
+// Open returns the backend selected by name, see AutoBackend and X11Backend.
+// When it cannot be used, the detected backend is returned with the error.
+func Open(name string) (Backend, error) {
+	switch name {
+	case AutoBackend, "":
+		return Detect(), nil
+	case X11Backend:
+		x11, err := NewX11()
+		if err != nil {
+			return Detect(), fmt.Errorf("failed to connect to the X server: %w", err)
+		}
+
+		return x11, nil
+	default:
+		return Detect(), fmt.Errorf("unknown clipboard backend %q", name)
+	}
+}
+
+// Detect returns the backend of the local clipboard: wl-copy on Wayland, then
+// xclip or xsel. Over SSH, or when none is available, text is copied through
+// the terminal with OSC 52.
+func Detect() Backend {
+	if os.Getenv("SSH_TTY") == "" {
+		if os.Getenv("WAYLAND_DISPLAY") != "" && WlCopy().Available() {
+			return WlCopy()
+		}
+
+		for _, backend := range []*Command{Xclip(), Xsel()} {
+			if backend.Available() {
+				return backend
+			}
+		}
+	}
+
+	terminal, err := openTerminal()
+	if err != nil {
+		return unavailable{err: err}
+	}
+
+	return NewOSC52(terminal)
+}
+
+// unavailable is used without clipboard tool nor terminal.
+type unavailable struct {
+	err error
+}
+
+func (u unavailable) Write(Selection, string, bool) error {
+	return fmt.Errorf("no clipboard tool is installed and no terminal is available: %w", u.err)
+}
+
+func (u unavailable) Read(selection Selection) (string, error) {
+	return "", u.Write(selection, "", false)
+}
+
 // Copy copies text to clipboard and sets up auto-clearing.
 func (m *Clipboard) Copy(text string, clearAfter time.Duration) error {
 	// Copy to clipboard
-	err := clipboard.WriteAll(text)
+	err := m.backend.Write(m.selection, text, true)
 	if err != nil {
 		return err
 	}
@@ -36,13 +139,15 @@ func (m *Clipboard) Copy(text string, clearAfter time.Duration) error {
 	// Set up auto-clear timer
 	if clearAfter > 0 {
 		m.clearTimer = time.AfterFunc(clearAfter, func() {
-			// Check if clipboard still contains our text before clearing
-			if current, err := clipboard.ReadAll(); err == nil && current == text {
-				err := clipboard.WriteAll("")
-				if err != nil {
-					log.Printf("Failed to clear clipboard: %v", err)
+			m.runClear(func() {
+				// Check if clipboard still contains our text before clearing
+				if current, err := m.backend.Read(m.selection); err == nil && current == text {
+					err := m.backend.Write(m.selection, "", false)
+					if err != nil {
+						log.Printf("Failed to clear clipboard: %v", err)
+					}
 				}
-			}
+			})
 		})
 	}
 
@@ -62,8 +167,8 @@ func (m *Clipboard) CopyWithContext(ctx context.Context, text string, clearAfter
 			select {
 			case <-ctx.Done():
 				// Context cancelled, clear immediately
-				if current, err := clipboard.ReadAll(); err == nil && current == text {
-					err := clipboard.WriteAll("")
+				if current, err := m.backend.Read(m.selection); err == nil && current == text {
+					err := m.backend.Write(m.selection, "", false)
 					if err != nil {
 						log.Printf("Failed to clear clipboard: %v", err)
 					}
@@ -88,12 +193,12 @@ func (m *Clipboard) Clear() error {
 		m.clearTimer = nil
 	}
 
-	return clipboard.WriteAll("")
+	return m.backend.Write(m.selection, "", false)
 }
 
 // Get retrieves current clipboard content.
 func (m *Clipboard) Get() (string, error) {
-	return clipboard.ReadAll()
+	return m.backend.Read(m.selection)
 }
 
 // StopAutoClearing cancels any pending auto-clear timer.
@@ -1,8 +1,16 @@
 package clipboard
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
 )
 
 func TestCopyWithoutClearTime(t *testing.T) {
@@ -190,3 +198,200 @@ func TestCopyAndGet(t *testing.T) {
 		t.Errorf("Expected '%s', got '%s'", testContent, retrieved)
 	}
 }
+
+func TestOSC52(t *testing.T) {
+	t.Setenv("TMUX", "")
+	t.Setenv("TERM", "xterm-256color")
+
+	var terminal bytes.Buffer
+
+	manager := NewWithBackend(NewOSC52(&terminal), ClipboardSelection)
+
+	// The clear is written by the test goroutine, like by the event loop of the interface
+	clears := make(chan func(), 1)
+	manager.RunClearsWith(func(clear func()) { clears <- clear })
+
+	if err := manager.Copy("s3cret", 50*time.Millisecond); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+
+	if terminal.String() != "\x1b]52;c;czNjcmV0\x07" {
+		t.Errorf("Expected the OSC 52 sequence, got %q", terminal.String())
+	}
+
+	// The terminal clipboard cannot be read, the copied text is remembered
+	if current, err := manager.Get(); err != nil || current != "s3cret" {
+		t.Errorf("Expected the copied text, got '%s', %v", current, err)
+	}
+
+	terminal.Reset()
+
+	select {
+	case clear := <-clears:
+		clear()
+	case <-time.After(time.Second):
+		t.Fatal("Expected the clear to be due")
+	}
+
+	if terminal.String() != "\x1b]52;c;!\x07" {
+		t.Errorf("Expected the clipboard to be cleared, got %q", terminal.String())
+	}
+}
+
+func TestOSC52Tmux(t *testing.T) {
+	t.Setenv("TMUX", "/tmp/tmux-1000/default,1234,0")
+
+	var terminal bytes.Buffer
+
+	if err := NewOSC52(&terminal).Write(ClipboardSelection, "s3cret", true); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+
+	if terminal.String() != "\x1bPtmux;\x1b\x1b]52;c;czNjcmV0\x07\x1b\\" {
+		t.Errorf("Expected the sequence passed through tmux, got %q", terminal.String())
+	}
+}
+
+func TestMemoryPrimarySelection(t *testing.T) {
+	backend := NewMemory()
+	manager := NewWithBackend(backend, PrimarySelection)
+
+	if err := manager.Copy("s3cret", 50*time.Millisecond); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+
+	if text, _ := backend.Read(PrimarySelection); text != "s3cret" || !backend.Sensitive(PrimarySelection) {
+		t.Errorf("Expected sensitive text in the primary selection, got '%s'", text)
+	}
+
+	if _, err := backend.Read(ClipboardSelection); !errors.Is(err, ErrEmpty) {
+		t.Errorf("Expected the clipboard to be left alone, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if text, _ := backend.Read(PrimarySelection); text != "" {
+		t.Errorf("Expected the primary selection to be cleared, got '%s'", text)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	record := filepath.Join(dir, "record")
+
+	// The fake xclip records its arguments and input
+	script := "#!/bin/sh\nexec >> " + record + "\necho \"$@\"\n" +
+		"while IFS= read -r line || [ -n \"$line\" ]; do printf %s \"$line\"; done\n"
+	if err := os.WriteFile(filepath.Join(dir, "xclip"), []byte(script), 0o700); err != nil {
+		t.Fatalf("Failed to write fake xclip: %v", err)
+	}
+
+	t.Setenv("PATH", dir)
+
+	backend := Xclip()
+	if !backend.Available() {
+		t.Fatal("Expected the fake xclip to be found")
+	}
+
+	if err := backend.Write(PrimarySelection, "s3cret", true); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(record)
+	if string(content) != "-in -selection primary\ns3cret" {
+		t.Errorf("Expected xclip to copy the text to the primary selection, got %q", content)
+	}
+
+	if Xsel().Available() {
+		t.Error("Expected xsel not to be found")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "xclip"), []byte("#!/bin/sh\n"), 0o700); err != nil {
+		t.Fatalf("Failed to write fake xclip: %v", err)
+	}
+
+	t.Setenv("PATH", dir)
+	t.Setenv("SSH_TTY", "")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	t.Setenv("DISPLAY", "")
+
+	for _, name := range []string{AutoBackend, ""} {
+		backend, err := Open(name)
+		if err != nil {
+			t.Errorf("Open(%q) failed: %v", name, err)
+		}
+
+		if _, ok := backend.(*Command); !ok {
+			t.Errorf("Expected Open(%q) to use xclip, got %T", name, backend)
+		}
+	}
+
+	for _, name := range []string{X11Backend, "klipper"} {
+		backend, err := Open(name)
+		if err == nil {
+			t.Errorf("Expected Open(%q) to fail", name)
+		}
+
+		if _, ok := backend.(*Command); !ok {
+			t.Errorf("Expected Open(%q) to fall back to xclip, got %T", name, backend)
+		}
+	}
+}
+
+func TestX11WriteTooLong(t *testing.T) {
+	x11 := &X11{maxWrite: 4, owned: map[xproto.Atom]x11Content{}}
+
+	if err := x11.Write(ClipboardSelection, "s3cret", true); err == nil {
+		t.Error("Expected text longer than a single request to be refused")
+	}
+
+	if len(x11.owned) != 0 {
+		t.Error("Expected refused text not to be offered")
+	}
+}
+
+func TestX11Convert(t *testing.T) {
+	atoms := x11Atoms{clipboard: 10, targets: 11, text: []xproto.Atom{12, xproto.AtomString}, hint: 13, property: 14}
+	targets := func(data []byte) []xproto.Atom {
+		var atoms []xproto.Atom
+		for i := 0; i+4 <= len(data); i += 4 {
+			atoms = append(atoms, xproto.Atom(xgb.Get32(data[i:])))
+		}
+
+		return atoms
+	}
+
+	sensitive := x11Content{text: "s3cret", sensitive: true}
+
+	kind, format, data, ok := atoms.convert(sensitive, atoms.targets)
+	if !ok || kind != xproto.AtomAtom || format != 32 || !slices.Contains(targets(data), atoms.hint) {
+		t.Errorf("Expected the targets of sensitive text to offer the password manager hint, got %v", targets(data))
+	}
+
+	if kind, _, data, ok := atoms.convert(sensitive, atoms.hint); !ok || kind != atoms.hint || string(data) != "secret" {
+		t.Errorf("Expected the hint to be secret, got '%s'", data)
+	}
+
+	for _, target := range atoms.text {
+		if _, format, data, ok := atoms.convert(sensitive, target); !ok || format != 8 || string(data) != "s3cret" {
+			t.Errorf("Expected target %d to convert to the text, got '%s'", target, data)
+		}
+	}
+
+	plain := x11Content{text: "octocat", sensitive: false}
+
+	if _, _, data, _ := atoms.convert(plain, atoms.targets); slices.Contains(targets(data), atoms.hint) {
+		t.Error("Expected text that is not sensitive not to offer the hint")
+	}
+
+	if _, _, _, ok := atoms.convert(plain, atoms.hint); ok {
+		t.Error("Expected text that is not sensitive not to convert to the hint")
+	}
+
+	if _, _, _, ok := atoms.convert(sensitive, 99); ok {
+		t.Error("Expected unknown targets to be refused")
+	}
+}
@@ -0,0 +1,133 @@
+package clipboard
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Command copies with a clipboard command line tool.
+type Command struct {
+	copyCommand  string
+	pasteCommand string
+	copyArgs     func(selection Selection) []string
+	pasteArgs    func(selection Selection) []string
+	// sensitiveFlag marks the content sensitive, used only when the installed
+	// version lists it in its help
+	sensitiveFlag string
+
+	once               sync.Once
+	sensitiveSupported bool
+}
+
+// WlCopy copies with wl-copy and pastes with wl-paste, on Wayland.
+func WlCopy() *Command {
+	primaryArgs := func(selection Selection, args ...string) []string {
+		if selection == PrimarySelection {
+			return append(args, "--primary")
+		}
+
+		return args
+	}
+
+	return &Command{
+		copyCommand:        "wl-copy",
+		pasteCommand:       "wl-paste",
+		copyArgs:           func(selection Selection) []string { return primaryArgs(selection) },
+		pasteArgs:          func(selection Selection) []string { return primaryArgs(selection, "--no-newline") },
+		sensitiveFlag:      "--sensitive",
+		once:               sync.Once{},
+		sensitiveSupported: false,
+	}
+}
+
+// Xclip copies and pastes with xclip, on X11.
+func Xclip() *Command {
+	return &Command{
+		copyCommand:        "xclip",
+		pasteCommand:       "xclip",
+		copyArgs:           func(selection Selection) []string { return []string{"-in", "-selection", xSelection(selection)} },
+		pasteArgs:          func(selection Selection) []string { return []string{"-out", "-selection", xSelection(selection)} },
+		sensitiveFlag:      "",
+		once:               sync.Once{},
+		sensitiveSupported: false,
+	}
+}
+
+// Xsel copies and pastes with xsel, on X11.
+func Xsel() *Command {
+	return &Command{
+		copyCommand:        "xsel",
+		pasteCommand:       "xsel",
+		copyArgs:           func(selection Selection) []string { return []string{"--input", "--" + xSelection(selection)} },
+		pasteArgs:          func(selection Selection) []string { return []string{"--output", "--" + xSelection(selection)} },
+		sensitiveFlag:      "",
+		once:               sync.Once{},
+		sensitiveSupported: false,
+	}
+}
+
+// Available tells whether the tool is installed.
+func (c *Command) Available() bool {
+	for _, command := range []string{c.copyCommand, c.pasteCommand} {
+		if _, err := exec.LookPath(command); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Write copies the text. Sensitive content is copied with the sensitive flag
+// of the tool when it has one, xclip and xsel cannot mark it.
+func (c *Command) Write(selection Selection, text string, sensitive bool) error {
+	args := c.copyArgs(selection)
+	if sensitive && c.supportsSensitive() {
+		args = append(args, c.sensitiveFlag)
+	}
+
+	// The tools keep running in the background to serve the selection, no
+	// output is read so as not to wait for them
+	cmd := exec.Command(c.copyCommand, args...)
+	cmd.Stdin = strings.NewReader(text)
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", c.copyCommand, err)
+	}
+
+	return nil
+}
+
+func (c *Command) Read(selection Selection) (string, error) {
+	output, err := exec.Command(c.pasteCommand, c.pasteArgs(selection)...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w", c.pasteCommand, err)
+	}
+
+	return string(output), nil
+}
+
+// supportsSensitive checks once whether the installed version of the tool
+// has the sensitive flag.
+func (c *Command) supportsSensitive() bool {
+	c.once.Do(func() {
+		if c.sensitiveFlag == "" {
+			return
+		}
+
+		help, _ := exec.Command(c.copyCommand, "--help").CombinedOutput()
+		c.sensitiveSupported = strings.Contains(string(help), c.sensitiveFlag)
+	})
+
+	return c.sensitiveSupported
+}
+
+func xSelection(selection Selection) string {
+	if selection == PrimarySelection {
+		return "primary"
+	}
+
+	return "clipboard"
+}
@@ -0,0 +1,55 @@
+package clipboard
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrEmpty is returned when reading a selection nothing was copied to.
+var ErrEmpty = errors.New("nothing is copied")
+
+// Memory keeps the selections in memory, for tests.
+type Memory struct {
+	mu        sync.Mutex
+	texts     map[Selection]string
+	sensitive map[Selection]bool
+}
+
+// NewMemory creates an empty in-memory clipboard.
+func NewMemory() *Memory {
+	return &Memory{
+		mu:        sync.Mutex{},
+		texts:     map[Selection]string{},
+		sensitive: map[Selection]bool{},
+	}
+}
+
+func (m *Memory) Write(selection Selection, text string, sensitive bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.texts[selection] = text
+	m.sensitive[selection] = sensitive
+
+	return nil
+}
+
+func (m *Memory) Read(selection Selection) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	text, ok := m.texts[selection]
+	if !ok {
+		return "", ErrEmpty
+	}
+
+	return text, nil
+}
+
+// Sensitive tells whether the content of the selection was marked sensitive.
+func (m *Memory) Sensitive(selection Selection) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sensitive[selection]
+}
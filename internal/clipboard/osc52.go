@@ -0,0 +1,79 @@
+package clipboard
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+)
+
+// OSC52 copies through the terminal with the OSC 52 escape sequence, which
+// works over SSH and without any clipboard tool. Terminals do not let programs
+// read their clipboard, so Read returns the text last written, and clearing
+// only works in terminals accepting an empty clipboard. Nothing tells the
+// terminal that content is sensitive.
+type OSC52 struct {
+	out  io.Writer
+	mode osc52.Mode
+
+	mu   sync.Mutex
+	last map[Selection]string
+}
+
+// NewOSC52 creates a backend writing escape sequences to the terminal,
+// wrapped for tmux or screen to pass them on.
+func NewOSC52(out io.Writer) *OSC52 {
+	mode := osc52.DefaultMode
+
+	switch {
+	case os.Getenv("TMUX") != "":
+		mode = osc52.TmuxMode
+	case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+		mode = osc52.ScreenMode
+	}
+
+	return &OSC52{
+		out:  out,
+		mode: mode,
+		mu:   sync.Mutex{},
+		last: map[Selection]string{},
+	}
+}
+
+func (o *OSC52) Write(selection Selection, text string, _ bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	sequence := osc52.New(text)
+	if text == "" {
+		sequence = osc52.Clear()
+	}
+
+	if selection == PrimarySelection {
+		sequence = sequence.Primary()
+	}
+
+	_, err := sequence.Mode(o.mode).WriteTo(o.out)
+	if err != nil {
+		return err
+	}
+
+	o.last[selection] = text
+
+	return nil
+}
+
+func (o *OSC52) Read(selection Selection) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.last[selection], nil
+}
+
+// openTerminal opens the controlling terminal, so that escape sequences reach
+// it whatever the outputs are redirected to.
+func openTerminal() (io.Writer, error) {
+	return os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+}
@@ -0,0 +1,342 @@
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// passwordManagerHint is the target KDE clipboard managers check: content
+// offered with it set to "secret" is not recorded in their history.
+const passwordManagerHint = "x-kde-passwordManagerHint"
+
+// x11ReadTimeout bounds the wait for the owner of a selection to send it.
+const x11ReadTimeout = time.Second
+
+// changePropertySize is the size of a ChangeProperty request without its
+// data, in bytes.
+const changePropertySize = 24
+
+// x11MaxRead is the longest selection read, in 32-bit units. Larger ones are
+// sent in increments, which reads do not support.
+const x11MaxRead = 1 << 20
+
+// X11 owns the X11 selections itself, the way xclip does in the background,
+// to offer the x-kde-passwordManagerHint target next to the text of sensitive
+// content. The command line tools offer a single target. The selections are
+// served while kagapass runs, and are empty once it exits. Content is sent in
+// a single request, without the incremental transfers of larger ones.
+type X11 struct {
+	conn   *xgb.Conn
+	window xproto.Window
+	atoms  x11Atoms
+	// maxWrite is the longest text sent in a single request, in bytes
+	maxWrite int
+
+	mu    sync.Mutex
+	owned map[xproto.Atom]x11Content
+
+	// readMu lets one read wait for its reply at a time
+	readMu  sync.Mutex
+	replies chan xproto.SelectionNotifyEvent
+}
+
+// x11Content is the text of a selection owned by kagapass.
+type x11Content struct {
+	text      string
+	sensitive bool
+}
+
+// x11Atoms identify the selections, targets and properties used.
+type x11Atoms struct {
+	clipboard xproto.Atom
+	// targets lists the targets of a selection
+	targets xproto.Atom
+	// text are the targets converting to the text, the first one is read
+	text []xproto.Atom
+	hint xproto.Atom
+	// property receives the selections read
+	property xproto.Atom
+}
+
+// NewX11 connects to the X server of $DISPLAY.
+func NewX11() (*X11, error) {
+	if os.Getenv("DISPLAY") == "" {
+		return nil, errors.New("DISPLAY is not set")
+	}
+
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+
+	x11 := &X11{
+		conn:     conn,
+		window:   0,
+		atoms:    x11Atoms{clipboard: 0, targets: 0, text: nil, hint: 0, property: 0},
+		maxWrite: 0,
+		mu:       sync.Mutex{},
+		owned:    map[xproto.Atom]x11Content{},
+		readMu:   sync.Mutex{},
+		replies:  make(chan xproto.SelectionNotifyEvent, 1),
+	}
+
+	err = x11.setup()
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	go x11.serve()
+
+	return x11, nil
+}
+
+// setup creates the invisible window owning the selections and interns the
+// atoms.
+func (x *X11) setup() error {
+	window, err := xproto.NewWindowId(x.conn)
+	if err != nil {
+		return err
+	}
+
+	setup := xproto.Setup(x.conn)
+	screen := setup.DefaultScreen(x.conn)
+	// The maximum request length is in 32-bit units
+	x.maxWrite = int(setup.MaximumRequestLength)*4 - changePropertySize
+
+	err = xproto.CreateWindowChecked(x.conn, 0, window, screen.Root, 0, 0, 1, 1, 0, xproto.WindowClassInputOnly, 0, 0, nil).Check()
+	if err != nil {
+		return fmt.Errorf("failed to create window: %w", err)
+	}
+
+	x.window = window
+
+	intern := func(name string) (xproto.Atom, error) {
+		reply, err := xproto.InternAtom(x.conn, false, uint16(len(name)), name).Reply()
+		if err != nil {
+			return 0, fmt.Errorf("failed to intern %s: %w", name, err)
+		}
+
+		return reply.Atom, nil
+	}
+
+	names := []string{"CLIPBOARD", "TARGETS", passwordManagerHint, "KAGAPASS_SELECTION", "UTF8_STRING", "text/plain;charset=utf-8", "text/plain", "TEXT"}
+	atoms := make([]xproto.Atom, len(names))
+
+	for i, name := range names {
+		atoms[i], err = intern(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	x.atoms = x11Atoms{
+		clipboard: atoms[0],
+		targets:   atoms[1],
+		hint:      atoms[2],
+		property:  atoms[3],
+		text:      append(atoms[4:], xproto.AtomString),
+	}
+
+	return nil
+}
+
+func (x *X11) Write(selection Selection, text string, sensitive bool) error {
+	atom := x.selection(selection)
+
+	if text == "" {
+		// An empty selection has no owner
+		x.mu.Lock()
+		delete(x.owned, atom)
+		x.mu.Unlock()
+
+		return xproto.SetSelectionOwnerChecked(x.conn, xproto.AtomNone, atom, xproto.TimeCurrentTime).Check()
+	}
+
+	if len(text) > x.maxWrite {
+		return fmt.Errorf("the text is longer than the %d bytes the X11 clipboard can send at once", x.maxWrite)
+	}
+
+	x.mu.Lock()
+	x.owned[atom] = x11Content{text: text, sensitive: sensitive}
+	x.mu.Unlock()
+
+	err := xproto.SetSelectionOwnerChecked(x.conn, x.window, atom, xproto.TimeCurrentTime).Check()
+	if err != nil {
+		return fmt.Errorf("failed to own the selection: %w", err)
+	}
+
+	owner, err := xproto.GetSelectionOwner(x.conn, atom).Reply()
+	if err != nil {
+		return err
+	}
+
+	if owner.Owner != x.window {
+		return errors.New("failed to own the selection")
+	}
+
+	return nil
+}
+
+// Read returns the text of the selection, asking its owner when it is not
+// kagapass.
+func (x *X11) Read(selection Selection) (string, error) {
+	atom := x.selection(selection)
+
+	x.mu.Lock()
+	content, owned := x.owned[atom]
+	x.mu.Unlock()
+
+	if owned {
+		return content.text, nil
+	}
+
+	owner, err := xproto.GetSelectionOwner(x.conn, atom).Reply()
+	if err != nil {
+		return "", err
+	}
+
+	if owner.Owner == xproto.AtomNone {
+		return "", nil
+	}
+
+	x.readMu.Lock()
+	defer x.readMu.Unlock()
+
+	// Drop the reply of a read that timed out
+	select {
+	case <-x.replies:
+	default:
+	}
+
+	err = xproto.ConvertSelectionChecked(x.conn, x.window, atom, x.atoms.text[0], x.atoms.property, xproto.TimeCurrentTime).Check()
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case reply := <-x.replies:
+		if reply.Property == xproto.AtomNone {
+			return "", errors.New("the selection has no text")
+		}
+	case <-time.After(x11ReadTimeout):
+		return "", errors.New("the owner of the selection did not send it")
+	}
+
+	property, err := xproto.GetProperty(x.conn, true, x.window, x.atoms.property, xproto.GetPropertyTypeAny, 0, x11MaxRead).Reply()
+	if err != nil {
+		return "", err
+	}
+
+	if property.BytesAfter > 0 {
+		return "", errors.New("the selection is too large")
+	}
+
+	return string(property.Value), nil
+}
+
+func (x *X11) selection(selection Selection) xproto.Atom {
+	if selection == PrimarySelection {
+		return xproto.AtomPrimary
+	}
+
+	return x.atoms.clipboard
+}
+
+// serve answers the requests for the selections until the connection closes.
+func (x *X11) serve() {
+	for {
+		event, err := x.conn.WaitForEvent()
+		if event == nil && err == nil {
+			return
+		} else if err != nil {
+			log.Printf("X11 clipboard error: %v", err)
+
+			continue
+		}
+
+		switch event := event.(type) {
+		case xproto.SelectionRequestEvent:
+			x.answer(event)
+		case xproto.SelectionClearEvent:
+			// Another program owns the selection
+			x.mu.Lock()
+			delete(x.owned, event.Selection)
+			x.mu.Unlock()
+		case xproto.SelectionNotifyEvent:
+			select {
+			case x.replies <- event:
+			default:
+			}
+		}
+	}
+}
+
+// answer converts the selection to the requested target, then notifies the
+// requestor.
+func (x *X11) answer(request xproto.SelectionRequestEvent) {
+	property := request.Property
+	if property == xproto.AtomNone {
+		// Obsolete clients expect the target as property
+		property = request.Target
+	}
+
+	x.mu.Lock()
+	content, owned := x.owned[request.Selection]
+	x.mu.Unlock()
+
+	kind, format, data, ok := x.atoms.convert(content, request.Target)
+	if !owned || !ok {
+		property = xproto.AtomNone
+	} else {
+		length := uint32(len(data)) / uint32(format/8)
+		xproto.ChangeProperty(x.conn, xproto.PropModeReplace, request.Requestor, property, kind, format, length, data)
+	}
+
+	notify := xproto.SelectionNotifyEvent{
+		Sequence:  0,
+		Time:      request.Time,
+		Requestor: request.Requestor,
+		Selection: request.Selection,
+		Target:    request.Target,
+		Property:  property,
+	}
+	xproto.SendEvent(x.conn, false, request.Requestor, xproto.EventMaskNoEvent, string(notify.Bytes()))
+}
+
+// convert returns the type, format and data of the content converted to the
+// target, or false when it cannot be.
+func (a x11Atoms) convert(content x11Content, target xproto.Atom) (xproto.Atom, byte, []byte, bool) {
+	switch {
+	case target == a.targets:
+		targets := append([]xproto.Atom{a.targets}, a.text...)
+		if content.sensitive {
+			targets = append(targets, a.hint)
+		}
+
+		data := make([]byte, 4*len(targets))
+		for i, atom := range targets {
+			xgb.Put32(data[4*i:], uint32(atom))
+		}
+
+		return xproto.AtomAtom, 32, data, true
+	case target == a.hint && content.sensitive:
+		return a.hint, 8, []byte("secret"), true
+	default:
+		for _, text := range a.text {
+			if target == text {
+				return target, 8, []byte(content.text), true
+			}
+		}
+
+		return xproto.AtomNone, 0, nil, false
+	}
+}
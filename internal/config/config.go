@@ -10,9 +10,13 @@ import (
 
 // Manager handles configuration loading and saving.
 type Manager struct {
+	homeDir      string
 	configDir    string
 	configPath   string
 	databasePath string
+	// migratedPaths maps the relative paths of the last database list loaded
+	// to their absolute path
+	migratedPaths map[string]string
 }
 
 // New creates a new configuration manager.
@@ -28,12 +32,19 @@ func New() (*Manager, error) {
 	}
 
 	return &Manager{
-		configDir:    configDir,
-		configPath:   filepath.Join(configDir, "config.json"),
-		databasePath: filepath.Join(configDir, "databases.json"),
+		homeDir:       homeDir,
+		configDir:     configDir,
+		configPath:    filepath.Join(configDir, "config.json"),
+		databasePath:  filepath.Join(configDir, "databases.json"),
+		migratedPaths: map[string]string{},
 	}, nil
 }
 
+// Dir returns the configuration directory.
+func (m *Manager) Dir() string {
+	return m.configDir
+}
+
 // LoadConfig loads the application configuration.
 func (m *Manager) LoadConfig() (types.Config, error) {
 	config := types.DefaultConfig()
@@ -86,9 +97,43 @@ func (m *Manager) LoadDatabaseList() (types.DatabaseList, error) {
 		return dbList, err
 	}
 
+	m.migratePaths(&dbList)
+
 	return dbList, nil
 }
 
+// migratePaths makes absolute the paths stored relative to the home
+// directory, where databases used to be loaded from.
+func (m *Manager) migratePaths(dbList *types.DatabaseList) {
+	absolute := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+
+		return filepath.Join(m.homeDir, path)
+	}
+
+	m.migratedPaths = map[string]string{}
+
+	for i := range dbList.Databases {
+		if path := absolute(dbList.Databases[i].Path); path != dbList.Databases[i].Path {
+			m.migratedPaths[dbList.Databases[i].Path] = path
+			dbList.Databases[i].Path = path
+		}
+
+		dbList.Databases[i].KeyFile = absolute(dbList.Databases[i].KeyFile)
+	}
+
+	dbList.LastUsed = absolute(dbList.LastUsed)
+}
+
+// MigratedPaths returns the database paths the last LoadDatabaseList made
+// absolute, by the relative path they were stored with. Passwords stored
+// under the relative path have to be moved to the absolute one.
+func (m *Manager) MigratedPaths() map[string]string {
+	return m.migratedPaths
+}
+
 // SaveDatabaseList saves the list of configured databases.
 func (m *Manager) SaveDatabaseList(dbList types.DatabaseList) error {
 	data, err := json.MarshalIndent(dbList, "", "  ")
@@ -214,3 +214,50 @@ func TestCorruptedConfigFile(t *testing.T) {
 			expectedDefault.ClipboardClearSeconds, config.ClipboardClearSeconds)
 	}
 }
+
+func TestLoadDatabaseListMigratesRelativePaths(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	manager, err := New()
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	// Paths used to be relative to the home directory
+	dbList := types.DatabaseList{
+		Databases: []types.Database{
+			{Name: "personal.kdbx", Path: "passwords/personal.kdbx", KeyFile: "passwords/personal.key"},
+			{Name: "work.kdbx", Path: "/srv/work.kdbx"},
+		},
+		LastUsed: "passwords/personal.kdbx",
+	}
+
+	if err := manager.SaveDatabaseList(dbList); err != nil {
+		t.Fatalf("SaveDatabaseList() failed: %v", err)
+	}
+
+	loaded, err := manager.LoadDatabaseList()
+	if err != nil {
+		t.Fatalf("LoadDatabaseList() failed: %v", err)
+	}
+
+	expected := filepath.Join(home, "passwords", "personal.kdbx")
+	if loaded.Databases[0].Path != expected || loaded.LastUsed != expected {
+		t.Errorf("Expected %s, got %s and %s", expected, loaded.Databases[0].Path, loaded.LastUsed)
+	}
+
+	if loaded.Databases[0].KeyFile != filepath.Join(home, "passwords", "personal.key") {
+		t.Errorf("Expected the key file in the home directory, got %s", loaded.Databases[0].KeyFile)
+	}
+
+	if loaded.Databases[1].Path != "/srv/work.kdbx" {
+		t.Errorf("Expected absolute paths to be kept, got %s", loaded.Databases[1].Path)
+	}
+
+	// Passwords are stored by database path, so the moves are reported
+	migrated := manager.MigratedPaths()
+	if len(migrated) != 1 || migrated["passwords/personal.kdbx"] != expected {
+		t.Errorf("Expected the database path to be reported as migrated, got %v", migrated)
+	}
+}
@@ -0,0 +1,229 @@
+package generator
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/martinlehoux/kagapass/internal/types"
+)
+
+const (
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	digitChars  = "0123456789"
+	symbolChars = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+	// lookAlikeChars are easily confused with one another when read back.
+	lookAlikeChars = "0OoIl1|`'\""
+)
+
+var (
+	ErrNoCharacterClass = errors.New("policy enables no character class")
+	ErrInvalidLength    = errors.New("policy length must be positive")
+	ErrTooShort         = errors.New("policy length is shorter than the number of character classes")
+	ErrInvalidWordCount = errors.New("policy word count must be positive")
+	ErrUnknownKind      = errors.New("unknown policy kind")
+)
+
+// wordlistData is the EFF large wordlist, one "<dice rolls>\t<word>" per line
+// after a header of "#" lines stating its source and CC BY 3.0 US license.
+//
+//go:embed eff_large_wordlist.txt
+var wordlistData string
+
+var wordlist = sync.OnceValue(func() []string {
+	words := make([]string, 0, 7776)
+
+	scanner := bufio.NewScanner(strings.NewReader(wordlistData))
+	for scanner.Scan() {
+		_, word, found := strings.Cut(scanner.Text(), "\t")
+		if found && word != "" {
+			words = append(words, word)
+		}
+	}
+
+	return words
+})
+
+// Generate produces a secret according to the policy kind.
+func Generate(policy types.PasswordPolicy) (string, error) {
+	switch policy.Kind {
+	case types.PasswordPolicyKind:
+		return Password(policy)
+	case types.PassphrasePolicyKind:
+		return Passphrase(policy)
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownKind, policy.Kind)
+	}
+}
+
+// Password generates a random password from the enabled character classes,
+// with at least one character from each of them.
+func Password(policy types.PasswordPolicy) (string, error) {
+	if policy.Length <= 0 {
+		return "", ErrInvalidLength
+	}
+
+	classes := characterClasses(policy)
+	if len(classes) == 0 {
+		return "", ErrNoCharacterClass
+	}
+
+	if policy.Length < len(classes) {
+		return "", ErrTooShort
+	}
+
+	password := make([]byte, 0, policy.Length)
+
+	// One character from each class guarantees the policy is satisfied
+	for _, class := range classes {
+		char, err := pick(class)
+		if err != nil {
+			return "", err
+		}
+
+		password = append(password, char)
+	}
+
+	all := strings.Join(classes, "")
+	for len(password) < policy.Length {
+		char, err := pick(all)
+		if err != nil {
+			return "", err
+		}
+
+		password = append(password, char)
+	}
+
+	err := shuffle(password)
+	if err != nil {
+		return "", err
+	}
+
+	return string(password), nil
+}
+
+// Passphrase generates a diceware-style passphrase from the embedded wordlist.
+func Passphrase(policy types.PasswordPolicy) (string, error) {
+	if policy.Words <= 0 {
+		return "", ErrInvalidWordCount
+	}
+
+	words := wordlist()
+	chosen := make([]string, policy.Words)
+
+	for i := range chosen {
+		index, err := randomInt(len(words))
+		if err != nil {
+			return "", err
+		}
+
+		chosen[i] = words[index]
+	}
+
+	return strings.Join(chosen, policy.Separator), nil
+}
+
+// Describe returns a short human readable summary of the policy.
+func Describe(policy types.PasswordPolicy) string {
+	switch policy.Kind {
+	case types.PasswordPolicyKind:
+		var classes []string
+
+		for _, class := range []struct {
+			enabled bool
+			name    string
+		}{
+			{policy.Upper, "A-Z"},
+			{policy.Lower, "a-z"},
+			{policy.Digits, "0-9"},
+			{policy.Symbols, "symbols"},
+		} {
+			if class.enabled {
+				classes = append(classes, class.name)
+			}
+		}
+
+		description := fmt.Sprintf("%d chars, %s", policy.Length, strings.Join(classes, " "))
+		if policy.ExcludeLookAlikes {
+			description += ", no look-alikes"
+		}
+
+		return description
+	case types.PassphrasePolicyKind:
+		return fmt.Sprintf("%d words, separator %q", policy.Words, policy.Separator)
+	default:
+		return string(policy.Kind)
+	}
+}
+
+func characterClasses(policy types.PasswordPolicy) []string {
+	var classes []string
+
+	for _, class := range []struct {
+		enabled bool
+		chars   string
+	}{
+		{policy.Upper, upperChars},
+		{policy.Lower, lowerChars},
+		{policy.Digits, digitChars},
+		{policy.Symbols, symbolChars},
+	} {
+		if !class.enabled {
+			continue
+		}
+
+		chars := class.chars
+		if policy.ExcludeLookAlikes {
+			chars = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(lookAlikeChars, r) {
+					return -1
+				}
+
+				return r
+			}, chars)
+		}
+
+		classes = append(classes, chars)
+	}
+
+	return classes
+}
+
+func pick(chars string) (byte, error) {
+	index, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+
+	return chars[index], nil
+}
+
+// shuffle performs a Fisher-Yates shuffle using a cryptographic source.
+func shuffle(chars []byte) error {
+	for i := len(chars) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return err
+		}
+
+		chars[i], chars[j] = chars[j], chars[i]
+	}
+
+	return nil
+}
+
+func randomInt(upper int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(upper)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n.Int64()), nil
+}
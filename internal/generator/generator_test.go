@@ -0,0 +1,106 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/martinlehoux/kagapass/internal/types"
+)
+
+func TestPasswordLengthAndClasses(t *testing.T) {
+	policy := types.PasswordPolicy{
+		Kind:    types.PasswordPolicyKind,
+		Length:  32,
+		Upper:   true,
+		Lower:   true,
+		Digits:  true,
+		Symbols: true,
+	}
+
+	for range 50 {
+		password, err := Generate(policy)
+		if err != nil {
+			t.Fatalf("Generate() failed: %v", err)
+		}
+
+		if len(password) != policy.Length {
+			t.Fatalf("Expected length %d, got %d", policy.Length, len(password))
+		}
+
+		for _, class := range []string{upperChars, lowerChars, digitChars, symbolChars} {
+			if !strings.ContainsAny(password, class) {
+				t.Errorf("Password '%s' is missing a character from '%s'", password, class)
+			}
+		}
+	}
+}
+
+func TestPasswordExcludeLookAlikes(t *testing.T) {
+	policy := types.PasswordPolicy{
+		Kind:              types.PasswordPolicyKind,
+		Length:            64,
+		Upper:             true,
+		Lower:             true,
+		Digits:            true,
+		Symbols:           true,
+		ExcludeLookAlikes: true,
+	}
+
+	for range 50 {
+		password, err := Password(policy)
+		if err != nil {
+			t.Fatalf("Password() failed: %v", err)
+		}
+
+		if strings.ContainsAny(password, lookAlikeChars) {
+			t.Errorf("Password '%s' contains look-alike characters", password)
+		}
+	}
+}
+
+func TestPasswordDigitsOnly(t *testing.T) {
+	password, err := Password(types.PasswordPolicy{Kind: types.PasswordPolicyKind, Length: 6, Digits: true})
+	if err != nil {
+		t.Fatalf("Password() failed: %v", err)
+	}
+
+	if strings.Trim(password, digitChars) != "" || len(password) != 6 {
+		t.Errorf("Expected a 6 digit PIN, got '%s'", password)
+	}
+}
+
+func TestPasswordInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy types.PasswordPolicy
+		err    error
+	}{
+		{"no class", types.PasswordPolicy{Kind: types.PasswordPolicyKind, Length: 10}, ErrNoCharacterClass},
+		{"zero length", types.PasswordPolicy{Kind: types.PasswordPolicyKind, Digits: true}, ErrInvalidLength},
+		{"too short", types.PasswordPolicy{Kind: types.PasswordPolicyKind, Length: 2, Upper: true, Lower: true, Digits: true}, ErrTooShort},
+		{"no words", types.PasswordPolicy{Kind: types.PassphrasePolicyKind}, ErrInvalidWordCount},
+	}
+
+	for _, test := range tests {
+		_, err := Generate(test.policy)
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	if len(wordlist()) != 7776 {
+		t.Fatalf("Expected 7776 words in wordlist, got %d", len(wordlist()))
+	}
+
+	passphrase, err := Generate(types.PasswordPolicy{Kind: types.PassphrasePolicyKind, Words: 5, Separator: "-"})
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+
+	words := strings.Split(passphrase, "-")
+	if len(words) < 5 {
+		t.Errorf("Expected at least 5 words, got '%s'", passphrase)
+	}
+}
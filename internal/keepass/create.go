@@ -0,0 +1,53 @@
+package keepass
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// Create writes a new empty KDBX 4 database at path, absolute or relative to
+// the root of the filesystem, readable by the user only, with the KDF settings,
+// like DefaultKDF(Argon2d). An existing file is never replaced, even one
+// created while the database is encrypted.
+func (m *Loader) Create(path string, name string, credentials Credentials, kdf KDF) error {
+	if len(credentials.Password) == 0 && credentials.KeyFile == "" {
+		return ErrNoMasterKey
+	}
+
+	dbCredentials, err := newCredentials(m.fs, credentials)
+	if err != nil {
+		return err
+	}
+
+	database := gokeepasslib.NewDatabase(gokeepasslib.WithDatabaseKDBXVersion4())
+	database.Credentials = dbCredentials
+	database.Content.Meta.DatabaseName = name
+
+	// The default root group of gokeepasslib holds a sample entry
+	root := gokeepasslib.NewGroup()
+	root.Name = name
+	database.Content.Root.Groups = []gokeepasslib.Group{root}
+
+	err = setKDF(database.Header, kdf)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+
+	err = gokeepasslib.NewEncoder(&buffer).Encode(database)
+	if err != nil {
+		return err
+	}
+
+	err = m.fs.CreateFile(fsName(path), buffer.Bytes(), 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s: %w", path, fs.ErrExist)
+	}
+
+	return err
+}
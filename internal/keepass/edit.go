@@ -0,0 +1,356 @@
+package keepass
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+var (
+	ErrEntryNotFound      = errors.New("entry not found")
+	ErrVersionNotFound    = errors.New("version not found in entry history")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrNoRootGroup        = errors.New("database has no root group")
+)
+
+// recycleBinIconID is the trash icon of KeePass.
+const recycleBinIconID = 43
+
+// CreateEntry adds a new entry to the group at entry.Group, creating any
+// missing group along the way.
+func (k *KeePass) CreateEntry(entry types.Entry) (types.Entry, error) {
+	root, err := k.rootGroup()
+	if err != nil {
+		return types.Entry{}, err
+	}
+
+	raw := gokeepasslib.NewEntry()
+	applyEntry(&raw, entry)
+
+	group := ensureGroup(root, entry.Group)
+	group.Entries = append(group.Entries, raw)
+
+	return newEntry(k.database, raw, group, entry.Group), nil
+}
+
+// UpdateEntry replaces the fields of the entry with the same UUID, keeping
+// its previous version in history and moving it if its group changed.
+func (k *KeePass) UpdateEntry(entry types.Entry) (types.Entry, error) {
+	root, err := k.rootGroup()
+	if err != nil {
+		return types.Entry{}, err
+	}
+
+	group, index, groupPath := findEntry(root, "", entry.Raw.UUID)
+	if group == nil {
+		return types.Entry{}, ErrEntryNotFound
+	}
+
+	current := &group.Entries[index]
+	pushHistory(current, k.database.Content.Meta.HistoryMaxItems)
+	applyEntry(current, entry)
+
+	if entry.Group == groupPath {
+		return newEntry(k.database, *current, group, groupPath), nil
+	}
+
+	moved := *current
+	now := fileNow()
+	moved.Times.LocationChanged = &now
+	group.Entries = slices.Delete(group.Entries, index, index+1)
+
+	target := ensureGroup(root, entry.Group)
+	target.Entries = append(target.Entries, moved)
+
+	return newEntry(k.database, moved, target, entry.Group), nil
+}
+
+// RestoreVersion makes the version of the entry last modified at the given
+// time its current one, keeping the current one in history.
+func (k *KeePass) RestoreVersion(uuid gokeepasslib.UUID, modified time.Time) (types.Entry, error) {
+	root, err := k.rootGroup()
+	if err != nil {
+		return types.Entry{}, err
+	}
+
+	group, index, groupPath := findEntry(root, "", uuid)
+	if group == nil {
+		return types.Entry{}, ErrEntryNotFound
+	}
+
+	current := &group.Entries[index]
+
+	var (
+		version gokeepasslib.Entry
+		found   bool
+	)
+
+	for _, history := range current.Histories {
+		for _, previous := range history.Entries {
+			if previous.Times.LastModificationTime != nil && previous.Times.LastModificationTime.Time.Equal(modified) {
+				version = previous
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return types.Entry{}, ErrVersionNotFound
+	}
+
+	pushHistory(current, k.database.Content.Meta.HistoryMaxItems)
+
+	current.Values = slices.Clone(version.Values)
+	current.Binaries = slices.Clone(version.Binaries)
+	current.Tags = version.Tags
+	current.IconID = version.IconID
+	current.CustomIconUUID = version.CustomIconUUID
+	current.ForegroundColor = version.ForegroundColor
+	current.BackgroundColor = version.BackgroundColor
+	current.OverrideURL = version.OverrideURL
+	current.AutoType = version.AutoType
+
+	now := fileNow()
+	current.Times.LastModificationTime = &now
+	current.Times.LastAccessTime = &now
+
+	return newEntry(k.database, *current, group, groupPath), nil
+}
+
+// DeleteEntry moves the entry with the given UUID to the recycle bin when the
+// database has it enabled, as KeePass does. Otherwise, or when the entry is
+// already in the recycle bin, it removes the entry and records the deletion
+// so that other clients can synchronise it.
+func (k *KeePass) DeleteEntry(uuid gokeepasslib.UUID) error {
+	root, err := k.rootGroup()
+	if err != nil {
+		return err
+	}
+
+	group, index, _ := findEntry(root, "", uuid)
+	if group == nil {
+		return ErrEntryNotFound
+	}
+
+	entry := group.Entries[index]
+	group.Entries = slices.Delete(group.Entries, index, index+1)
+	now := fileNow()
+
+	if bin := k.recycleBin(root, group, now); bin != nil {
+		entry.Times.LocationChanged = &now
+		bin.Entries = append(bin.Entries, entry)
+
+		return nil
+	}
+
+	k.database.Content.Root.DeletedObjects = append(k.database.Content.Root.DeletedObjects, gokeepasslib.DeletedObjectData{
+		XMLName:      xml.Name{Space: "", Local: "DeletedObject"},
+		UUID:         uuid,
+		DeletionTime: &now,
+	})
+
+	return nil
+}
+
+// recycleBin returns the recycle bin group the entries of the group are moved
+// to when deleted, created under the root on first use. It is nil when the
+// recycle bin is disabled or holds the group, whose entries are then removed.
+func (k *KeePass) recycleBin(root *gokeepasslib.Group, group *gokeepasslib.Group, now w.TimeWrapper) *gokeepasslib.Group {
+	meta := k.database.Content.Meta
+	if meta == nil || !meta.RecycleBinEnabled.Bool {
+		return nil
+	}
+
+	if bin, _ := findGroup(root, nil, meta.RecycleBinUUID); bin != nil {
+		if inBin, _ := findGroup(bin, nil, group.UUID); inBin != nil {
+			return nil
+		}
+
+		return bin
+	}
+
+	bin := gokeepasslib.NewGroup()
+	bin.Name = "Recycle Bin"
+	bin.IconID = recycleBinIconID
+	bin.EnableAutoType = w.NewNullableBoolWrapper(false)
+	bin.EnableSearching = w.NewNullableBoolWrapper(false)
+	root.Groups = append(root.Groups, bin)
+
+	meta.RecycleBinUUID = bin.UUID
+	meta.RecycleBinChanged = &now
+
+	return &root.Groups[len(root.Groups)-1]
+}
+
+// Save encrypts the database and writes it back to the file it was loaded from.
+func (k *KeePass) Save() error {
+	// The protected values are encrypted with the new inner stream key
+	err := refreshSeeds(k.database)
+	if err != nil {
+		return err
+	}
+
+	err = k.database.LockProtectedEntries()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := k.database.UnlockProtectedEntries()
+		if err != nil {
+			log.Printf("Error unlocking keepass entries: %v", err)
+		}
+	}()
+
+	var buffer bytes.Buffer
+
+	err = gokeepasslib.NewEncoder(&buffer).Encode(k.database)
+	if err != nil {
+		return err
+	}
+
+	err = k.fs.WriteFile(k.path, buffer.Bytes(), 0o600)
+	if err != nil {
+		return err
+	}
+
+	// Our own write is not an external change
+	return k.MarkUnchanged()
+}
+
+func (k *KeePass) rootGroup() (*gokeepasslib.Group, error) {
+	if k.database.Content == nil || k.database.Content.Root == nil || len(k.database.Content.Root.Groups) == 0 {
+		return nil, ErrNoRootGroup
+	}
+
+	return &k.database.Content.Root.Groups[0], nil
+}
+
+// refreshSeeds draws a new master seed, encryption IV, KDF salt and inner
+// stream key so that every save produces a differently encrypted file, as
+// KeePass does. The key is derived again from the new salt when encoding.
+func refreshSeeds(database *gokeepasslib.Database) error {
+	headers := database.Header.FileHeaders
+	// KDBX 3 keeps the KDF seed and inner stream key in the header
+	seeds := [][]byte{headers.MasterSeed, headers.EncryptionIV, headers.TransformSeed, headers.ProtectedStreamKey, headers.StreamStartBytes}
+
+	if headers.KdfParameters != nil {
+		seeds = append(seeds, headers.KdfParameters.Salt[:])
+	}
+
+	if database.Content != nil && database.Content.InnerHeader != nil {
+		seeds = append(seeds, database.Content.InnerHeader.InnerRandomStreamKey)
+	}
+
+	for _, seed := range seeds {
+		_, err := rand.Read(seed)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func findEntry(group *gokeepasslib.Group, groupPath string, uuid gokeepasslib.UUID) (*gokeepasslib.Group, int, string) {
+	for i := range group.Entries {
+		if group.Entries[i].UUID.Compare(uuid) {
+			return group, i, groupPath
+		}
+	}
+
+	for i := range group.Groups {
+		found, index, foundPath := findEntry(&group.Groups[i], joinGroupPath(groupPath, group.Groups[i].Name), uuid)
+		if found != nil {
+			return found, index, foundPath
+		}
+	}
+
+	return nil, 0, ""
+}
+
+func ensureGroup(root *gokeepasslib.Group, groupPath string) *gokeepasslib.Group {
+	group := root
+
+	for name := range strings.SplitSeq(groupPath, "/") {
+		if name == "" {
+			continue
+		}
+
+		index := slices.IndexFunc(group.Groups, func(child gokeepasslib.Group) bool {
+			return child.Name == name
+		})
+		if index < 0 {
+			child := gokeepasslib.NewGroup()
+			child.Name = name
+			group.Groups = append(group.Groups, child)
+			index = len(group.Groups) - 1
+		}
+
+		group = &group.Groups[index]
+	}
+
+	return group
+}
+
+// fileNow returns the current time at the second, the precision database
+// files keep, so that a version is the same once saved and read back.
+func fileNow() w.TimeWrapper {
+	now := w.Now()
+	now.Time = now.Time.Truncate(time.Second)
+
+	return now
+}
+
+func pushHistory(entry *gokeepasslib.Entry, maxItems int64) {
+	previous := *entry
+	previous.Values = slices.Clone(entry.Values)
+	previous.Binaries = slices.Clone(entry.Binaries)
+	previous.Histories = nil
+
+	if len(entry.Histories) == 0 {
+		entry.Histories = []gokeepasslib.History{{Entries: nil}}
+	}
+
+	history := &entry.Histories[0]
+	history.Entries = append(history.Entries, previous)
+
+	if maxItems >= 0 && int64(len(history.Entries)) > maxItems {
+		history.Entries = history.Entries[int64(len(history.Entries))-maxItems:]
+	}
+}
+
+func applyEntry(raw *gokeepasslib.Entry, entry types.Entry) {
+	setValue(raw, "Title", entry.Title, false)
+	setValue(raw, "UserName", entry.Username, false)
+	setValue(raw, "Password", entry.Password, true)
+	setValue(raw, "URL", entry.URL, false)
+	setValue(raw, "Notes", entry.Notes, false)
+
+	now := fileNow()
+	raw.Times.LastModificationTime = &now
+	raw.Times.LastAccessTime = &now
+}
+
+func setValue(raw *gokeepasslib.Entry, key string, content string, protected bool) {
+	value := raw.Get(key)
+	if value == nil {
+		raw.Values = append(raw.Values, gokeepasslib.ValueData{
+			Key:   key,
+			Value: gokeepasslib.V{Content: content, Protected: w.NewBoolWrapper(protected)},
+		})
+
+		return
+	}
+
+	value.Value.Content = content
+}
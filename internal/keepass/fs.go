@@ -0,0 +1,108 @@
+package keepass
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS is a filesystem databases can be loaded from and saved back to.
+type FS interface {
+	fs.FS
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// CreateFile writes a new file, failing with fs.ErrExist when the name is
+	// taken.
+	CreateFile(name string, data []byte, perm fs.FileMode) error
+}
+
+type dirFS struct {
+	fs.FS
+
+	root string
+}
+
+var _ FS = dirFS{} //nolint:exhaustruct // Type assertion only
+
+// DirFS returns a writable filesystem rooted at the given directory.
+func DirFS(root string) FS {
+	return dirFS{
+		FS:   os.DirFS(root),
+		root: root,
+	}
+}
+
+// WriteFile atomically replaces the named file by writing to a temporary
+// file in the same directory and renaming it over the original.
+func (d dirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	path := filepath.Join(d.root, filepath.FromSlash(name))
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// CreateFile creates the named file exclusively, so that a file created in
+// the meantime by another program is never replaced. A file left incomplete
+// by a failed write is removed.
+func (d dirFS) CreateFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+
+	path := filepath.Join(d.root, filepath.FromSlash(name))
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		_ = os.Remove(path)
+
+		return err
+	}
+
+	return nil
+}
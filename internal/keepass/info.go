@@ -0,0 +1,231 @@
+package keepass
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// KdfArgon2id identifies Argon2id, the default of KeePassXC, which
+// gokeepasslib does not name next to gokeepasslib.KdfArgon2 for Argon2d.
+var KdfArgon2id = []byte{
+	0x9E, 0x29, 0x8B, 0x19,
+	0x56, 0xDB, 0x47, 0x73,
+	0xB2, 0x3D, 0xFC, 0x3E,
+	0xC6, 0xF0, 0xA1, 0xE6,
+}
+
+const (
+	// AESKDF transforms the key with rounds of AES.
+	AESKDF = "AES-KDF"
+	// Argon2d is the memory-hard Argon2 variant of KeePass.
+	Argon2d = "Argon2d"
+	// Argon2id is the memory-hard Argon2 variant of KeePassXC.
+	Argon2id = "Argon2id"
+)
+
+// Header describes how a database file is encrypted. It is readable without
+// the credentials.
+type Header struct {
+	// Version is the KDBX version, like 4.0
+	Version     string
+	Cipher      string
+	Compression string
+	KDF         KDF
+}
+
+// KDF are the key derivation settings, which make each unlock attempt costly.
+type KDF struct {
+	// Type is AESKDF, Argon2d or Argon2id
+	Type string
+	// Rounds of AES-KDF
+	Rounds uint64
+	// Iterations, Memory in bytes and Parallelism of Argon2
+	Iterations  uint64
+	Memory      uint64
+	Parallelism uint32
+}
+
+// String describes the settings, like "Argon2d, 2 iterations, 64 MiB, 2
+// threads".
+func (k KDF) String() string {
+	if k.Type == AESKDF {
+		return fmt.Sprintf("%s, %d rounds", k.Type, k.Rounds)
+	}
+
+	memory := fmt.Sprintf("%d KiB", k.Memory/1024)
+	if k.Memory%(1024*1024) == 0 {
+		memory = fmt.Sprintf("%d MiB", k.Memory/(1024*1024))
+	}
+
+	return fmt.Sprintf("%s, %d iterations, %s, %d threads", k.Type, k.Iterations, memory, k.Parallelism)
+}
+
+// Info describes an unlocked database.
+type Info struct {
+	Header
+
+	Name        string
+	Description string
+	// MasterKeyChanged is when the credentials were last changed, zero when
+	// unknown
+	MasterKeyChanged time.Time
+	RecycleBin       bool
+	// RecycleBinGroup is the path of the recycle bin group, empty until an
+	// entry is deleted with the recycle bin enabled
+	RecycleBinGroup string
+	// Entries and Groups count all entries and groups below the root group,
+	// recycled ones included
+	Entries int
+	Groups  int
+}
+
+// ReadHeader reads the header of the database at path, absolute or relative
+// to the root of the filesystem, without decrypting it.
+func (m *Loader) ReadHeader(path string) (Header, error) {
+	file, err := m.fs.Open(fsName(path))
+	if err != nil {
+		return Header{}, err //nolint:exhaustruct // No header
+	}
+
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			log.Printf("Error closing keepass file: %v", err)
+		}
+	}()
+
+	// Decoding stops for the missing credentials right after the header
+	database := gokeepasslib.NewDatabase()
+	database.Credentials = nil
+
+	err = gokeepasslib.NewDecoder(file).Decode(database)
+
+	var missing gokeepasslib.ErrRequiredAttributeMissing
+	if !errors.As(err, &missing) {
+		if err == nil {
+			err = errors.New("decoded without credentials")
+		}
+
+		return Header{}, fmt.Errorf("failed to read header: %w", err) //nolint:exhaustruct // No header
+	}
+
+	return newHeader(database.Header), nil
+}
+
+// Info describes the database, from its header and metadata.
+func (k *KeePass) Info() Info {
+	info := Info{
+		Header:           newHeader(k.database.Header),
+		Name:             "",
+		Description:      "",
+		MasterKeyChanged: time.Time{},
+		RecycleBin:       false,
+		RecycleBinGroup:  "",
+		Entries:          0,
+		Groups:           0,
+	}
+
+	if k.database.Content == nil {
+		return info
+	}
+
+	if meta := k.database.Content.Meta; meta != nil {
+		info.Name = meta.DatabaseName
+		info.Description = meta.DatabaseDescription
+		info.RecycleBin = meta.RecycleBinEnabled.Bool
+
+		if meta.MasterKeyChanged != nil {
+			info.MasterKeyChanged = meta.MasterKeyChanged.Time
+		}
+	}
+
+	root, err := k.rootGroup()
+	if err != nil {
+		return info
+	}
+
+	var count func(group *gokeepasslib.Group, groupPath string)
+
+	count = func(group *gokeepasslib.Group, groupPath string) {
+		for _, entry := range group.Entries {
+			if entry.Values != nil {
+				info.Entries++
+			}
+		}
+
+		for i := range group.Groups {
+			subGroup := &group.Groups[i]
+			subPath := joinGroupPath(groupPath, subGroup.Name)
+			info.Groups++
+
+			if k.database.Content.Meta != nil && subGroup.UUID.Compare(k.database.Content.Meta.RecycleBinUUID) {
+				info.RecycleBinGroup = subPath
+			}
+
+			count(subGroup, subPath)
+		}
+	}
+	count(root, "")
+
+	return info
+}
+
+func newHeader(header *gokeepasslib.DBHeader) Header {
+	signature := header.Signature
+	fileHeaders := header.FileHeaders
+
+	result := Header{
+		Version:     fmt.Sprintf("%d.%d", signature.MajorVersion, signature.MinorVersion),
+		Cipher:      cipherName(fileHeaders.CipherID),
+		Compression: "none",
+		KDF:         KDF{Type: AESKDF, Rounds: fileHeaders.TransformRounds, Iterations: 0, Memory: 0, Parallelism: 0},
+	}
+
+	if fileHeaders.CompressionFlags == gokeepasslib.GzipCompressionFlag {
+		result.Compression = "gzip"
+	}
+
+	// KDBX 4 keeps the KDF settings in a dictionary, KDBX 3 only has AES-KDF
+	if parameters := fileHeaders.KdfParameters; header.IsKdbx4() && parameters != nil {
+		result.KDF = KDF{
+			Type:        kdfName(parameters.UUID),
+			Rounds:      parameters.Rounds,
+			Iterations:  parameters.Iterations,
+			Memory:      parameters.Memory,
+			Parallelism: parameters.Parallelism,
+		}
+	}
+
+	return result
+}
+
+func cipherName(id []byte) string {
+	switch {
+	case bytes.Equal(id, gokeepasslib.CipherAES):
+		return "AES-256"
+	case bytes.Equal(id, gokeepasslib.CipherTwoFish):
+		return "Twofish"
+	case bytes.Equal(id, gokeepasslib.CipherChaCha20):
+		return "ChaCha20"
+	default:
+		return fmt.Sprintf("unknown (%x)", id)
+	}
+}
+
+func kdfName(id []byte) string {
+	switch {
+	case bytes.Equal(id, gokeepasslib.KdfAES3), bytes.Equal(id, gokeepasslib.KdfAES4):
+		return AESKDF
+	case bytes.Equal(id, gokeepasslib.KdfArgon2):
+		return Argon2d
+	case bytes.Equal(id, KdfArgon2id):
+		return Argon2id
+	default:
+		return fmt.Sprintf("unknown (%x)", id)
+	}
+}
@@ -1,26 +1,58 @@
 package keepass
 
 import (
+	"fmt"
 	"io/fs"
 	"log"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/martinlehoux/kagapass/internal/totp"
 	"github.com/martinlehoux/kagapass/internal/types"
 	"github.com/tobischo/gokeepasslib/v3"
 )
 
 type Loader struct {
-	fs fs.FS
+	fs FS
 }
 
-func NewLoader(fs fs.FS) *Loader {
+func NewLoader(fs FS) *Loader {
 	return &Loader{
 		fs: fs,
 	}
 }
 
-func (m *Loader) Load(path string, password []byte) (*KeePass, error) {
-	file, err := m.fs.Open(path)
+// Credentials are the parts of a composite master key. A database may be
+// protected by a password, a key file, or both.
+type Credentials struct {
+	Password []byte
+	// KeyFile is the path of the key file, empty when there is none
+	KeyFile string
+}
+
+// PasswordCredentials returns credentials made of a password only.
+func PasswordCredentials(password []byte) Credentials {
+	return Credentials{Password: password, KeyFile: ""}
+}
+
+// Load decrypts the database at path, absolute or relative to the root of the
+// filesystem.
+func (m *Loader) Load(path string, credentials Credentials) (*KeePass, error) {
+	dbCredentials, err := newCredentials(m.fs, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return load(m.fs, path, dbCredentials)
+}
+
+// load decodes the database file with the credentials.
+func load(fsys FS, path string, credentials *gokeepasslib.DBCredentials) (*KeePass, error) {
+	path = fsName(path)
+
+	file, err := fsys.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -32,11 +64,22 @@ func (m *Loader) Load(path string, password []byte) (*KeePass, error) {
 		}
 	}()
 
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
 	database := gokeepasslib.NewDatabase()
-	database.Credentials = gokeepasslib.NewPasswordCredentials(string(password))
+	database.Credentials = credentials
 
 	err = gokeepasslib.NewDecoder(file).Decode(database)
 	if err != nil {
+		// gokeepasslib derives the keys of Argon2id with AES-KDF, failing
+		// like a wrong password would
+		if header := database.Header; header.Signature != nil && header.FileHeaders != nil && newHeader(header).KDF.Type == Argon2id {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedKDF, Argon2id)
+		}
+
 		return nil, err
 	}
 
@@ -44,11 +87,51 @@ func (m *Loader) Load(path string, password []byte) (*KeePass, error) {
 
 	return &KeePass{
 		database: database,
+		fs:       fsys,
+		path:     path,
+		modTime:  info.ModTime(),
+		size:     info.Size(),
 	}, err
 }
 
+// newCredentials builds the composite key, reading the key file from fsys. Key
+// files may be KeePass XML (v1 or v2), 32 raw bytes, 64 hex characters, or any
+// other file, which is hashed.
+func newCredentials(fsys FS, credentials Credentials) (*gokeepasslib.DBCredentials, error) {
+	if credentials.KeyFile == "" {
+		return gokeepasslib.NewPasswordCredentials(string(credentials.Password)), nil
+	}
+
+	data, err := fs.ReadFile(fsys, fsName(credentials.KeyFile))
+	if err != nil {
+		return nil, kcore.Wrap(err, "failed to read key file")
+	}
+
+	if len(credentials.Password) == 0 {
+		dbCredentials, err := gokeepasslib.NewKeyDataCredentials(data)
+		if err != nil {
+			return nil, kcore.Wrap(err, "failed to parse key file")
+		}
+
+		return dbCredentials, nil
+	}
+
+	dbCredentials, err := gokeepasslib.NewPasswordAndKeyDataCredentials(string(credentials.Password), data)
+	if err != nil {
+		return nil, kcore.Wrap(err, "failed to parse key file")
+	}
+
+	return dbCredentials, nil
+}
+
 type KeePass struct {
 	database *gokeepasslib.Database
+	fs       FS
+	path     string
+	// modTime and size of the file when it was last read or written, to
+	// detect changes made by other programs
+	modTime time.Time
+	size    int64
 }
 
 func (k *KeePass) Entries() ([]types.Entry, error) {
@@ -56,17 +139,116 @@ func (k *KeePass) Entries() ([]types.Entry, error) {
 
 	// Start from the root group
 	if k.database.Content != nil && k.database.Content.Root != nil && len(k.database.Content.Root.Groups) > 0 {
-		entries = append(entries, collectEntriesFromGroup(&k.database.Content.Root.Groups[0], "")...)
+		entries = append(entries, collectEntriesFromGroup(k.database, &k.database.Content.Root.Groups[0], "")...)
 	}
 
 	return entries, nil
 }
 
+// Groups returns the group tree, starting from the root group.
+func (k *KeePass) Groups() (types.Group, error) {
+	root, err := k.rootGroup()
+	if err != nil {
+		return types.Group{}, err //nolint:exhaustruct // No group
+	}
+
+	return newGroup(root, ""), nil
+}
+
+// History returns the previous versions of the entry, most recent first.
+// Versions are listed in the group and database of the entry, without the
+// size of their attachments.
+func History(entry types.Entry) []types.Entry {
+	group := gokeepasslib.Group{UUID: entry.GroupUUID} //nolint:exhaustruct // Only the UUID is read
+
+	var versions []types.Entry
+
+	for _, history := range entry.Raw.Histories {
+		for _, raw := range history.Entries {
+			version := newEntry(nil, raw, &group, entry.Group)
+			version.Database = entry.Database
+			versions = append(versions, version)
+		}
+	}
+
+	slices.SortStableFunc(versions, func(a, b types.Entry) int {
+		return b.Modified.Compare(a.Modified)
+	})
+
+	return versions
+}
+
+// Changed reports whether the file was written by another program since it
+// was loaded or saved.
+func (k *KeePass) Changed() (bool, error) {
+	info, err := fs.Stat(k.fs, k.path)
+	if err != nil {
+		return false, err
+	}
+
+	return !info.ModTime().Equal(k.modTime) || info.Size() != k.size, nil
+}
+
+// MarkUnchanged records the current file as known, so that a file that
+// cannot be reloaded is not reported as changed again until it is rewritten.
+func (k *KeePass) MarkUnchanged() error {
+	info, err := fs.Stat(k.fs, k.path)
+	if err != nil {
+		return err
+	}
+
+	k.modTime = info.ModTime()
+	k.size = info.Size()
+
+	return nil
+}
+
+// Reload loads the file again with the credentials it was unlocked with. The
+// database is left untouched, and should be closed once replaced.
+func (k *KeePass) Reload() (*KeePass, error) {
+	return load(k.fs, k.path, k.database.Credentials)
+}
+
+// LoadWithSameCredentials loads another file with the credentials this
+// database was unlocked with, like a copy of it.
+func (k *KeePass) LoadWithSameCredentials(path string) (*KeePass, error) {
+	return load(k.fs, path, k.database.Credentials)
+}
+
+// Attachment returns the content of the named attachment of the entry with the
+// given UUID.
+func (k *KeePass) Attachment(uuid gokeepasslib.UUID, name string) ([]byte, error) {
+	root, err := k.rootGroup()
+	if err != nil {
+		return nil, err
+	}
+
+	group, index, _ := findEntry(root, "", uuid)
+	if group == nil {
+		return nil, ErrEntryNotFound
+	}
+
+	for _, reference := range group.Entries[index].Binaries {
+		if reference.Name != name {
+			continue
+		}
+
+		binary := k.database.FindBinary(reference.Value.ID)
+		if binary == nil {
+			break
+		}
+
+		return binary.GetContentBytes()
+	}
+
+	return nil, ErrAttachmentNotFound
+}
+
 func (k *KeePass) Close() error {
 	return k.database.LockProtectedEntries()
 }
 
-func collectEntriesFromGroup(group *gokeepasslib.Group, groupPath string) []types.Entry {
+func collectEntriesFromGroup(database *gokeepasslib.Database, group *gokeepasslib.Group, groupPath string) []types.Entry {
 	var entries []types.Entry
 
 	if group == nil {
@@ -79,59 +261,162 @@ func collectEntriesFromGroup(group *gokeepasslib.Group, groupPath string) []type
 			continue
 		}
 
-		entryData := types.Entry{
-			Raw:      entry,
-			Group:    groupPath,
-			Title:    "",
-			Username: "",
-			Password: "",
-			URL:      "",
-			Notes:    "",
-			Created:  time.Time{},
-			Modified: time.Time{},
+		entries = append(entries, newEntry(database, entry, group, groupPath))
+	}
+
+	// Recursively process subgroups
+	for _, subGroup := range group.Groups {
+		entries = append(entries, collectEntriesFromGroup(database, &subGroup, joinGroupPath(groupPath, subGroup.Name))...)
+	}
+
+	return entries
+}
+
+func newGroup(group *gokeepasslib.Group, groupPath string) types.Group {
+	groups := make([]types.Group, len(group.Groups))
+	for i := range group.Groups {
+		groups[i] = newGroup(&group.Groups[i], joinGroupPath(groupPath, group.Groups[i].Name))
+	}
+
+	entryCount := 0
+
+	for _, entry := range group.Entries {
+		if entry.Values != nil {
+			entryCount++
 		}
+	}
+
+	return types.Group{
+		UUID:       group.UUID,
+		Name:       group.Name,
+		Path:       groupPath,
+		IconID:     group.IconID,
+		Groups:     groups,
+		EntryCount: entryCount,
+	}
+}
 
-		// Extract common fields
-		for _, value := range entry.Values {
-			switch value.Key {
-			case "Title":
-				entryData.Title = value.Value.Content
-			case "UserName":
-				entryData.Username = value.Value.Content
-			case "Password":
-				entryData.Password = value.Value.Content
-			case "URL":
-				entryData.URL = value.Value.Content
-			case "Notes":
-				entryData.Notes = value.Value.Content
+// newEntry converts a raw entry, reading the size of its attachments from the
+// database when there is one.
+func newEntry(database *gokeepasslib.Database, entry gokeepasslib.Entry, group *gokeepasslib.Group, groupPath string) types.Entry {
+	entryData := types.Entry{
+		Raw:         entry,
+		Group:       groupPath,
+		GroupUUID:   group.UUID,
+		Title:       "",
+		Username:    "",
+		Password:    "",
+		URL:         "",
+		Notes:       "",
+		Fields:      nil,
+		Attachments: nil,
+		Tags:        parseTags(entry.Tags),
+		Created:     time.Time{},
+		Modified:    time.Time{},
+		TOTP:        nil,
+		Database:    types.Database{}, //nolint:exhaustruct // Only the caller knows which configured database this is
+	}
+
+	for _, reference := range entry.Binaries {
+		attachment := types.Attachment{Name: reference.Name, Size: 0}
+
+		if database != nil {
+			if binary := database.FindBinary(reference.Value.ID); binary != nil {
+				content, err := binary.GetContentBytes()
+				if err != nil {
+					log.Printf("Ignoring unreadable attachment %q of entry %q: %v", reference.Name, entry.GetTitle(), err)
+				}
+
+				attachment.Size = len(content)
 			}
 		}
 
-		// Extract timestamps
-		if entry.Times.CreationTime != nil {
-			entryData.Created = entry.Times.CreationTime.Time
-		}
+		entryData.Attachments = append(entryData.Attachments, attachment)
+	}
 
-		if entry.Times.LastModificationTime != nil {
-			entryData.Modified = entry.Times.LastModificationTime.Time
+	// Extract common fields
+	for _, value := range entry.Values {
+		switch value.Key {
+		case "Title":
+			entryData.Title = value.Value.Content
+		case "UserName":
+			entryData.Username = value.Value.Content
+		case "Password":
+			entryData.Password = value.Value.Content
+		case "URL":
+			entryData.URL = value.Value.Content
+		case "Notes":
+			entryData.Notes = value.Value.Content
+		default:
+			entryData.Fields = append(entryData.Fields, types.Field{
+				Key:       value.Key,
+				Value:     value.Value.Content,
+				Protected: value.Value.Protected.Bool,
+			})
 		}
+	}
+
+	entryData.TOTP = parseTOTP(entry)
 
-		entries = append(entries, entryData)
+	// Extract timestamps
+	if entry.Times.CreationTime != nil {
+		entryData.Created = entry.Times.CreationTime.Time
 	}
 
-	// Recursively process subgroups
-	for _, subGroup := range group.Groups {
-		subGroupPath := groupPath
-		if subGroup.Name != "" {
-			if subGroupPath != "" {
-				subGroupPath += "/"
-			}
+	if entry.Times.LastModificationTime != nil {
+		entryData.Modified = entry.Times.LastModificationTime.Time
+	}
+
+	return entryData
+}
+
+// parseTOTP reads the KeePassXC "otp" URI, falling back to the legacy
+// "TOTP Seed" and "TOTP Settings" fields.
+func parseTOTP(entry gokeepasslib.Entry) *totp.TOTP {
+	var (
+		parsed totp.TOTP
+		err    error
+	)
 
-			subGroupPath += subGroup.Name
+	switch {
+	case entry.GetContent("otp") != "":
+		parsed, err = totp.ParseURI(entry.GetContent("otp"))
+	case entry.GetContent("TOTP Seed") != "":
+		parsed, err = totp.ParseLegacy(entry.GetContent("TOTP Seed"), entry.GetContent("TOTP Settings"))
+	default:
+		return nil
+	}
+
+	if err != nil {
+		log.Printf("Ignoring invalid TOTP for entry %q: %v", entry.GetTitle(), err)
+
+		return nil
+	}
+
+	return &parsed
+}
+
+// parseTags splits KeePass tags, which are separated by semicolons or commas.
+func parseTags(tags string) []string {
+	var parsed []string
+
+	for tag := range strings.FieldsFuncSeq(tags, func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			parsed = append(parsed, tag)
 		}
+	}
+
+	return parsed
+}
 
-		entries = append(entries, collectEntriesFromGroup(&subGroup, subGroupPath)...)
+func joinGroupPath(groupPath string, name string) string {
+	if name == "" {
+		return groupPath
 	}
 
-	return entries
+	if groupPath == "" {
+		return name
+	}
+
+	return groupPath + "/" + name
 }
@@ -0,0 +1,1116 @@
+package keepass
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/testor"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+const testPassword = "supersecret"
+
+func writeTestDatabase(t *testing.T, dir string, name string) {
+	t.Helper()
+
+	writeTestDatabaseWithCredentials(t, dir, name, gokeepasslib.NewPasswordCredentials(testPassword))
+}
+
+func writeTestDatabaseWithCredentials(t *testing.T, dir string, name string, credentials *gokeepasslib.DBCredentials) {
+	t.Helper()
+
+	entry := testor.NewEntry("GitHub", "octocat", "hunter2",
+		gokeepasslib.ValueData{Key: "API Key", Value: gokeepasslib.V{Content: "ghp_token", Protected: w.NewBoolWrapper(true)}},
+		gokeepasslib.ValueData{Key: "Recovery email", Value: gokeepasslib.V{Content: "octo@example.com"}},
+	)
+	entry.Tags = "dev; work,"
+
+	testor.WriteDatabase(t, filepath.Join(dir, name), testor.NewDatabase(credentials, testor.NewGroup("Root", entry)))
+}
+
+func TestLoadEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	keepass, err := NewLoader(DirFS(dir)).Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	entries, err := keepass.Entries()
+	if err != nil {
+		t.Fatalf("Entries() failed: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	if entries[0].Title != "GitHub" || entries[0].Password != "hunter2" {
+		t.Errorf("Unexpected entry: %+v", entries[0])
+	}
+
+	expectedFields := []types.Field{
+		{Key: "API Key", Value: "ghp_token", Protected: true},
+		{Key: "Recovery email", Value: "octo@example.com", Protected: false},
+	}
+	if !slices.Equal(entries[0].Fields, expectedFields) {
+		t.Errorf("Expected custom fields %+v, got %+v", expectedFields, entries[0].Fields)
+	}
+
+	if !slices.Equal(entries[0].Tags, []string{"dev", "work"}) {
+		t.Errorf("Expected tags [dev work], got %v", entries[0].Tags)
+	}
+}
+
+func TestCreateUpdateDeleteAndSave(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	loader := NewLoader(DirFS(dir))
+
+	keepass, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	created, err := keepass.CreateEntry(types.Entry{Title: "AWS", Username: "admin", Password: "s3cret", Group: "Infra/Cloud"})
+	if err != nil {
+		t.Fatalf("CreateEntry() failed: %v", err)
+	}
+
+	if created.Group != "Infra/Cloud" {
+		t.Errorf("Expected group 'Infra/Cloud', got '%s'", created.Group)
+	}
+
+	created.Password = "rotated"
+	created.Group = ""
+
+	updated, err := keepass.UpdateEntry(created)
+	if err != nil {
+		t.Fatalf("UpdateEntry() failed: %v", err)
+	}
+
+	if len(updated.Raw.Histories) != 1 || len(updated.Raw.Histories[0].Entries) != 1 {
+		t.Errorf("Expected previous version in history, got %+v", updated.Raw.Histories)
+	}
+
+	entries, _ := keepass.Entries()
+	if err := keepass.DeleteEntry(entries[0].Raw.UUID); err != nil {
+		t.Fatalf("DeleteEntry() failed: %v", err)
+	}
+
+	if err := keepass.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "test.kdbx"))
+	if err != nil {
+		t.Fatalf("Failed to stat saved database: %v", err)
+	}
+
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	reloaded, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Reloading saved database failed: %v", err)
+	}
+
+	entries, err = reloaded.Entries()
+	if err != nil {
+		t.Fatalf("Entries() failed: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry after save, got %d", len(entries))
+	}
+
+	if entries[0].Title != "AWS" || entries[0].Password != "rotated" || entries[0].Group != "" {
+		t.Errorf("Unexpected entry after save: %+v", entries[0])
+	}
+
+	if entries[0].Raw.Histories[0].Entries[0].GetPassword() != "s3cret" {
+		t.Error("Expected history to keep the previous password")
+	}
+
+	if len(reloaded.database.Content.Root.DeletedObjects) != 1 {
+		t.Errorf("Expected 1 deleted object, got %d", len(reloaded.database.Content.Root.DeletedObjects))
+	}
+
+	// Every save draws a new KDF salt and inner stream key
+	salt := reloaded.database.Header.FileHeaders.KdfParameters.Salt
+	streamKey := slices.Clone(reloaded.database.Content.InnerHeader.InnerRandomStreamKey)
+
+	if err := reloaded.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	resaved, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Reloading saved database failed: %v", err)
+	}
+
+	if resaved.database.Header.FileHeaders.KdfParameters.Salt == salt || slices.Equal(resaved.database.Content.InnerHeader.InnerRandomStreamKey, streamKey) {
+		t.Error("Expected a new KDF salt and inner stream key")
+	}
+
+	if entries := entriesByTitle(t, resaved); entries["AWS"].Password != "rotated" {
+		t.Errorf("Expected the protected values to be kept, got '%s'", entries["AWS"].Password)
+	}
+}
+
+func TestDeleteEntryToRecycleBin(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	keepass, _ := NewLoader(DirFS(dir)).Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	keepass.database.Content.Meta.RecycleBinEnabled = w.NewBoolWrapper(true)
+
+	github := entriesByTitle(t, keepass)["GitHub"]
+	if err := keepass.DeleteEntry(github.Raw.UUID); err != nil {
+		t.Fatalf("DeleteEntry() failed: %v", err)
+	}
+
+	// The recycle bin is created on first use
+	recycled := entriesByTitle(t, keepass)["GitHub"]
+	if recycled.Group != "Recycle Bin" || recycled.Password != "hunter2" {
+		t.Errorf("Expected GitHub in the recycle bin, got %+v", recycled)
+	}
+
+	if info := keepass.Info(); info.RecycleBinGroup != "Recycle Bin" {
+		t.Errorf("Expected the recycle bin in the metadata, got %+v", info)
+	}
+
+	if len(keepass.database.Content.Root.DeletedObjects) != 0 {
+		t.Error("Expected a recycled entry not to be recorded as deleted")
+	}
+
+	// Deleting from the recycle bin removes the entry
+	if err := keepass.DeleteEntry(github.Raw.UUID); err != nil {
+		t.Fatalf("DeleteEntry() failed: %v", err)
+	}
+
+	if entries, _ := keepass.Entries(); len(entries) != 0 {
+		t.Errorf("Expected the entry to be removed, got %d entries", len(entries))
+	}
+
+	if len(keepass.database.Content.Root.DeletedObjects) != 1 {
+		t.Error("Expected the removed entry to be recorded as deleted")
+	}
+}
+
+func TestUpdateUnknownEntry(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	keepass, err := NewLoader(DirFS(dir)).Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	_, err = keepass.UpdateEntry(types.Entry{Title: "Unknown", Raw: gokeepasslib.NewEntry()})
+	if err != ErrEntryNotFound {
+		t.Errorf("Expected ErrEntryNotFound, got %v", err)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	keepass, err := NewLoader(DirFS(dir)).Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	created, err := keepass.CreateEntry(types.Entry{Title: "AWS", Group: "Infra/Cloud"})
+	if err != nil {
+		t.Fatalf("CreateEntry() failed: %v", err)
+	}
+
+	root, err := keepass.Groups()
+	if err != nil {
+		t.Fatalf("Groups() failed: %v", err)
+	}
+
+	if root.Path != "" || root.EntryCount != 1 || root.TotalEntries() != 2 {
+		t.Errorf("Unexpected root group: %+v", root)
+	}
+
+	if len(root.Groups) != 1 || len(root.Groups[0].Groups) != 1 {
+		t.Fatalf("Expected Infra/Cloud groups, got %+v", root.Groups)
+	}
+
+	cloud := root.Groups[0].Groups[0]
+	if cloud.Path != "Infra/Cloud" || cloud.EntryCount != 1 {
+		t.Errorf("Unexpected cloud group: %+v", cloud)
+	}
+
+	if created.GroupUUID != cloud.UUID {
+		t.Error("Expected entry to reference its group UUID")
+	}
+
+	if len(root.All()) != 3 {
+		t.Errorf("Expected 3 groups in total, got %d", len(root.All()))
+	}
+}
+
+func TestLoadArgon2id(t *testing.T) {
+	dir := t.TempDir()
+
+	// gokeepasslib writes the Argon2id settings, but derives the key with AES-KDF
+	database := testor.NewDatabase(gokeepasslib.NewPasswordCredentials(testPassword))
+	database.Header.FileHeaders.KdfParameters.UUID = KdfArgon2id
+	testor.WriteDatabase(t, filepath.Join(dir, "argon2id.kdbx"), database)
+
+	_, err := NewLoader(DirFS(dir)).Load("argon2id.kdbx", PasswordCredentials([]byte("wrong")))
+	if !errors.Is(err, ErrUnsupportedKDF) {
+		t.Errorf("Expected ErrUnsupportedKDF rather than a wrong password, got %v", err)
+	}
+}
+
+func TestLoadWithKeyFile(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	keyHash := sha256.Sum256(key)
+
+	keyFiles := map[string][]byte{
+		"binary":    key,
+		"hex":       []byte(hex.EncodeToString(key)),
+		"arbitrary": []byte("any file works as a key file"),
+		"xml v1": []byte(`<?xml version="1.0" encoding="utf-8"?>
+<KeyFile><Meta><Version>1.00</Version></Meta><Key><Data>` + base64.StdEncoding.EncodeToString(key) + `</Data></Key></KeyFile>`),
+		"xml v2": []byte(`<?xml version="1.0" encoding="utf-8"?>
+<KeyFile><Meta><Version>2.0</Version></Meta><Key><Data Hash="` + strings.ToUpper(hex.EncodeToString(keyHash[:4])) + `">` +
+			strings.ToUpper(hex.EncodeToString(key)) + `</Data></Key></KeyFile>`),
+	}
+
+	for name, keyFile := range keyFiles {
+		for _, password := range []string{"", testPassword} {
+			t.Run(fmt.Sprintf("%s with password %q", name, password), func(t *testing.T) {
+				dir := t.TempDir()
+				if err := os.WriteFile(filepath.Join(dir, "db.key"), keyFile, 0o600); err != nil {
+					t.Fatalf("Failed to write key file: %v", err)
+				}
+
+				var (
+					credentials *gokeepasslib.DBCredentials
+					err         error
+				)
+
+				if password == "" {
+					credentials, err = gokeepasslib.NewKeyDataCredentials(keyFile)
+				} else {
+					credentials, err = gokeepasslib.NewPasswordAndKeyDataCredentials(password, keyFile)
+				}
+
+				if err != nil {
+					t.Fatalf("Failed to build credentials: %v", err)
+				}
+
+				writeTestDatabaseWithCredentials(t, dir, "test.kdbx", credentials)
+
+				loader := NewLoader(DirFS(dir))
+
+				keepass, err := loader.Load("test.kdbx", Credentials{Password: []byte(password), KeyFile: "db.key"})
+				if err != nil {
+					t.Fatalf("Load() failed: %v", err)
+				}
+
+				if entries, _ := keepass.Entries(); len(entries) != 1 {
+					t.Errorf("Expected 1 entry, got %d", len(entries))
+				}
+
+				// The key file alone, or the password alone, is not enough when both are required
+				if password != "" {
+					if _, err := loader.Load("test.kdbx", Credentials{Password: nil, KeyFile: "db.key"}); err == nil {
+						t.Error("Expected loading without the password to fail")
+					}
+				}
+
+				if _, err := loader.Load("test.kdbx", PasswordCredentials([]byte(password))); err == nil {
+					t.Error("Expected loading without the key file to fail")
+				}
+			})
+		}
+	}
+}
+
+func TestChangedAndReload(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	loader := NewLoader(DirFS(dir))
+
+	keepass, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if changed, err := keepass.Changed(); err != nil || changed {
+		t.Fatalf("Expected a freshly loaded database to be unchanged, got %v, %v", changed, err)
+	}
+
+	// Saving is not an external change
+	if err := keepass.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	if changed, _ := keepass.Changed(); changed {
+		t.Error("Expected own save not to be reported as a change")
+	}
+
+	// Another program adds an entry
+	other, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if _, err := other.CreateEntry(types.Entry{Title: "Synced"}); err != nil {
+		t.Fatalf("CreateEntry() failed: %v", err)
+	}
+
+	if err := other.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	if changed, _ := keepass.Changed(); !changed {
+		t.Fatal("Expected the external save to be reported as a change")
+	}
+
+	reloaded, err := keepass.Reload()
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+
+	entries, _ := reloaded.Entries()
+	if len(entries) != 2 {
+		t.Errorf("Expected the synced entry after reloading, got %d entries", len(entries))
+	}
+
+	if changed, _ := reloaded.Changed(); changed {
+		t.Error("Expected the reloaded database to be unchanged")
+	}
+}
+
+// setModified backdates or postdates the last change of an entry.
+func setModified(t *testing.T, keepass *KeePass, title string, modified time.Time) {
+	t.Helper()
+
+	entries, _ := keepass.Entries()
+	for _, entry := range entries {
+		if entry.Title == title {
+			group, index, _ := findEntry(&keepass.database.Content.Root.Groups[0], "", entry.Raw.UUID)
+			wrapper := w.TimeWrapper{Formatted: false, Time: modified}
+			group.Entries[index].Times.LastModificationTime = &wrapper
+
+			return
+		}
+	}
+
+	t.Fatalf("No entry %s", title)
+}
+
+func entriesByTitle(t *testing.T, keepass *KeePass) map[string]types.Entry {
+	t.Helper()
+
+	entries, err := keepass.Entries()
+	if err != nil {
+		t.Fatalf("Entries() failed: %v", err)
+	}
+
+	byTitle := map[string]types.Entry{}
+	for _, entry := range entries {
+		byTitle[entry.Title] = entry
+	}
+
+	return byTitle
+}
+
+func TestMergeAndSave(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	loader := NewLoader(DirFS(dir))
+	credentials := PasswordCredentials([]byte(testPassword))
+
+	base, _ := loader.Load("test.kdbx", credentials)
+	for _, title := range []string{"Gmail", "Bank", "Jira", "Wiki"} {
+		if _, err := base.CreateEntry(types.Entry{Title: title, Password: "initial", Group: "Work"}); err != nil {
+			t.Fatalf("CreateEntry() failed: %v", err)
+		}
+	}
+
+	if err := base.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	ours, _ := loader.Load("test.kdbx", credentials)
+	theirs, _ := loader.Load("test.kdbx", credentials)
+	now := time.Now()
+
+	update := func(keepass *KeePass, title string, password string, modified time.Time) {
+		entry := entriesByTitle(t, keepass)[title]
+		entry.Password = password
+
+		if _, err := keepass.UpdateEntry(entry); err != nil {
+			t.Fatalf("UpdateEntry() failed: %v", err)
+		}
+
+		setModified(t, keepass, title, modified)
+	}
+
+	// Another program changes, adds and deletes entries, then saves
+	update(theirs, "Gmail", "theirs", now.Add(time.Hour))
+	update(theirs, "Jira", "theirs", now.Add(2*time.Hour))
+
+	if _, err := theirs.CreateEntry(types.Entry{Title: "AWS", Password: "theirs", Group: "Work/Infra"}); err != nil {
+		t.Fatalf("CreateEntry() failed: %v", err)
+	}
+
+	for _, title := range []string{"Bank", "Wiki"} {
+		if err := theirs.DeleteEntry(entriesByTitle(t, theirs)[title].Raw.UUID); err != nil {
+			t.Fatalf("DeleteEntry() failed: %v", err)
+		}
+	}
+
+	if err := theirs.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	// Meanwhile, other entries change here, Jira before the other program
+	// and Wiki after its deletion. Times are an hour apart, files keep seconds
+	update(ours, "GitHub", "ours", now.Add(time.Hour))
+	update(ours, "Jira", "ours", now.Add(time.Hour))
+	update(ours, "Wiki", "ours", now.Add(time.Hour))
+
+	if err := ours.MergeAndSave(); err != nil {
+		t.Fatalf("MergeAndSave() failed: %v", err)
+	}
+
+	if changed, _ := ours.Changed(); changed {
+		t.Error("Expected the merged database to be unchanged")
+	}
+
+	saved, err := loader.Load("test.kdbx", credentials)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	entries := entriesByTitle(t, saved)
+
+	for title, password := range map[string]string{"GitHub": "ours", "Gmail": "theirs", "Jira": "theirs", "AWS": "theirs", "Wiki": "ours"} {
+		if entries[title].Password != password {
+			t.Errorf("Expected the password of %s to be '%s', got '%s'", title, password, entries[title].Password)
+		}
+	}
+
+	if _, ok := entries["Bank"]; ok {
+		t.Error("Expected Bank to stay deleted")
+	}
+
+	if entries["AWS"].Group != "Work/Infra" {
+		t.Errorf("Expected AWS in its new group, got '%s'", entries["AWS"].Group)
+	}
+
+	var history []string
+	for _, version := range entries["Jira"].Raw.Histories[0].Entries {
+		history = append(history, version.GetPassword())
+	}
+
+	// The history of both sides, then the overwritten version
+	if !slices.Equal(history, []string{"initial", "ours"}) {
+		t.Errorf("Expected both previous versions of Jira in history, got %v", history)
+	}
+}
+
+func TestMergeUnrelatedDatabases(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "a.kdbx")
+	writeTestDatabase(t, dir, "b.kdbx")
+
+	loader := NewLoader(DirFS(dir))
+	credentials := PasswordCredentials([]byte(testPassword))
+
+	a, _ := loader.Load("a.kdbx", credentials)
+	b, _ := loader.Load("b.kdbx", credentials)
+
+	if _, err := b.CreateEntry(types.Entry{Title: "Server", Group: "Infra"}); err != nil {
+		t.Fatalf("CreateEntry() failed: %v", err)
+	}
+
+	// Attachments and custom icons are copied along their entry
+	group, index, _ := findEntry(&b.database.Content.Root.Groups[0], "", entriesByTitle(t, b)["Server"].Raw.UUID)
+	binary := b.database.AddBinary([]byte("ssh key"))
+	group.Entries[index].Binaries = append(group.Entries[index].Binaries, binary.CreateReference("id_ed25519"))
+
+	icon := gokeepasslib.CustomIcon{UUID: gokeepasslib.NewUUID(), Data: "iVBORw0KGgo="}
+	b.database.Content.Meta.CustomIcons = append(b.database.Content.Meta.CustomIcons, icon)
+	group.Entries[index].CustomIconUUID = icon.UUID
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+
+	if err := a.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	saved, _ := loader.Load("a.kdbx", credentials)
+
+	entries, _ := saved.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("Expected both GitHub entries and Server, got %d entries", len(entries))
+	}
+
+	server := entriesByTitle(t, saved)["Server"]
+	if server.Group != "Infra" || len(server.Raw.Binaries) != 1 {
+		t.Fatalf("Expected Server in Infra with its attachment, got %+v", server)
+	}
+
+	content, err := saved.database.FindBinary(server.Raw.Binaries[0].Value.ID).GetContentBytes()
+	if err != nil || string(content) != "ssh key" {
+		t.Errorf("Expected the attachment content, got '%s', %v", content, err)
+	}
+
+	if icons := saved.database.Content.Meta.CustomIcons; len(icons) != 1 || icons[0] != icon {
+		t.Errorf("Expected the custom icon of Server, got %v", icons)
+	}
+
+	// Merging again adds nothing
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+
+	if icons := a.database.Content.Meta.CustomIcons; len(icons) != 1 {
+		t.Errorf("Expected the custom icon once, got %d icons", len(icons))
+	}
+}
+
+func TestMergeVersions(t *testing.T) {
+	modified := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	uuid := gokeepasslib.NewUUID()
+
+	version := func(password string, modified time.Time) gokeepasslib.Entry {
+		entry := gokeepasslib.NewEntry()
+		entry.UUID = uuid
+		entry.Values = append(entry.Values, gokeepasslib.ValueData{Key: "Password", Value: gokeepasslib.V{Content: password}})
+		wrapper := w.TimeWrapper{Formatted: false, Time: modified}
+		entry.Times.LastModificationTime = &wrapper
+
+		return entry
+	}
+
+	ours := version("ours", modified.Add(time.Hour))
+	ours.Histories = []gokeepasslib.History{{Entries: []gokeepasslib.Entry{version("a", modified), version("shared", modified.Add(time.Minute))}}}
+	theirs := version("theirs", modified.Add(2*time.Hour))
+	theirs.Histories = []gokeepasslib.History{{Entries: []gokeepasslib.Entry{version("b", modified), version("shared", modified.Add(time.Minute))}}}
+
+	merged, err := mergeVersions(ours, theirs, -1)
+	if err != nil {
+		t.Fatalf("mergeVersions() failed: %v", err)
+	}
+
+	var history []string
+	for _, version := range merged.Histories[0].Entries {
+		history = append(history, version.GetPassword())
+	}
+
+	// Versions saved in the same second are both kept, identical ones once
+	slices.Sort(history[:2])
+
+	if merged.GetPassword() != "theirs" || !slices.Equal(history, []string{"a", "b", "shared", "ours"}) {
+		t.Errorf("Expected theirs with a, b, shared and ours in history, got %s with %v", merged.GetPassword(), history)
+	}
+}
+
+func TestMergeIntoMissingGroup(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	keepass, _ := NewLoader(DirFS(dir)).Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	merger := &merger{
+		target:   keepass.database,
+		source:   keepass.database,
+		deleted:  map[gokeepasslib.UUID]time.Time{},
+		binaries: map[int]int{},
+	}
+
+	entry := gokeepasslib.NewEntry()
+	entry.Values = append(entry.Values, gokeepasslib.ValueData{Key: "Title", Value: gokeepasslib.V{Content: "Orphan"}})
+
+	if err := merger.mergeEntry(entry, gokeepasslib.NewUUID()); err != nil {
+		t.Fatalf("mergeEntry() failed: %v", err)
+	}
+
+	if orphan, ok := entriesByTitle(t, keepass)["Orphan"]; !ok || orphan.Group != "" {
+		t.Errorf("Expected the entry of a missing group in the root, got %+v", orphan)
+	}
+}
+
+func TestHistoryAndRestoreVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	keepass, err := NewLoader(DirFS(dir)).Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	entry, _ := keepass.CreateEntry(types.Entry{Title: "AWS", Password: "first", Group: "Infra"})
+	setModified(t, keepass, "AWS", time.Now().Add(-2*time.Hour))
+
+	entry.Password = "second"
+	_, _ = keepass.UpdateEntry(entry)
+	setModified(t, keepass, "AWS", time.Now().Add(-time.Hour))
+
+	entry.Password = "third"
+	entry, _ = keepass.UpdateEntry(entry)
+
+	versions := History(entry)
+	if len(versions) != 2 || versions[0].Password != "second" || versions[1].Password != "first" {
+		t.Fatalf("Expected the previous versions, most recent first, got %+v", versions)
+	}
+
+	if versions[0].Group != "Infra" || versions[0].GroupUUID != entry.GroupUUID {
+		t.Errorf("Expected versions in the group of the entry, got '%s'", versions[0].Group)
+	}
+
+	restored, err := keepass.RestoreVersion(entry.Raw.UUID, versions[1].Modified)
+	if err != nil {
+		t.Fatalf("RestoreVersion() failed: %v", err)
+	}
+
+	if restored.Password != "first" {
+		t.Errorf("Expected the first password to be restored, got '%s'", restored.Password)
+	}
+
+	// The replaced version is kept
+	if versions := History(restored); len(versions) != 3 || versions[0].Password != "third" {
+		t.Errorf("Expected the replaced version in history, got %+v", versions)
+	}
+
+	if _, err := keepass.RestoreVersion(entry.Raw.UUID, time.Time{}); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	loader := NewLoader(DirFS(dir))
+
+	keepass, _ := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	raw := &keepass.database.Content.Root.Groups[0].Entries[0]
+	binary := keepass.database.AddBinary([]byte("ssh key"))
+	raw.Binaries = append(raw.Binaries, binary.CreateReference("id_ed25519"))
+
+	if err := keepass.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	saved, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	entries, _ := saved.Entries()
+
+	expected := []types.Attachment{{Name: "id_ed25519", Size: 7}}
+	if !slices.Equal(entries[0].Attachments, expected) {
+		t.Errorf("Expected attachments %+v, got %+v", expected, entries[0].Attachments)
+	}
+
+	content, err := saved.Attachment(entries[0].Raw.UUID, "id_ed25519")
+	if err != nil || string(content) != "ssh key" {
+		t.Errorf("Expected the attachment content, got '%s', %v", content, err)
+	}
+
+	if _, err := saved.Attachment(entries[0].Raw.UUID, "missing"); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("Expected ErrAttachmentNotFound, got %v", err)
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PASSWORDS", "passwords")
+
+	dir := filepath.Join(home, "passwords")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	if err := os.Symlink(filepath.Join(dir, "test.kdbx"), filepath.Join(home, "link.kdbx")); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+
+	// The home directory may itself be behind a link
+	expected, _ := filepath.EvalSymlinks(filepath.Join(dir, "test.kdbx"))
+
+	for _, path := range []string{"~/passwords/test.kdbx", " $HOME/$PASSWORDS/test.kdbx ", "~/link.kdbx", dir + "/../passwords/test.kdbx"} {
+		canonical, err := CanonicalPath(path)
+		if err != nil || canonical != expected {
+			t.Errorf("Expected %s for %s, got %s, %v", expected, path, canonical, err)
+		}
+	}
+
+	if _, err := CanonicalPath("~/missing.kdbx"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file to be reported, got %v", err)
+	}
+
+	if err := CheckDatabase(expected); err != nil {
+		t.Errorf("CheckDatabase() failed: %v", err)
+	}
+
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, path := range []string{notes, dir} {
+		if err := CheckDatabase(path); err == nil {
+			t.Errorf("Expected %s to be refused", path)
+		}
+	}
+
+	if err := CheckDatabase(notes); !errors.Is(err, ErrNotKDBX) {
+		t.Errorf("Expected ErrNotKDBX, got %v", err)
+	}
+
+	// Absolute paths are loaded from the root of the filesystem
+	database, err := NewLoader(RootFS()).Load(expected, PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if err := database.Save(); err != nil {
+		t.Errorf("Save() failed: %v", err)
+	}
+}
+
+func TestCompletePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	for _, dir := range []string{"Sync", "Sites", ".config"} {
+		if err := os.Mkdir(filepath.Join(home, dir), 0o700); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	writeTestDatabase(t, filepath.Join(home, "Sync"), "personal.kdbx")
+	writeTestDatabase(t, filepath.Join(home, "Sync"), "perso-old.kdbx")
+
+	if err := os.WriteFile(filepath.Join(home, "Sync", "personal.txt"), []byte("notes"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, test := range []struct {
+		path       string
+		completed  string
+		candidates []string
+	}{
+		{"~", "~/", nil},
+		{"~/Sy", "~/Sync/", []string{"Sync/"}},
+		{"~/S", "~/S", []string{"Sites/", "Sync/"}},
+		{"~/.c", "~/.config/", []string{".config/"}},
+		// Only databases are completed, not other files
+		{"~/Sync/person", "~/Sync/personal.kdbx", []string{"personal.kdbx"}},
+		{"~/Sync/p", "~/Sync/perso", []string{"perso-old.kdbx", "personal.kdbx"}},
+		{"~/Missing/p", "~/Missing/p", nil},
+	} {
+		completed, candidates := CompletePath(test.path)
+		if completed != test.completed || !slices.Equal(candidates, test.candidates) {
+			t.Errorf("Expected %s %v for %s, got %s %v", test.completed, test.candidates, test.path, completed, candidates)
+		}
+	}
+}
+
+func TestFindDatabases(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	for _, dir := range []string{"Sync/work", "Sync/.stversions", "Nextcloud"} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0o700); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	writeTestDatabase(t, filepath.Join(home, "Sync"), "personal.kdbx")
+	writeTestDatabase(t, filepath.Join(home, "Sync", "work"), "work.KDBX")
+	writeTestDatabase(t, filepath.Join(home, "Sync", ".stversions"), "personal~20240101.kdbx")
+	writeTestDatabase(t, filepath.Join(home, "Nextcloud"), "shared.kdbx")
+
+	// Files named like databases without being one are left out
+	if err := os.WriteFile(filepath.Join(home, "Nextcloud", "fake.kdbx"), []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	found, err := FindDatabases([]string{"~/Sync", "$HOME/Nextcloud", "~/Dropbox", "~/Sync/work"})
+	if err != nil {
+		t.Fatalf("FindDatabases() failed: %v", err)
+	}
+
+	root, _ := filepath.EvalSymlinks(home)
+	expected := []string{
+		filepath.Join(root, "Nextcloud", "shared.kdbx"),
+		filepath.Join(root, "Sync", "personal.kdbx"),
+		filepath.Join(root, "Sync", "work", "work.KDBX"),
+	}
+
+	if !slices.Equal(found, expected) {
+		t.Errorf("Expected %v, got %v", expected, found)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	loader := NewLoader(DirFS(dir))
+
+	// The header is read without the password
+	header, err := loader.ReadHeader("test.kdbx")
+	if err != nil {
+		t.Fatalf("ReadHeader() failed: %v", err)
+	}
+
+	expected := Header{
+		Version:     "4.0",
+		Cipher:      "ChaCha20",
+		Compression: "gzip",
+		KDF:         KDF{Type: Argon2d, Rounds: 0, Iterations: 2, Memory: 1024 * 1024, Parallelism: 2},
+	}
+	if header != expected {
+		t.Errorf("Expected %+v, got %+v", expected, header)
+	}
+
+	if header.KDF.String() != "Argon2d, 2 iterations, 1 MiB, 2 threads" {
+		t.Errorf("Unexpected KDF description %q", header.KDF.String())
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := loader.ReadHeader("notes.txt"); err == nil {
+		t.Error("Expected other files to be reported")
+	}
+
+	database, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	// The recycle bin of KeePass is a group named in the metadata
+	if _, err := database.CreateEntry(types.Entry{Title: "Old", Group: "Recycle Bin"}); err != nil {
+		t.Fatalf("CreateEntry() failed: %v", err)
+	}
+
+	groups, err := database.Groups()
+	if err != nil {
+		t.Fatalf("Groups() failed: %v", err)
+	}
+
+	database.database.Content.Meta.RecycleBinEnabled = w.NewBoolWrapper(true)
+	database.database.Content.Meta.RecycleBinUUID = groups.Groups[0].UUID
+	database.database.Content.Meta.DatabaseName = "Personal"
+
+	info := database.Info()
+	if info.Header != expected || info.Name != "Personal" {
+		t.Errorf("Expected the header and name, got %+v", info)
+	}
+
+	if info.Entries != 2 || info.Groups != 1 {
+		t.Errorf("Expected 2 entries and 1 group, got %d and %d", info.Entries, info.Groups)
+	}
+
+	if !info.RecycleBin || info.RecycleBinGroup != "Recycle Bin" {
+		t.Errorf("Expected the recycle bin group, got %+v", info)
+	}
+}
+
+func TestChangeMasterKey(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabase(t, dir, "test.kdbx")
+
+	if err := os.WriteFile(filepath.Join(dir, "db.key"), []byte("any file works as a key file"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	loader := NewLoader(DirFS(dir))
+
+	database, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	aesKDF := KDF{Type: AESKDF, Rounds: 1000, Iterations: 0, Memory: 0, Parallelism: 0}
+	if err := database.ChangeMasterKey(PasswordCredentials([]byte("new password")), aesKDF); err != nil {
+		t.Fatalf("ChangeMasterKey() failed: %v", err)
+	}
+
+	if _, err := loader.Load("test.kdbx", PasswordCredentials([]byte(testPassword))); err == nil {
+		t.Error("Expected the old password to be refused")
+	}
+
+	reloaded, err := loader.Load("test.kdbx", PasswordCredentials([]byte("new password")))
+	if err != nil {
+		t.Fatalf("Load() with the new password failed: %v", err)
+	}
+
+	if info := reloaded.Info(); info.KDF != aesKDF || info.MasterKeyChanged.IsZero() {
+		t.Errorf("Expected AES-KDF and the master key change time, got %+v", info)
+	}
+
+	// A nil password keeps the current one, here adding a key file
+	argon2KDF := KDF{Type: Argon2d, Rounds: 0, Iterations: 1, Memory: 1024 * 1024, Parallelism: 1}
+	if err := database.ChangeMasterKey(Credentials{Password: nil, KeyFile: "db.key"}, argon2KDF); err != nil {
+		t.Fatalf("ChangeMasterKey() failed: %v", err)
+	}
+
+	reloaded, err = loader.Load("test.kdbx", Credentials{Password: []byte("new password"), KeyFile: "db.key"})
+	if err != nil {
+		t.Fatalf("Load() with the password and key file failed: %v", err)
+	}
+
+	if entries, _ := reloaded.Entries(); len(entries) != 1 || reloaded.Info().KDF != argon2KDF {
+		t.Errorf("Expected the entry encrypted with Argon2d, got %d entries and %v", len(entries), reloaded.Info().KDF)
+	}
+
+	// gokeepasslib would write a file announcing Argon2id but derived with AES-KDF
+	argon2idKDF := argon2KDF
+	argon2idKDF.Type = Argon2id
+
+	if err := database.ChangeMasterKey(PasswordCredentials([]byte("other")), argon2idKDF); !errors.Is(err, ErrUnsupportedKDF) {
+		t.Errorf("Expected ErrUnsupportedKDF, got %v", err)
+	}
+
+	// Without a password, the key file alone protects the database
+	if err := database.ChangeMasterKey(Credentials{Password: []byte{}, KeyFile: "db.key"}, argon2KDF); err != nil {
+		t.Fatalf("ChangeMasterKey() failed: %v", err)
+	}
+
+	if _, err := loader.Load("test.kdbx", Credentials{Password: nil, KeyFile: "db.key"}); err != nil {
+		t.Errorf("Load() with the key file alone failed: %v", err)
+	}
+
+	if err := database.ChangeMasterKey(Credentials{Password: nil, KeyFile: ""}, argon2KDF); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("Expected ErrNoMasterKey, got %v", err)
+	}
+
+	// An empty password alone is no master key either
+	if err := database.ChangeMasterKey(PasswordCredentials([]byte{}), argon2KDF); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("Expected ErrNoMasterKey for an empty password, got %v", err)
+	}
+
+	if _, err := loader.Load("test.kdbx", Credentials{Password: nil, KeyFile: "db.key"}); err != nil {
+		t.Errorf("Expected the refused change to keep the key file, got %v", err)
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	kdf, err := Benchmark(DefaultKDF(AESKDF), 10*time.Millisecond)
+	if err != nil || kdf.Type != AESKDF || kdf.Rounds == 0 {
+		t.Errorf("Expected AES-KDF rounds, got %+v, %v", kdf, err)
+	}
+
+	argon2KDF := KDF{Type: Argon2d, Rounds: 0, Iterations: 0, Memory: 1024 * 1024, Parallelism: 1}
+
+	kdf, err = Benchmark(argon2KDF, 10*time.Millisecond)
+	if err != nil || kdf.Iterations == 0 || kdf.Memory != argon2KDF.Memory || kdf.Parallelism != 1 {
+		t.Errorf("Expected Argon2d iterations for the same memory and threads, got %+v, %v", kdf, err)
+	}
+
+	argon2KDF.Memory = 1024
+	if _, err := Benchmark(argon2KDF, 10*time.Millisecond); err == nil {
+		t.Error("Expected too little memory to be reported")
+	}
+
+	if _, err := Benchmark(DefaultKDF(Argon2id), time.Second); !errors.Is(err, ErrUnsupportedKDF) {
+		t.Errorf("Expected ErrUnsupportedKDF, got %v", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	loader := NewLoader(DirFS(dir))
+	kdf := KDF{Type: Argon2d, Rounds: 0, Iterations: 1, Memory: 1024 * 1024, Parallelism: 1}
+
+	if err := loader.Create("personal.kdbx", "Personal", PasswordCredentials([]byte(testPassword)), kdf); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "personal.kdbx"))
+	if err != nil {
+		t.Fatalf("Expected the database file: %v", err)
+	}
+
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the database to be readable by the user only, got %v", info.Mode().Perm())
+	}
+
+	database, err := loader.Load("personal.kdbx", PasswordCredentials([]byte(testPassword)))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	created := database.Info()
+	if created.Name != "Personal" || created.Entries != 0 || created.Groups != 0 {
+		t.Errorf("Expected an empty database named Personal, got %+v", created)
+	}
+
+	if created.Version != "4.0" || created.KDF != kdf {
+		t.Errorf("Expected KDBX 4.0 with the Argon2d settings, got %+v", created.Header)
+	}
+
+	if err := loader.Create("personal.kdbx", "Other", PasswordCredentials([]byte("other")), kdf); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Expected the existing database to be kept, got %v", err)
+	}
+
+	if err := loader.Create("empty.kdbx", "Empty", PasswordCredentials(nil), kdf); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("Expected ErrNoMasterKey, got %v", err)
+	}
+
+	argon2id := kdf
+	argon2id.Type = Argon2id
+
+	if err := loader.Create("argon2id.kdbx", "Argon2id", PasswordCredentials([]byte(testPassword)), argon2id); !errors.Is(err, ErrUnsupportedKDF) {
+		t.Errorf("Expected ErrUnsupportedKDF, got %v", err)
+	}
+
+	// A key file alone protects the database
+	if err := os.WriteFile(filepath.Join(dir, "db.key"), []byte("any file works as a key file"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	credentials := Credentials{Password: nil, KeyFile: "db.key"}
+	if err := loader.Create("keyfile.kdbx", "Key File", credentials, kdf); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	if _, err := loader.Load("keyfile.kdbx", credentials); err != nil {
+		t.Errorf("Load() with the key file failed: %v", err)
+	}
+}
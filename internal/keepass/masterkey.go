@@ -0,0 +1,247 @@
+package keepass
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tobischo/argon2"
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// ErrUnsupportedKDF is returned for key derivation settings a database cannot
+// be encrypted with, and for databases that cannot be decrypted. gokeepasslib
+// only derives keys with AES-KDF and Argon2d, so a file announcing Argon2id
+// would not open in other clients.
+var ErrUnsupportedKDF = errors.New("unsupported key derivation function")
+
+// ErrNoMasterKey is returned when new credentials have neither a password nor
+// a key file.
+var ErrNoMasterKey = errors.New("a password or a key file is required")
+
+// argon2Version is the version of Argon2 written in the KDF settings, 1.3.
+const argon2Version = 0x13
+
+// benchmarkTime is the least time a benchmark measures, long enough for the
+// timer resolution and short runs not to skew the result.
+const benchmarkTime = 100 * time.Millisecond
+
+// DefaultKDF returns the settings of a new database for the KDF type, those of
+// KeePassXC, to be tuned with Benchmark.
+func DefaultKDF(kdfType string) KDF {
+	if kdfType == AESKDF {
+		return KDF{Type: AESKDF, Rounds: 6_000_000, Iterations: 0, Memory: 0, Parallelism: 0}
+	}
+
+	return KDF{Type: kdfType, Rounds: 0, Iterations: 10, Memory: 64 * 1024 * 1024, Parallelism: 2}
+}
+
+// ChangeMasterKey re-encrypts the database with new credentials and key
+// derivation settings, with a new salt. A nil password keeps the current one.
+// Changes made to the file by other programs are merged in first, while it can
+// still be read with the current credentials.
+func (k *KeePass) ChangeMasterKey(credentials Credentials, kdf KDF) error {
+	// An empty password is hashed like any other, leaving no master key
+	if credentials.Password != nil && len(credentials.Password) == 0 && credentials.KeyFile == "" {
+		return ErrNoMasterKey
+	}
+
+	dbCredentials, err := newCredentials(k.fs, credentials)
+	if err != nil {
+		return err
+	}
+
+	if credentials.Password == nil {
+		dbCredentials.Passphrase = k.database.Credentials.Passphrase
+	}
+
+	if dbCredentials.Passphrase == nil && dbCredentials.Key == nil {
+		return ErrNoMasterKey
+	}
+
+	err = checkKDF(kdf)
+	if err != nil {
+		return err
+	}
+
+	err = k.mergeChanges()
+	if err != nil {
+		return err
+	}
+
+	fileHeaders := k.database.Header.FileHeaders
+	previous := *fileHeaders
+	previousCredentials := k.database.Credentials
+
+	err = setKDF(k.database.Header, kdf)
+	if err != nil {
+		return err
+	}
+
+	k.database.Credentials = dbCredentials
+
+	var previousChanged *w.TimeWrapper
+
+	if meta := k.database.Content.Meta; meta != nil {
+		previousChanged = meta.MasterKeyChanged
+		now := w.Now()
+		meta.MasterKeyChanged = &now
+	}
+
+	err = k.Save()
+	if err != nil {
+		*fileHeaders = previous
+		k.database.Credentials = previousCredentials
+
+		if meta := k.database.Content.Meta; meta != nil {
+			meta.MasterKeyChanged = previousChanged
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// setKDF changes the key derivation settings of the header, with a new salt.
+// KDBX 3 only supports AES-KDF.
+func setKDF(header *gokeepasslib.DBHeader, kdf KDF) error {
+	err := checkKDF(kdf)
+	if err != nil {
+		return err
+	}
+
+	fileHeaders := header.FileHeaders
+
+	if !header.IsKdbx4() {
+		if kdf.Type != AESKDF {
+			return fmt.Errorf("%w: KDBX %d.%d only supports %s", ErrUnsupportedKDF,
+				header.Signature.MajorVersion, header.Signature.MinorVersion, AESKDF)
+		}
+
+		seed := make([]byte, 32)
+
+		_, err := rand.Read(seed)
+		if err != nil {
+			return err
+		}
+
+		fileHeaders.TransformSeed = seed
+		fileHeaders.TransformRounds = kdf.Rounds
+
+		return nil
+	}
+
+	parameters := &gokeepasslib.KdfParameters{} //nolint:exhaustruct // Only the settings of the KDF are written
+	if kdf.Type == AESKDF {
+		parameters.UUID = gokeepasslib.KdfAES4
+		parameters.Rounds = kdf.Rounds
+	} else {
+		parameters.UUID = gokeepasslib.KdfArgon2
+		parameters.Iterations = kdf.Iterations
+		parameters.Memory = kdf.Memory
+		parameters.Parallelism = kdf.Parallelism
+		parameters.Version = argon2Version
+	}
+
+	_, err = rand.Read(parameters.Salt[:])
+	if err != nil {
+		return err
+	}
+
+	fileHeaders.KdfParameters = parameters
+
+	return nil
+}
+
+// checkKDF rejects the KDF types gokeepasslib cannot derive keys with, and the
+// settings Argon2 refuses or gokeepasslib would truncate.
+func checkKDF(kdf KDF) error {
+	switch kdf.Type {
+	case AESKDF:
+		if kdf.Rounds == 0 {
+			return errors.New("AES-KDF needs at least 1 round")
+		}
+
+		return nil
+	case Argon2d:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedKDF, kdf.Type)
+	}
+
+	switch {
+	case kdf.Iterations == 0 || kdf.Iterations > math.MaxUint32:
+		return fmt.Errorf("argon2 iterations must be between 1 and %d", uint32(math.MaxUint32))
+	case kdf.Parallelism == 0 || kdf.Parallelism > math.MaxUint8:
+		return fmt.Errorf("argon2 parallelism must be between 1 and %d", math.MaxUint8)
+	case kdf.Memory < 8*1024*uint64(kdf.Parallelism):
+		return fmt.Errorf("argon2 needs at least %d KiB of memory for %d threads", 8*kdf.Parallelism, kdf.Parallelism)
+	case kdf.Memory > math.MaxUint32:
+		return errors.New("argon2 memory must be below 4 GiB")
+	}
+
+	return nil
+}
+
+// Benchmark tunes the rounds of AES-KDF, or the iterations of Argon2d for its
+// memory and parallelism, so that deriving a key takes about target on this
+// machine.
+func Benchmark(kdf KDF, target time.Duration) (KDF, error) {
+	if kdf.Type == Argon2d {
+		// Tuning needs valid memory and parallelism
+		kdf.Iterations = 1
+	} else {
+		kdf.Rounds = 1
+	}
+
+	err := checkKDF(kdf)
+	if err != nil {
+		return kdf, err
+	}
+
+	if kdf.Type == AESKDF {
+		block, err := aes.NewCipher(make([]byte, 32))
+		if err != nil {
+			return kdf, err
+		}
+
+		rounds := uint64(1 << 16)
+		elapsed := timeAESKDF(block, rounds)
+
+		for elapsed < benchmarkTime {
+			rounds *= 2
+			elapsed = timeAESKDF(block, rounds)
+		}
+
+		kdf.Rounds = max(1, uint64(float64(rounds)*float64(target)/float64(elapsed)))
+
+		return kdf, nil
+	}
+
+	// Each iteration goes over the whole memory once
+	start := time.Now()
+	argon2.DKey([]byte("kagapass"), make([]byte, 32), 1, uint32(kdf.Memory/1024), uint8(kdf.Parallelism), 32)
+	elapsed := time.Since(start)
+
+	kdf.Iterations = min(math.MaxUint32, max(1, uint64(target/max(elapsed, 1))))
+
+	return kdf, nil
+}
+
+// timeAESKDF times the rounds of AES-KDF, as gokeepasslib derives keys.
+func timeAESKDF(block cipher.Block, rounds uint64) time.Duration {
+	key := make([]byte, 32)
+	start := time.Now()
+
+	for range rounds {
+		block.Encrypt(key, key)
+		block.Encrypt(key[16:], key[16:])
+	}
+
+	return time.Since(start)
+}
@@ -0,0 +1,506 @@
+package keepass
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// Merge merges the other database into this one, as KeePass synchronises
+// databases. Entries and groups are matched by UUID, the most recently
+// modified version of each wins and the other one is kept in its history.
+// Objects deleted on either side are removed unless modified after their
+// deletion, and objects moved on either side follow the latest move. The
+// timestamps stand in for the common ancestor of a three-way merge. Custom
+// icons missing here are added.
+func (k *KeePass) Merge(other *KeePass) error {
+	root, err := k.rootGroup()
+	if err != nil {
+		return err
+	}
+
+	otherRoot, err := other.rootGroup()
+	if err != nil {
+		return err
+	}
+
+	merger := &merger{
+		target:   k.database,
+		source:   other.database,
+		deleted:  map[gokeepasslib.UUID]time.Time{},
+		binaries: map[int]int{},
+	}
+
+	merger.mergeDeletions()
+	merger.mergeCustomIcons()
+
+	// Roots always match, even between unrelated databases
+	err = merger.mergeChildren(otherRoot, root.UUID)
+	if err != nil {
+		return err
+	}
+
+	merger.applyDeletions()
+
+	return nil
+}
+
+// MergeAndSave saves the database, first merging in the changes another
+// program made to the file since it was loaded instead of overwriting them.
+func (k *KeePass) MergeAndSave() error {
+	err := k.mergeChanges()
+	if err != nil {
+		return err
+	}
+
+	return k.Save()
+}
+
+// mergeChanges merges in the file when another program wrote it since it was
+// loaded. A missing file has nothing to merge.
+func (k *KeePass) mergeChanges() error {
+	changed, err := k.Changed()
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !changed) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	current, err := k.Reload()
+	if err != nil {
+		return kcore.Wrap(err, "failed to read the database changed on disk")
+	}
+
+	defer func() {
+		err := current.Close()
+		if err != nil {
+			log.Printf("Error closing keepass file: %v", err)
+		}
+	}()
+
+	err = k.Merge(current)
+	if err != nil {
+		return kcore.Wrap(err, "failed to merge the database changed on disk")
+	}
+
+	return nil
+}
+
+type merger struct {
+	target *gokeepasslib.Database
+	source *gokeepasslib.Database
+	// deleted holds the latest deletion time of the objects deleted on
+	// either side
+	deleted map[gokeepasslib.UUID]time.Time
+	// binaries maps attachment IDs of the source to the target
+	binaries map[int]int
+}
+
+func (m *merger) mergeDeletions() {
+	for _, database := range []*gokeepasslib.Database{m.target, m.source} {
+		for _, deleted := range database.Content.Root.DeletedObjects {
+			deletedAt := timeOf(deleted.DeletionTime)
+			if previous, ok := m.deleted[deleted.UUID]; !ok || deletedAt.After(previous) {
+				m.deleted[deleted.UUID] = deletedAt
+			}
+		}
+	}
+
+	known := map[gokeepasslib.UUID]bool{}
+	for _, deleted := range m.target.Content.Root.DeletedObjects {
+		known[deleted.UUID] = true
+	}
+
+	for _, deleted := range m.source.Content.Root.DeletedObjects {
+		if !known[deleted.UUID] {
+			deletedAt := w.TimeWrapper{Formatted: false, Time: m.deleted[deleted.UUID]}
+			m.target.Content.Root.DeletedObjects = append(m.target.Content.Root.DeletedObjects, gokeepasslib.DeletedObjectData{
+				XMLName:      deleted.XMLName,
+				UUID:         deleted.UUID,
+				DeletionTime: &deletedAt,
+			})
+			known[deleted.UUID] = true
+		}
+	}
+
+	// Keep the latest deletion time of objects deleted on both sides
+	for i := range m.target.Content.Root.DeletedObjects {
+		deleted := &m.target.Content.Root.DeletedObjects[i]
+		if deletedAt := m.deleted[deleted.UUID]; deletedAt.After(timeOf(deleted.DeletionTime)) {
+			wrapper := w.TimeWrapper{Formatted: false, Time: deletedAt}
+			deleted.DeletionTime = &wrapper
+		}
+	}
+}
+
+// mergeCustomIcons adds the icons of the source its entries and groups may
+// use. Icons are matched by UUID and never change once added.
+func (m *merger) mergeCustomIcons() {
+	if m.source.Content.Meta == nil || m.target.Content.Meta == nil {
+		return
+	}
+
+	for _, icon := range m.source.Content.Meta.CustomIcons {
+		known := slices.ContainsFunc(m.target.Content.Meta.CustomIcons, func(other gokeepasslib.CustomIcon) bool {
+			return other.UUID == icon.UUID
+		})
+		if !known {
+			m.target.Content.Meta.CustomIcons = append(m.target.Content.Meta.CustomIcons, icon)
+		}
+	}
+}
+
+// isDeleted reports whether the object was deleted after its last change.
+func (m *merger) isDeleted(uuid gokeepasslib.UUID, times gokeepasslib.TimeData) bool {
+	deletedAt, ok := m.deleted[uuid]
+
+	return ok && !deletedAt.Before(timeOf(times.LastModificationTime))
+}
+
+// mergeChildren merges the entries and subgroups of the source group into
+// the target group with the given UUID.
+func (m *merger) mergeChildren(source *gokeepasslib.Group, parent gokeepasslib.UUID) error {
+	for _, entry := range source.Entries {
+		err := m.mergeEntry(entry, parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, group := range source.Groups {
+		err := m.mergeGroup(group, parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *merger) mergeGroup(source gokeepasslib.Group, parent gokeepasslib.UUID) error {
+	root := &m.target.Content.Root.Groups[0]
+	target, targetParent := findGroup(root, nil, source.UUID)
+
+	switch {
+	case target == nil && m.isDeleted(source.UUID, source.Times):
+		// Children changed after the deletion are kept in the parent
+		return m.mergeChildren(&source, parent)
+	case target == nil:
+		group := source
+		group.Times = cloneTimes(source.Times)
+		group.Entries = nil
+		group.Groups = nil
+
+		parentGroup := groupOrRoot(root, parent)
+		parentGroup.Groups = append(parentGroup.Groups, group)
+	default:
+		if timeOf(source.Times.LastModificationTime).After(timeOf(target.Times.LastModificationTime)) {
+			target.Name = source.Name
+			target.Notes = source.Notes
+			target.IconID = source.IconID
+			target.CustomIconUUID = source.CustomIconUUID
+			target.IsExpanded = source.IsExpanded
+			target.DefaultAutoTypeSequence = source.DefaultAutoTypeSequence
+			target.EnableAutoType = source.EnableAutoType
+			target.EnableSearching = source.EnableSearching
+			target.Times = cloneTimes(source.Times)
+		}
+
+		if targetParent != nil && targetParent.UUID != parent && timeOf(source.Times.LocationChanged).After(timeOf(target.Times.LocationChanged)) {
+			moveGroup(root, source.UUID, parent, source.Times.LocationChanged)
+		}
+	}
+
+	return m.mergeChildren(&source, source.UUID)
+}
+
+func (m *merger) mergeEntry(source gokeepasslib.Entry, parent gokeepasslib.UUID) error {
+	root := &m.target.Content.Root.Groups[0]
+
+	incoming, err := m.cloneEntry(source)
+	if err != nil {
+		return err
+	}
+
+	group, index, _ := findEntry(root, "", source.UUID)
+	if group == nil {
+		if m.isDeleted(source.UUID, source.Times) {
+			return nil
+		}
+
+		parentGroup := groupOrRoot(root, parent)
+		parentGroup.Entries = append(parentGroup.Entries, incoming)
+
+		return nil
+	}
+
+	target := &group.Entries[index]
+	moved := group.UUID != parent && timeOf(source.Times.LocationChanged).After(timeOf(target.Times.LocationChanged))
+
+	merged, err := mergeVersions(*target, incoming, m.target.Content.Meta.HistoryMaxItems)
+	if err != nil {
+		return err
+	}
+
+	*target = merged
+
+	if moved {
+		entry := *target
+		entry.Times.LocationChanged = source.Times.LocationChanged
+		group.Entries = slices.Delete(group.Entries, index, index+1)
+
+		parentGroup := groupOrRoot(root, parent)
+		parentGroup.Entries = append(parentGroup.Entries, entry)
+	}
+
+	return nil
+}
+
+// applyDeletions removes the objects deleted after their last change. Groups
+// still holding objects changed since are kept.
+func (m *merger) applyDeletions() {
+	root := &m.target.Content.Root.Groups[0]
+
+	for uuid := range m.deleted {
+		group, index, _ := findEntry(root, "", uuid)
+		if group != nil && m.isDeleted(uuid, group.Entries[index].Times) {
+			group.Entries = slices.Delete(group.Entries, index, index+1)
+		}
+	}
+
+	// Deleted groups are emptied of their deleted subgroups first
+	for removed := true; removed; {
+		removed = false
+
+		for uuid := range m.deleted {
+			group, parent := findGroup(root, nil, uuid)
+			if group != nil && parent != nil && len(group.Entries) == 0 && len(group.Groups) == 0 && m.isDeleted(uuid, group.Times) {
+				index := slices.IndexFunc(parent.Groups, func(child gokeepasslib.Group) bool { return child.UUID == uuid })
+				parent.Groups = slices.Delete(parent.Groups, index, index+1)
+				removed = true
+			}
+		}
+	}
+}
+
+// cloneEntry copies a source entry and its history, with its attachments
+// copied to the target database.
+func (m *merger) cloneEntry(source gokeepasslib.Entry) (gokeepasslib.Entry, error) {
+	entry := source.Clone()
+	entry.UUID = source.UUID
+	entry.Times = cloneTimes(source.Times)
+
+	for i := range entry.Binaries {
+		err := m.copyBinary(&entry.Binaries[i])
+		if err != nil {
+			return gokeepasslib.Entry{}, err
+		}
+	}
+
+	for i := range entry.Histories {
+		for j := range entry.Histories[i].Entries {
+			// Clone gives the versions new UUIDs too
+			previous := &entry.Histories[i].Entries[j]
+			previous.UUID = source.Histories[i].Entries[j].UUID
+			previous.Times = cloneTimes(previous.Times)
+
+			for k := range previous.Binaries {
+				err := m.copyBinary(&previous.Binaries[k])
+				if err != nil {
+					return gokeepasslib.Entry{}, err
+				}
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+func (m *merger) copyBinary(reference *gokeepasslib.BinaryReference) error {
+	if id, ok := m.binaries[reference.Value.ID]; ok {
+		reference.Value.ID = id
+
+		return nil
+	}
+
+	binary := m.source.FindBinary(reference.Value.ID)
+	if binary == nil {
+		return fmt.Errorf("missing attachment %q", reference.Name)
+	}
+
+	content, err := binary.GetContentBytes()
+	if err != nil {
+		return err
+	}
+
+	id := m.target.AddBinary(content).ID
+	m.binaries[reference.Value.ID] = id
+	reference.Value.ID = id
+
+	return nil
+}
+
+// versionKey identifies a version of an entry in its history.
+type versionKey struct {
+	modified int64
+	content  [sha256.Size]byte
+}
+
+// newVersionKey identifies the version by its modification time and content,
+// so that versions saved in the same second are kept apart.
+func newVersionKey(version gokeepasslib.Entry) (versionKey, error) {
+	modified := timeOf(version.Times.LastModificationTime).UnixNano()
+	version.Times = gokeepasslib.TimeData{} //nolint:exhaustruct // Times are keyed separately
+	version.Histories = nil
+
+	content, err := xml.Marshal(version)
+	if err != nil {
+		return versionKey{}, kcore.Wrap(err, "failed to encode entry version")
+	}
+
+	return versionKey{modified: modified, content: sha256.Sum256(content)}, nil
+}
+
+// mergeVersions returns the most recently modified version, ours on a tie,
+// with both histories and the other version as history.
+func mergeVersions(ours gokeepasslib.Entry, theirs gokeepasslib.Entry, maxItems int64) (gokeepasslib.Entry, error) {
+	current, previous := ours, theirs
+	if timeOf(theirs.Times.LastModificationTime).After(timeOf(ours.Times.LastModificationTime)) {
+		current, previous = theirs, ours
+	}
+
+	previous.Histories = nil
+	versions := map[versionKey]gokeepasslib.Entry{}
+
+	for _, entry := range []gokeepasslib.Entry{ours, theirs} {
+		for _, history := range entry.Histories {
+			for _, version := range history.Entries {
+				key, err := newVersionKey(version)
+				if err != nil {
+					return gokeepasslib.Entry{}, err
+				}
+
+				versions[key] = version
+			}
+		}
+	}
+
+	key, err := newVersionKey(previous)
+	if err != nil {
+		return gokeepasslib.Entry{}, err
+	}
+
+	versions[key] = previous
+
+	key, err = newVersionKey(current)
+	if err != nil {
+		return gokeepasslib.Entry{}, err
+	}
+
+	delete(versions, key)
+
+	keys := slices.SortedFunc(maps.Keys(versions), func(a, b versionKey) int {
+		return cmp.Or(cmp.Compare(a.modified, b.modified), bytes.Compare(a.content[:], b.content[:]))
+	})
+
+	history := make([]gokeepasslib.Entry, 0, len(keys))
+	for _, key := range keys {
+		history = append(history, versions[key])
+	}
+
+	if maxItems >= 0 && int64(len(history)) > maxItems {
+		history = history[int64(len(history))-maxItems:]
+	}
+
+	merged := current
+	merged.Histories = nil
+
+	if len(history) > 0 {
+		merged.Histories = []gokeepasslib.History{{Entries: history}}
+	}
+
+	// The location is merged separately
+	merged.Times.LocationChanged = ours.Times.LocationChanged
+
+	return merged, nil
+}
+
+// findGroup returns the group with the given UUID and its parent, nil for the
+// root.
+func findGroup(group *gokeepasslib.Group, parent *gokeepasslib.Group, uuid gokeepasslib.UUID) (*gokeepasslib.Group, *gokeepasslib.Group) {
+	if group.UUID == uuid {
+		return group, parent
+	}
+
+	for i := range group.Groups {
+		found, foundParent := findGroup(&group.Groups[i], group, uuid)
+		if found != nil {
+			return found, foundParent
+		}
+	}
+
+	return nil, nil
+}
+
+// groupOrRoot returns the group with the given UUID, or the root when it is
+// missing.
+func groupOrRoot(root *gokeepasslib.Group, uuid gokeepasslib.UUID) *gokeepasslib.Group {
+	group, _ := findGroup(root, nil, uuid)
+	if group == nil {
+		return root
+	}
+
+	return group
+}
+
+// moveGroup moves the group under the parent, unless that would move it
+// into itself.
+func moveGroup(root *gokeepasslib.Group, uuid gokeepasslib.UUID, parent gokeepasslib.UUID, locationChanged *w.TimeWrapper) {
+	group, _ := findGroup(root, nil, uuid)
+	if found, _ := findGroup(group, nil, parent); found != nil {
+		return
+	}
+
+	moved := *group
+	moved.Times.LocationChanged = locationChanged
+
+	_, oldParent := findGroup(root, nil, uuid)
+	index := slices.IndexFunc(oldParent.Groups, func(child gokeepasslib.Group) bool { return child.UUID == uuid })
+	oldParent.Groups = slices.Delete(oldParent.Groups, index, index+1)
+
+	newParent := groupOrRoot(root, parent)
+	newParent.Groups = append(newParent.Groups, moved)
+}
+
+func cloneTimes(times gokeepasslib.TimeData) gokeepasslib.TimeData {
+	clone := times
+
+	for _, wrapper := range []**w.TimeWrapper{&clone.CreationTime, &clone.LastModificationTime, &clone.LastAccessTime, &clone.ExpiryTime, &clone.LocationChanged} {
+		if *wrapper != nil {
+			copied := **wrapper
+			*wrapper = &copied
+		}
+	}
+
+	return clone
+}
+
+func timeOf(wrapper *w.TimeWrapper) time.Time {
+	if wrapper == nil {
+		return time.Time{}
+	}
+
+	return wrapper.Time
+}
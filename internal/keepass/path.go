@@ -0,0 +1,239 @@
+package keepass
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrNotKDBX is returned for files that are not KeePass 2 databases.
+var ErrNotKDBX = errors.New("not a KeePass 2 database")
+
+// kdbxSignature starts every KeePass 2 database, KDBX 3 and 4 alike.
+var kdbxSignature = []byte{0x03, 0xD9, 0xA2, 0x9A, 0x67, 0xFB, 0x4B, 0xB5}
+
+// RootFS returns the writable filesystem of the whole machine, in which
+// databases are found by their absolute path.
+func RootFS() FS {
+	return DirFS("/")
+}
+
+// ExpandPath expands environment variables and a leading ~ in a path typed by
+// the user.
+func ExpandPath(path string) (string, error) {
+	path = os.ExpandEnv(strings.TrimSpace(path))
+	if path == "" {
+		return "", errors.New("path is empty")
+	}
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+
+		path = filepath.Join(home, path[1:])
+	}
+
+	return path, nil
+}
+
+// CanonicalPath expands a path typed by the user, see ExpandPath, and makes it
+// absolute with symbolic links resolved, so that saving replaces the file
+// rather than the link. The file must exist.
+func CanonicalPath(path string) (string, error) {
+	path, err := ExpandPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(path)
+}
+
+// IsDatabaseName tells whether the file name has the KeePass 2 extension.
+func IsDatabaseName(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".kdbx")
+}
+
+// CompletePath completes the last element of a path typed by the user with
+// the directories, ending with a slash, and databases it starts. It returns
+// the path completed as far as the candidates agree, and the candidates.
+// Hidden files are only candidates when the element starts with a dot.
+func CompletePath(path string) (string, []string) {
+	if path == "~" {
+		return "~/", nil
+	}
+
+	dir, prefix := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		dir, prefix = path[:i+1], path[i+1:]
+	}
+
+	listed := "."
+	if dir != "" {
+		expanded, err := ExpandPath(dir)
+		if err != nil {
+			return path, nil
+		}
+
+		listed = expanded
+	}
+
+	entries, err := os.ReadDir(listed)
+	if err != nil {
+		return path, nil
+	}
+
+	var candidates []string
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+
+		switch {
+		case IsDir(filepath.Join(listed, name), entry):
+			candidates = append(candidates, name+"/")
+		case IsDatabaseName(name):
+			candidates = append(candidates, name)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return path, nil
+	}
+
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	// Names may differ within a character
+	for !utf8.ValidString(common) {
+		common = common[:len(common)-1]
+	}
+
+	return dir + common, candidates
+}
+
+// IsDir tells whether the entry at path is a directory, following symbolic
+// links.
+func IsDir(path string, entry fs.DirEntry) bool {
+	if entry.Type()&fs.ModeSymlink == 0 {
+		return entry.IsDir()
+	}
+
+	info, err := os.Stat(path)
+
+	return err == nil && info.IsDir()
+}
+
+// FindDatabases walks the roots, typed as by the user, for KeePass 2
+// databases and returns their canonical paths, sorted. Hidden directories,
+// unreadable directories and missing roots are skipped.
+func FindDatabases(roots []string) ([]string, error) {
+	found := map[string]bool{}
+
+	var errs []error
+
+	for _, root := range roots {
+		dir, err := CanonicalPath(root)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", root, err))
+
+			continue
+		}
+
+		err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if path == dir {
+					return err
+				}
+
+				return nil
+			}
+
+			if entry.IsDir() {
+				if path != dir && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if !IsDatabaseName(entry.Name()) || CheckDatabase(path) != nil {
+				return nil
+			}
+
+			canonical, err := filepath.EvalSymlinks(path)
+			if err == nil {
+				found[canonical] = true
+			}
+
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", root, err))
+		}
+	}
+
+	return slices.Sorted(maps.Keys(found)), errors.Join(errs...)
+}
+
+// CheckDatabase checks that the file at path is a KeePass 2 database, from its
+// signature.
+func CheckDatabase(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	signature := make([]byte, len(kdbxSignature))
+
+	_, err = io.ReadFull(file, signature)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	if !bytes.Equal(signature, kdbxSignature) {
+		return fmt.Errorf("%s: %w", path, ErrNotKDBX)
+	}
+
+	return nil
+}
+
+// fsName returns the name of the path in a filesystem, absolute paths being
+// taken from its root.
+func fsName(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+}
@@ -0,0 +1,249 @@
+package keepass
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// ErrVaultClosed is returned by the operations of a vault once locked.
+var ErrVaultClosed = errors.New("database is locked")
+
+// Vault is an unlocked database shared by goroutines, like the screens of the
+// interface and the commands they start, or the requests to the agent. Its
+// operations run one at a time: a reload never swaps the database out while it
+// is being written, and a write always goes to the last reload.
+type Vault struct {
+	mu      sync.Mutex
+	keepass *KeePass
+}
+
+// NewVault shares the unlocked database.
+func NewVault(opened *KeePass) *Vault {
+	return &Vault{mu: sync.Mutex{}, keepass: opened}
+}
+
+// Entries returns the entries of the database.
+func (v *Vault) Entries() ([]types.Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return nil, ErrVaultClosed
+	}
+
+	return v.keepass.Entries()
+}
+
+// Groups returns the group tree of the database.
+func (v *Vault) Groups() (types.Group, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return types.Group{}, ErrVaultClosed //nolint:exhaustruct // No group
+	}
+
+	return v.keepass.Groups()
+}
+
+// Info describes the database and how it is encrypted.
+func (v *Vault) Info() (Info, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return Info{}, ErrVaultClosed //nolint:exhaustruct // No database
+	}
+
+	return v.keepass.Info(), nil
+}
+
+// Attachment returns the content of an attachment of the entry.
+func (v *Vault) Attachment(uuid gokeepasslib.UUID, name string) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return nil, ErrVaultClosed
+	}
+
+	return v.keepass.Attachment(uuid, name)
+}
+
+// SaveEntry creates the entry when isNew is set, or updates it, then saves the
+// database. It returns the saved entry and all entries.
+func (v *Vault) SaveEntry(entry types.Entry, isNew bool) (types.Entry, []types.Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return entry, nil, ErrVaultClosed
+	}
+
+	var (
+		saved types.Entry
+		err   error
+	)
+
+	if isNew {
+		saved, err = v.keepass.CreateEntry(entry)
+	} else {
+		saved, err = v.keepass.UpdateEntry(entry)
+	}
+
+	if err != nil {
+		return entry, nil, kcore.Wrap(err, "failed to save entry")
+	}
+
+	entries, err := v.save()
+
+	return saved, entries, err
+}
+
+// RestoreVersion makes the version of the entry modified at the given time its
+// current one, then saves the database. It returns the restored entry and all
+// entries.
+func (v *Vault) RestoreVersion(uuid gokeepasslib.UUID, modified time.Time) (types.Entry, []types.Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return types.Entry{}, nil, ErrVaultClosed //nolint:exhaustruct // No entry
+	}
+
+	restored, err := v.keepass.RestoreVersion(uuid, modified)
+	if err != nil {
+		return restored, nil, kcore.Wrap(err, "failed to restore version")
+	}
+
+	entries, err := v.save()
+
+	return restored, entries, err
+}
+
+// DeleteEntry deletes the entry, then saves the database. It returns the
+// remaining entries.
+func (v *Vault) DeleteEntry(uuid gokeepasslib.UUID) ([]types.Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return nil, ErrVaultClosed
+	}
+
+	err := v.keepass.DeleteEntry(uuid)
+	if err != nil {
+		return nil, kcore.Wrap(err, "failed to delete entry")
+	}
+
+	return v.save()
+}
+
+// save merges in the changes made to the file by other programs, so that
+// saving never overwrites them.
+func (v *Vault) save() ([]types.Entry, error) {
+	err := v.keepass.MergeAndSave()
+	if err != nil {
+		return nil, kcore.Wrap(err, "failed to save database")
+	}
+
+	entries, err := v.keepass.Entries()
+	if err != nil {
+		return nil, kcore.Wrap(err, "failed to get entries")
+	}
+
+	return entries, nil
+}
+
+// ChangeMasterKey re-encrypts the database with new credentials and KDF
+// settings.
+func (v *Vault) ChangeMasterKey(credentials Credentials, kdf KDF) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return ErrVaultClosed
+	}
+
+	return v.keepass.ChangeMasterKey(credentials, kdf)
+}
+
+// Changed reports whether another program wrote the file. A vault busy with a
+// write or a reload is reported unchanged, to be checked again later without
+// waiting for it.
+func (v *Vault) Changed() (bool, error) {
+	if !v.mu.TryLock() {
+		return false, nil
+	}
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return false, nil
+	}
+
+	return v.keepass.Changed()
+}
+
+// Reload decrypts the file again when another program wrote it, reporting
+// whether it did, and returns the entries. A file that cannot be decrypted is
+// not reported as changed again until it is rewritten.
+func (v *Vault) Reload() ([]types.Entry, bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return nil, false, ErrVaultClosed
+	}
+
+	// A save may have merged the change in meanwhile
+	changed, err := v.keepass.Changed()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if changed {
+		reloaded, err := v.keepass.Reload()
+		if err != nil {
+			markErr := v.keepass.MarkUnchanged()
+			if markErr != nil {
+				log.Printf("failed to check database: %v", markErr)
+			}
+
+			return nil, false, err
+		}
+
+		v.close()
+		v.keepass = reloaded
+	}
+
+	entries, err := v.keepass.Entries()
+
+	return entries, changed, err
+}
+
+// Close locks the protected values of the database, once its current
+// operation is done. The vault cannot be used afterwards.
+func (v *Vault) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keepass == nil {
+		return
+	}
+
+	v.close()
+	v.keepass = nil
+}
+
+func (v *Vault) close() {
+	err := v.keepass.Close()
+	if err != nil {
+		log.Printf("failed to close database: %v", err)
+	}
+}
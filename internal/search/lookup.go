@@ -0,0 +1,92 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/types"
+)
+
+var (
+	// ErrNotFound is returned when no entry or field has the looked up name.
+	ErrNotFound = errors.New("not found")
+	// ErrAmbiguous is returned when several entries have the looked up name.
+	ErrAmbiguous = errors.New("ambiguous match")
+)
+
+// Lookup finds an entry by its full path, or by its title alone.
+func Lookup(entries []types.Entry, name string) (types.Entry, error) {
+	name = strings.Trim(name, "/")
+
+	var matches []types.Entry
+
+	for _, entry := range entries {
+		if Path(entry) == name {
+			matches = append(matches, entry)
+		}
+	}
+
+	if len(matches) == 0 {
+		for _, entry := range entries {
+			if entry.Title == name {
+				matches = append(matches, entry)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return types.Entry{}, fmt.Errorf("%w: entry %q", ErrNotFound, name) //nolint:exhaustruct // No entry
+	case 1:
+		return matches[0], nil
+	default:
+		paths := make([]string, len(matches))
+		for i, match := range matches {
+			paths[i] = Path(match)
+		}
+
+		//nolint:exhaustruct // No entry
+		return types.Entry{}, fmt.Errorf("%w: %q matches %s", ErrAmbiguous, name, strings.Join(paths, ", "))
+	}
+}
+
+// FieldValue returns a standard field, the current TOTP code or a custom field.
+func FieldValue(entry types.Entry, field string) (string, error) {
+	switch strings.ToLower(field) {
+	case "title":
+		return entry.Title, nil
+	case "username", "user":
+		return entry.Username, nil
+	case "password":
+		return entry.Password, nil
+	case "url":
+		return entry.URL, nil
+	case "notes":
+		return entry.Notes, nil
+	case "totp":
+		if entry.TOTP == nil {
+			return "", fmt.Errorf("%w: entry has no TOTP", ErrNotFound)
+		}
+
+		return entry.TOTP.Code(time.Now()), nil
+	}
+
+	for _, custom := range entry.Fields {
+		if custom.Key == field {
+			return custom.Value, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: field %q", ErrNotFound, field)
+}
+
+// Path returns the group path and title of the entry, like Work/Infra/AWS.
+func Path(entry types.Entry) string {
+	if entry.Group == "" {
+		return entry.Title
+	}
+
+	return entry.Group + "/" + entry.Title
+}
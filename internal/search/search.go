@@ -0,0 +1,209 @@
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/sahilm/fuzzy"
+)
+
+// Field is an entry field that can be searched.
+type Field string
+
+const (
+	TitleField    Field = "title"
+	UsernameField Field = "username"
+	URLField      Field = "url"
+	TagField      Field = "tag"
+	GroupField    Field = "group"
+	NotesField    Field = "notes"
+)
+
+// fields lists the searched fields, with their weight.
+var fields = []struct {
+	field  Field
+	weight int
+}{
+	{TitleField, 4},
+	{UsernameField, 3},
+	{URLField, 2},
+	{TagField, 2},
+	{GroupField, 1},
+	{NotesField, 1},
+}
+
+// prefixes maps query prefixes to the field they restrict a term to.
+var prefixes = map[string]Field{
+	"title:": TitleField,
+	"user:":  UsernameField,
+	"url:":   URLField,
+	"tag:":   TagField,
+	"group:": GroupField,
+	"notes:": NotesField,
+}
+
+const (
+	// Substring matches rank above scattered fuzzy ones of the same weight
+	substringQuality = 2
+	fuzzyQuality     = 1
+
+	// maxFuzzyScore bounds the fuzzy score so it only breaks ties
+	maxFuzzyScore = 999
+)
+
+// Match is an entry matching a query.
+type Match struct {
+	// Index is the index of the entry in the searched slice
+	Index int
+	// Field is the field that matched best, and Value its content
+	Field Field
+	Value string
+	Score int
+}
+
+// term is a part of a query, restricted to one field or searched in all of them.
+type term struct {
+	pattern string
+	field   Field
+}
+
+// Find returns the entries matching the query, best first.
+//
+// Words prefixed with a field name, like "url:github.com" or "user:octocat",
+// must match that field. The other words must each match one of the fields,
+// the title being weighted highest.
+func Find(query string, entries []types.Entry) []Match {
+	terms := parseQuery(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var matches []Match
+
+	for i, entry := range entries {
+		match, ok := matchEntry(terms, entry)
+		if ok {
+			match.Index = i
+			matches = append(matches, match)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	return matches
+}
+
+// parseQuery splits the query into one term per word, so that words in
+// different fields, like "github octocat", match together.
+func parseQuery(query string) []term {
+	var terms []term
+
+	for word := range strings.FieldsSeq(query) {
+		term := term{pattern: word, field: ""}
+
+		for prefix, field := range prefixes {
+			if len(word) > len(prefix) && strings.EqualFold(word[:len(prefix)], prefix) {
+				term.pattern = word[len(prefix):]
+				term.field = field
+
+				break
+			}
+		}
+
+		terms = append(terms, term)
+	}
+
+	return terms
+}
+
+// matchEntry requires every term to match and adds up their scores. The
+// reported field is the best one of the last matching term.
+func matchEntry(terms []term, entry types.Entry) (Match, bool) {
+	result := Match{Index: 0, Field: "", Value: "", Score: 0}
+
+	for _, term := range terms {
+		best := Match{Index: 0, Field: "", Value: "", Score: 0}
+
+		for _, candidate := range fields {
+			if term.field != "" && term.field != candidate.field {
+				continue
+			}
+
+			for _, value := range fieldValues(entry, candidate.field) {
+				quality, fuzzyScore := matchValue(term.pattern, value, candidate.field)
+				if quality == 0 {
+					continue
+				}
+
+				score := candidate.weight*quality*(maxFuzzyScore+1) + fuzzyScore
+				if score > best.Score {
+					best = Match{Index: 0, Field: candidate.field, Value: value, Score: score}
+				}
+			}
+		}
+
+		if best.Field == "" {
+			return result, false
+		}
+
+		result.Field = best.Field
+		result.Value = best.Value
+		result.Score += best.Score
+	}
+
+	return result, true
+}
+
+// matchValue returns the quality of the match of the pattern against one
+// value, zero when it does not match, and its fuzzy score. Notes are long free
+// text, which fuzzy matching would match almost always, so they require a
+// substring.
+func matchValue(pattern string, value string, field Field) (int, int) {
+	if value == "" {
+		return 0, 0
+	}
+
+	quality := 0
+	if strings.Contains(strings.ToLower(value), strings.ToLower(pattern)) {
+		quality = substringQuality
+	} else if field != NotesField {
+		quality = fuzzyQuality
+	}
+
+	if quality == 0 {
+		return 0, 0
+	}
+
+	matches := fuzzy.Find(pattern, []string{value})
+	if len(matches) == 0 {
+		return 0, 0
+	}
+
+	// Fuzzy scores are mostly small, keep them positive so they only order
+	// matches of the same quality
+	fuzzyScore := min(max(matches[0].Score+maxFuzzyScore/2, 0), maxFuzzyScore)
+
+	return quality, fuzzyScore
+}
+
+func fieldValues(entry types.Entry, field Field) []string {
+	switch field {
+	case TitleField:
+		return []string{entry.Title}
+	case UsernameField:
+		return []string{entry.Username}
+	case URLField:
+		return []string{entry.URL}
+	case TagField:
+		return entry.Tags
+	case GroupField:
+		return []string{entry.Group}
+	case NotesField:
+		return []string{entry.Notes}
+	}
+
+	return nil
+}
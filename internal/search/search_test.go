@@ -0,0 +1,115 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/martinlehoux/kagapass/internal/types"
+)
+
+var entries = []types.Entry{
+	{Title: "GitHub", Username: "octocat", URL: "https://github.com", Group: "Work", Tags: []string{"dev"}},
+	{Title: "Gmail", Username: "john@gmail.com", URL: "https://mail.google.com", Group: "Personal"},
+	{Title: "Bank", Username: "john", Notes: "Branch: Main Street\nAdvisor: Hubert", Group: "Personal/Finance", Tags: []string{"money"}},
+	{Title: "Company VPN", Username: "jdoe", URL: "vpn.example.com", Group: "Work", Tags: []string{"dev", "infra"}},
+}
+
+func titles(matches []Match) []string {
+	result := make([]string, len(matches))
+	for i, match := range matches {
+		result[i] = entries[match.Index].Title
+	}
+
+	return result
+}
+
+func TestFindMatchesOtherFields(t *testing.T) {
+	matches := Find("octocat", entries)
+	if len(matches) != 1 || matches[0].Field != UsernameField {
+		t.Fatalf("Expected a username match, got %+v", matches)
+	}
+
+	matches = Find("github.com", entries)
+	if len(matches) != 1 || matches[0].Field != URLField || matches[0].Value != "https://github.com" {
+		t.Fatalf("Expected a URL match, got %+v", matches)
+	}
+
+	matches = Find("advisor", entries)
+	if len(matches) != 1 || matches[0].Field != NotesField {
+		t.Fatalf("Expected a notes match, got %+v", matches)
+	}
+}
+
+func TestFindWeightsTitleHighest(t *testing.T) {
+	// "gm" also matches Gmail's username and other fields, the title wins
+	matches := Find("gm", entries)
+	if len(matches) == 0 || entries[matches[0].Index].Title != "Gmail" || matches[0].Field != TitleField {
+		t.Errorf("Expected Gmail title match first, got %v", titles(matches))
+	}
+
+	// A title substring ranks above a username substring
+	entries := []types.Entry{
+		{Title: "Mail server", Username: "bank"},
+		{Title: "Bank"},
+	}
+
+	matches = Find("bank", entries)
+	if len(matches) != 2 || matches[0].Index != 1 {
+		t.Errorf("Expected title match first, got %+v", matches)
+	}
+}
+
+func TestFindNotesRequireSubstring(t *testing.T) {
+	// Scattered letters of the notes must not match
+	if matches := Find("bmst", entries); len(matches) != 0 {
+		t.Errorf("Expected no match, got %v", titles(matches))
+	}
+}
+
+func TestFindPrefixes(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"user:john", []string{"Bank", "Gmail"}},
+		{"url:google", []string{"Gmail"}},
+		{"group:work", []string{"GitHub", "Company VPN"}},
+		{"tag:infra", []string{"Company VPN"}},
+		{"tag:dev vpn", []string{"Company VPN"}},
+		{"group:personal user:john bank", []string{"Bank"}},
+		{"TAG:money", []string{"Bank"}},
+		{"tag:unknown", []string{}},
+	}
+
+	for _, test := range tests {
+		got := titles(Find(test.query, entries))
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.query, test.expected, got)
+
+			continue
+		}
+
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, got %v", test.query, test.expected, got)
+
+				break
+			}
+		}
+	}
+}
+
+func TestFindWordsInDifferentFields(t *testing.T) {
+	if got := titles(Find("github octocat", entries)); len(got) != 1 || got[0] != "GitHub" {
+		t.Errorf("Expected the title and username words to both match GitHub, got %v", got)
+	}
+
+	if got := titles(Find("john work", entries)); len(got) != 0 {
+		t.Errorf("Expected every word to have to match, got %v", got)
+	}
+}
+
+func TestFindEmptyQuery(t *testing.T) {
+	if matches := Find("  ", entries); len(matches) != 0 {
+		t.Errorf("Expected no match for empty query, got %v", titles(matches))
+	}
+}
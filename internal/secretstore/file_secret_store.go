@@ -0,0 +1,210 @@
+package secretstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+const (
+	secretsFileName = "secrets.age"
+	// defaultWorkFactor is the scrypt cost used by the age tool
+	defaultWorkFactor = 18
+)
+
+// File keeps secrets in a file encrypted with age, using a passphrase.
+type File struct {
+	mu         sync.Mutex
+	path       string
+	passphrase string
+	workFactor int
+
+	// The decrypted secrets, kept while the file is unchanged so scrypt only
+	// runs when it is written by another process
+	secrets map[string][]byte
+	modTime time.Time
+}
+
+var _ SecretStore = (*File)(nil)
+
+// NewFile creates a store in dir, encrypted with the passphrase.
+func NewFile(dir string, passphrase string) (*File, error) {
+	if passphrase == "" {
+		return nil, errors.New("no passphrase, set KAGAPASS_SECRETS_PASSPHRASE")
+	}
+
+	if dir == "" {
+		return nil, errors.New("no directory for the secrets file")
+	}
+
+	return &File{
+		mu:         sync.Mutex{},
+		path:       filepath.Join(dir, secretsFileName),
+		passphrase: passphrase,
+		workFactor: defaultWorkFactor,
+		secrets:    nil,
+		modTime:    time.Time{},
+	}, nil
+}
+
+func (f *File) Store(key string, secret []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	// The kept secrets only change once written
+	secrets = maps.Clone(secrets)
+	secrets[key] = bytes.Clone(secret)
+
+	return f.write(secrets)
+}
+
+func (f *File) Get(key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := secrets[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return bytes.Clone(secret), nil
+}
+
+func (f *File) Remove(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := secrets[key]; !ok {
+		return nil
+	}
+
+	secrets = maps.Clone(secrets)
+	delete(secrets, key)
+
+	return f.write(secrets)
+}
+
+// read returns the secrets of the file, none when it does not exist yet.
+func (f *File) read() (map[string][]byte, error) {
+	info, err := os.Stat(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]byte{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if f.secrets != nil && info.ModTime().Equal(f.modTime) {
+		return f.secrets, nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	identity, err := age.NewScryptIdentity(f.passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := age.Decrypt(file, identity)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", f.path, err)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := map[string][]byte{}
+
+	err = json.Unmarshal(data, &secrets)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", f.path, err)
+	}
+
+	f.secrets = secrets
+	f.modTime = info.ModTime()
+
+	return secrets, nil
+}
+
+// write encrypts the secrets to a temporary file renamed over the store, so a
+// failed write never loses the previous secrets.
+func (f *File) write(secrets map[string][]byte) error {
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	recipient, err := age.NewScryptRecipient(f.passphrase)
+	if err != nil {
+		return err
+	}
+
+	recipient.SetWorkFactor(f.workFactor)
+
+	file, err := os.CreateTemp(filepath.Dir(f.path), secretsFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer, err := age.Encrypt(file, recipient)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), f.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.secrets = secrets
+	f.modTime = info.ModTime()
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package secretstore
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/99designs/keyring"
@@ -47,7 +48,9 @@ func (k keyringSecretStore) Store(key string, secret []byte) error {
 
 func (k keyringSecretStore) Get(key string) ([]byte, error) {
 	item, err := k.ring.Get(getKey(key))
-	if err != nil {
+	if errors.Is(err, keyring.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -55,5 +58,10 @@ func (k keyringSecretStore) Get(key string) ([]byte, error) {
 }
 
 func (k keyringSecretStore) Remove(key string) error {
-	return k.ring.Remove(getKey(key))
+	err := k.ring.Remove(getKey(key))
+	if errors.Is(err, keyring.ErrKeyNotFound) {
+		return nil
+	}
+
+	return err
 }
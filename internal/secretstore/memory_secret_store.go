@@ -0,0 +1,68 @@
+package secretstore
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Memory keeps secrets in the process, until it exits.
+type Memory struct {
+	mu      sync.Mutex
+	secrets map[string][]byte
+}
+
+var _ SecretStore = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		mu:      sync.Mutex{},
+		secrets: map[string][]byte{},
+	}
+}
+
+func (m *Memory) Store(key string, secret []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.secrets[key] = bytes.Clone(secret)
+
+	return nil
+}
+
+func (m *Memory) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secret, ok := m.secrets[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return bytes.Clone(secret), nil
+}
+
+func (m *Memory) Remove(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.secrets, key)
+
+	return nil
+}
+
+// None never keeps secrets, so a password is asked on every unlock.
+type None struct{}
+
+var _ SecretStore = None{}
+
+func (None) Store(string, []byte) error {
+	return nil
+}
+
+func (None) Get(string) ([]byte, error) {
+	return nil, ErrNotFound
+}
+
+func (None) Remove(string) error {
+	return nil
+}
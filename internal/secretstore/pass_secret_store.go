@@ -0,0 +1,133 @@
+package secretstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// passFolder is the folder of the password store holding the secrets.
+const passFolder = "kagapass"
+
+// Pass keeps secrets in a password store, as `pass` does: one file per
+// secret, encrypted with gpg for the keys listed in the closest .gpg-id file.
+// Secrets can be read back with `pass show kagapass/<database path>`.
+type Pass struct {
+	dir string
+	gpg string
+}
+
+var _ SecretStore = Pass{}
+
+// NewPass uses the password store in dir, $PASSWORD_STORE_DIR or
+// ~/.password-store, which must have been initialised with `pass init`.
+func NewPass(dir string) (Pass, error) {
+	if dir == "" {
+		dir = os.Getenv("PASSWORD_STORE_DIR")
+	}
+
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Pass{}, err
+		}
+
+		dir = filepath.Join(home, ".password-store")
+	}
+
+	gpg, err := exec.LookPath("gpg")
+	if err != nil {
+		return Pass{}, err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".gpg-id")); err != nil {
+		return Pass{}, fmt.Errorf("password store %s is not initialised, run pass init: %w", dir, err)
+	}
+
+	return Pass{dir: dir, gpg: gpg}, nil
+}
+
+func (p Pass) Store(key string, secret []byte) error {
+	path := p.path(key)
+
+	recipients, err := p.recipients(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--batch", "--yes", "--quiet", "--encrypt", "--output", path}
+	for _, recipient := range recipients {
+		args = append(args, "--recipient", recipient)
+	}
+
+	_, err = p.run(secret, args...)
+
+	return err
+}
+
+func (p Pass) Get(key string) ([]byte, error) {
+	path := p.path(key)
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return p.run(nil, "--batch", "--quiet", "--decrypt", path)
+}
+
+func (p Pass) Remove(key string) error {
+	err := os.Remove(p.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path returns the file of the secret. Keys are database paths, kept as
+// folders so they read naturally in `pass ls`.
+func (p Pass) path(key string) string {
+	return filepath.Join(p.dir, passFolder, filepath.Clean("/"+key)+".gpg")
+}
+
+// recipients reads the .gpg-id file closest to dir, as pass does for
+// subfolders encrypted for other keys.
+func (p Pass) recipients(dir string) ([]string, error) {
+	for {
+		data, err := os.ReadFile(filepath.Join(dir, ".gpg-id"))
+		if err == nil {
+			return strings.Fields(string(data)), nil
+		}
+
+		if !errors.Is(err, os.ErrNotExist) || dir == p.dir || !strings.HasPrefix(dir, p.dir) {
+			return nil, err
+		}
+
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (p Pass) run(stdin []byte, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(p.gpg, args...) //nolint:gosec // Arguments are built by the store
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("gpg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
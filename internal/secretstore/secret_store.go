@@ -1,7 +1,145 @@
 package secretstore
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
 type SecretStore interface {
 	Store(key string, secret []byte) error
 	Get(key string) ([]byte, error)
 	Remove(key string) error
 }
+
+// ErrNotFound is returned by Get when no secret is stored for the key.
+var ErrNotFound = errors.New("secret not found")
+
+// Backend names, selected with the secret_store setting.
+const (
+	KeyringBackend = "keyring"
+	FileBackend    = "file"
+	PassBackend    = "pass"
+	MemoryBackend  = "memory"
+	NoneBackend    = "none"
+)
+
+// Options configures the backends.
+type Options struct {
+	// Dir is where the file backend keeps its encrypted file
+	Dir string
+	// Passphrase encrypts the file backend
+	Passphrase string
+	// PassDir is the root of the password store used by the pass backend,
+	// ~/.password-store when empty
+	PassDir string
+}
+
+// OptionsFromEnv returns the options with the file store in dir and its
+// passphrase from $KAGAPASS_SECRETS_PASSPHRASE.
+func OptionsFromEnv(dir string) Options {
+	return Options{
+		Dir:        dir,
+		Passphrase: os.Getenv("KAGAPASS_SECRETS_PASSPHRASE"),
+		PassDir:    "",
+	}
+}
+
+var backends = map[string]func(options Options) (SecretStore, error){
+	KeyringBackend: func(Options) (SecretStore, error) {
+		keyring, err := NewKeyring()
+		if err != nil {
+			return nil, err
+		}
+
+		return &keyring, nil
+	},
+	FileBackend: func(options Options) (SecretStore, error) {
+		return NewFile(options.Dir, options.Passphrase)
+	},
+	PassBackend: func(options Options) (SecretStore, error) {
+		return NewPass(options.PassDir)
+	},
+	MemoryBackend: func(Options) (SecretStore, error) {
+		return NewMemory(), nil
+	},
+	NoneBackend: func(Options) (SecretStore, error) {
+		return None{}, nil
+	},
+}
+
+// Open opens the named backend, the keyring when the name is empty.
+func Open(name string, options Options) (SecretStore, error) {
+	if name == "" {
+		name = KeyringBackend
+	}
+
+	open, ok := backends[name]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for backend := range backends {
+			names = append(names, backend)
+		}
+
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("unknown secret store %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	store, err := open(options)
+	if err != nil {
+		return nil, fmt.Errorf("%s secret store: %w", name, err)
+	}
+
+	return store, nil
+}
+
+// OpenWithFallback opens the named backend or, when it is unavailable, an
+// in-memory store keeping passwords until exit. The error tells why the
+// backend is not used.
+func OpenWithFallback(name string, options Options) (SecretStore, error) {
+	store, err := Open(name, options)
+	if err != nil {
+		return NewMemory(), err
+	}
+
+	return store, nil
+}
+
+// MoveKeys moves the secrets stored under the old keys of the map to the new
+// key they map to. A secret already stored under the new key is kept, and the
+// old one removed.
+func MoveKeys(store SecretStore, keys map[string]string) error {
+	var errs []error
+
+	for from, to := range keys {
+		secret, err := store.Get(from)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read %s: %w", from, err))
+
+			continue
+		}
+
+		_, err = store.Get(to)
+		if errors.Is(err, ErrNotFound) {
+			err = store.Store(to, secret)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to move %s: %w", from, err))
+
+			continue
+		}
+
+		err = store.Remove(from)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", from, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,248 @@
+package secretstore
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testStore stores, reads back and removes a secret.
+func testStore(t *testing.T, store SecretStore) {
+	t.Helper()
+
+	const key = "/home/user/passwords/personal.kdbx"
+
+	if _, err := store.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound before storing, got %v", err)
+	}
+
+	if err := store.Store(key, []byte("s3cret")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	secret, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+
+	if string(secret) != "s3cret" {
+		t.Errorf("Expected 's3cret', got '%s'", secret)
+	}
+
+	if err := store.Remove(key); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+
+	if _, err := store.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after removing, got %v", err)
+	}
+
+	// Removing twice is not an error
+	if err := store.Remove(key); err != nil {
+		t.Errorf("Remove() of a missing secret failed: %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestNone(t *testing.T) {
+	store := None{}
+
+	if err := store.Store("key", []byte("s3cret")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	if _, err := store.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected nothing to be kept, got %v", err)
+	}
+}
+
+func TestMoveKeys(t *testing.T) {
+	store := NewMemory()
+
+	_ = store.Store("passwords/personal.kdbx", []byte("personal"))
+	_ = store.Store("passwords/work.kdbx", []byte("stale"))
+	_ = store.Store("/home/octocat/passwords/work.kdbx", []byte("work"))
+
+	err := MoveKeys(store, map[string]string{
+		"passwords/personal.kdbx": "/home/octocat/passwords/personal.kdbx",
+		"passwords/work.kdbx":     "/home/octocat/passwords/work.kdbx",
+		"passwords/other.kdbx":    "/home/octocat/passwords/other.kdbx",
+	})
+	if err != nil {
+		t.Fatalf("MoveKeys() failed: %v", err)
+	}
+
+	if secret, err := store.Get("/home/octocat/passwords/personal.kdbx"); err != nil || string(secret) != "personal" {
+		t.Errorf("Expected the secret under its new key, got '%s' (%v)", secret, err)
+	}
+
+	if secret, err := store.Get("/home/octocat/passwords/work.kdbx"); err != nil || string(secret) != "work" {
+		t.Errorf("Expected the secret already under the new key to be kept, got '%s' (%v)", secret, err)
+	}
+
+	for _, key := range []string{"passwords/personal.kdbx", "passwords/work.kdbx"} {
+		if _, err := store.Get(key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected %s to be removed, got %v", key, err)
+		}
+	}
+
+	if _, err := store.Get("/home/octocat/passwords/other.kdbx"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected nothing to be stored without a secret to move, got %v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFile(dir, "passphrase")
+	if err != nil {
+		t.Fatalf("NewFile() failed: %v", err)
+	}
+
+	// Keep tests fast, decryption accepts any work factor below the maximum
+	store.workFactor = 10
+
+	testStore(t, store)
+
+	if err := store.Store("key", []byte("s3cret")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, secretsFileName))
+	if err != nil {
+		t.Fatalf("Expected the secrets file to exist: %v", err)
+	}
+
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected secrets file mode 0600, got %o", info.Mode().Perm())
+	}
+
+	// Another store with the same passphrase reads it back
+	other, _ := NewFile(dir, "passphrase")
+	if secret, err := other.Get("key"); err != nil || string(secret) != "s3cret" {
+		t.Errorf("Expected to read the secret back, got '%s', %v", secret, err)
+	}
+
+	wrong, _ := NewFile(dir, "wrong")
+	if _, err := wrong.Get("key"); err == nil {
+		t.Error("Expected a wrong passphrase to fail")
+	}
+
+	if _, err := NewFile(dir, ""); err == nil {
+		t.Error("Expected an empty passphrase to be refused")
+	}
+
+	// A failed write keeps the secrets read last: the file is replaced by a
+	// directory it cannot be renamed over, unchanged as far as the store knows
+	path := filepath.Join(dir, secretsFileName)
+
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() failed: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(path, "blocker"), 0o700); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("Chtimes() failed: %v", err)
+	}
+
+	if err := store.Store("other", []byte("lost")); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+
+	if err := store.Remove("key"); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+
+	if _, err := store.Get("other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the unwritten secret not to be kept, got %v", err)
+	}
+
+	if secret, err := store.Get("key"); err != nil || string(secret) != "s3cret" {
+		t.Errorf("Expected the written secret to be kept, got '%s', %v", secret, err)
+	}
+}
+
+func TestPass(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	home := t.TempDir()
+	t.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+	})
+
+	const recipient = "kagapass-test@example.com"
+
+	err := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", recipient, "default", "default", "never").Run()
+	if err != nil {
+		t.Skipf("failed to generate a gpg key: %v", err)
+	}
+
+	dir := t.TempDir()
+
+	if _, err := NewPass(dir); err == nil {
+		t.Error("Expected an uninitialised password store to be refused")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".gpg-id"), []byte(recipient+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write .gpg-id: %v", err)
+	}
+
+	store, err := NewPass(dir)
+	if err != nil {
+		t.Fatalf("NewPass() failed: %v", err)
+	}
+
+	testStore(t, store)
+
+	// Secrets are laid out as pass does
+	if err := store.Store("/home/user/personal.kdbx", []byte("s3cret")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "kagapass", "home", "user", "personal.kdbx.gpg")); err != nil {
+		t.Errorf("Expected the secret in the kagapass folder: %v", err)
+	}
+}
+
+func TestOpenWithFallback(t *testing.T) {
+	store, err := OpenWithFallback("memory", Options{})
+	if err != nil {
+		t.Fatalf("OpenWithFallback() failed: %v", err)
+	}
+
+	if _, ok := store.(*Memory); !ok {
+		t.Errorf("Expected the memory store, got %T", store)
+	}
+
+	store, err = OpenWithFallback("unknown", Options{})
+	if err == nil {
+		t.Error("Expected an unknown store to be reported")
+	}
+
+	if store == nil {
+		t.Fatal("Expected a fallback store")
+	}
+
+	testStore(t, store)
+
+	// The file store needs a passphrase
+	if _, err := OpenWithFallback("file", Options{Dir: t.TempDir()}); err == nil {
+		t.Error("Expected the file store without passphrase to be reported")
+	}
+}
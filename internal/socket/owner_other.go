@@ -0,0 +1,10 @@
+//go:build !unix
+
+package socket
+
+import "io/fs"
+
+// fileOwner reports that the owner of files is unknown.
+func fileOwner(fs.FileInfo) (int, bool) {
+	return 0, false
+}
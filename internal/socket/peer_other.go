@@ -0,0 +1,11 @@
+//go:build !linux
+
+package socket
+
+import "net"
+
+// checkPeer accepts every connection where the peer credentials are not
+// available, relying on the permissions of the socket and its directory.
+func checkPeer(*net.UnixConn) error {
+	return nil
+}
@@ -0,0 +1,151 @@
+// Package socket places the Unix sockets the kagapass agents are served on.
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrInUse is returned when a running server already listens on the socket.
+	ErrInUse = errors.New("already listening")
+	// ErrNotPrivate is returned when another user could reach the socket or
+	// replace it, or when it is served by another user.
+	ErrNotPrivate = errors.New("not private to the user")
+)
+
+// Path returns the path in the environment variable, or name in a kagapass
+// directory of $XDG_RUNTIME_DIR or of the temporary directory.
+func Path(env string, name string) string {
+	if path := os.Getenv(env); path != "" {
+		return path
+	}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "kagapass", name)
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kagapass-%d", os.Getuid()), name)
+}
+
+// Listen listens on the socket, only accessible to the user, creating its
+// directory for the user alone. A directory another user owns or can enter is
+// refused, since the shared temporary directory lets anyone create it first.
+// A socket left by a server that is no longer running is replaced, and
+// connections from other users are closed as soon as they are accepted.
+func Listen(path string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+
+			return nil, fmt.Errorf("%w on %s", ErrInUse, path)
+		}
+
+		err := os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, 0o600)
+	if err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	return &privateListener{UnixListener: listener}, nil
+}
+
+// Check returns an error unless the socket and its directory belong to the
+// user, and the directory is private to them, so that a client never sends
+// secrets to a server another user put in place.
+func Check(path string) error {
+	err := checkDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s is %w: not a socket", path, ErrNotPrivate)
+	}
+
+	return checkOwner(path, info)
+}
+
+// checkDir returns an error unless dir is a directory owned by the user that
+// only they can access.
+func checkDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is %w: not a directory", dir, ErrNotPrivate)
+	}
+
+	if info.Mode().Perm() != 0o700 {
+		return fmt.Errorf("%s is %w: mode %04o instead of 0700", dir, ErrNotPrivate, info.Mode().Perm())
+	}
+
+	return checkOwner(dir, info)
+}
+
+func checkOwner(path string, info fs.FileInfo) error {
+	uid, ok := fileOwner(info)
+	if ok && uid != os.Getuid() {
+		return fmt.Errorf("%s is %w: owned by uid %d", path, ErrNotPrivate, uid)
+	}
+
+	return nil
+}
+
+// privateListener closes the connections of other users, in case the socket
+// permissions are not enforced.
+type privateListener struct {
+	*net.UnixListener
+}
+
+// Accept implements net.Listener.
+func (l *privateListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkPeer(conn)
+		if err == nil {
+			return conn, nil
+		}
+
+		log.Printf("refused connection: %v", err)
+		conn.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package socket
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kagapass", "test.sock")
+
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("Failed to stat directory: %v", err)
+	}
+
+	if info.Mode().Perm() != 0o700 {
+		t.Errorf("Expected directory mode 0700, got %04o", info.Mode().Perm())
+	}
+
+	if err := Check(path); err != nil {
+		t.Errorf("Check() failed: %v", err)
+	}
+
+	if _, err := Listen(path); !errors.Is(err, ErrInUse) {
+		t.Errorf("Expected ErrInUse, got %v", err)
+	}
+}
+
+func TestListenRefusesSharedDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "kagapass")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	// Another user could replace the socket in a directory they can write to
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+
+	if _, err := Listen(filepath.Join(dir, "test.sock")); !errors.Is(err, ErrNotPrivate) {
+		t.Errorf("Expected ErrNotPrivate for a shared directory, got %v", err)
+	}
+
+	// A link created in the temporary directory may point anywhere
+	if err := os.Chmod(dir, 0o700); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+
+	if _, err := Listen(filepath.Join(link, "test.sock")); !errors.Is(err, ErrNotPrivate) {
+		t.Errorf("Expected ErrNotPrivate for a linked directory, got %v", err)
+	}
+}
+
+func TestCheckRefusesFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "kagapass")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	path := filepath.Join(dir, "test.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if err := Check(path); !errors.Is(err, ErrNotPrivate) {
+		t.Errorf("Expected ErrNotPrivate for a regular file, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package sshagent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf16"
+
+	"github.com/martinlehoux/kagapass/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// SettingsAttachment is the attachment in which KeeAgent, and KeePassXC, keep
+// the SSH agent settings of an entry.
+const SettingsAttachment = "KeeAgent.settings"
+
+const (
+	// AttachmentLocation reads the key from an attachment of the entry.
+	AttachmentLocation = "attachment"
+	// FileLocation reads the key from a file outside the database.
+	FileLocation = "file"
+)
+
+// Settings are the KeeAgent settings of an entry.
+type Settings struct {
+	AllowUseOfSshKey                bool     `xml:"AllowUseOfSshKey"`
+	AddAtDatabaseOpen               bool     `xml:"AddAtDatabaseOpen"`
+	RemoveAtDatabaseClose           bool     `xml:"RemoveAtDatabaseClose"`
+	UseConfirmConstraintWhenAdding  bool     `xml:"UseConfirmConstraintWhenAdding"`
+	UseLifetimeConstraintWhenAdding bool     `xml:"UseLifetimeConstraintWhenAdding"`
+	LifetimeConstraintDuration      uint32   `xml:"LifetimeConstraintDuration"`
+	Location                        Location `xml:"Location"`
+}
+
+// Location is where the private key of an entry is stored.
+type Location struct {
+	// SelectedType is AttachmentLocation or FileLocation
+	SelectedType   string `xml:"SelectedType"`
+	AttachmentName string `xml:"AttachmentName"`
+	FileName       string `xml:"FileName"`
+}
+
+// Key is the SSH key of an entry, ready to be added to an agent.
+type Key struct {
+	Entry    types.Entry
+	Settings Settings
+	// Private is the decrypted private key, as parsed by ssh.ParseRawPrivateKey
+	Private any
+	Public  ssh.PublicKey
+}
+
+// ParseSettings parses KeeAgent settings, written in UTF-16 by KeeAgent and in
+// UTF-8 by KeePassXC. Missing elements keep the KeeAgent defaults.
+func ParseSettings(data []byte) (Settings, error) {
+	settings := Settings{
+		AllowUseOfSshKey:                false,
+		AddAtDatabaseOpen:               true,
+		RemoveAtDatabaseClose:           true,
+		UseConfirmConstraintWhenAdding:  false,
+		UseLifetimeConstraintWhenAdding: false,
+		LifetimeConstraintDuration:      0,
+		Location:                        Location{SelectedType: AttachmentLocation, AttachmentName: "", FileName: ""},
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(decodeText(data)))
+	// The text is already UTF-8 whatever the declaration says
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	err := decoder.Decode(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("invalid %s: %w", SettingsAttachment, err)
+	}
+
+	return settings, nil
+}
+
+// Keys reads the keys of the entries whose settings allow the SSH agent and
+// ask to add the key when the database opens. Entries failing to load are
+// reported and skipped.
+func Keys(entries []types.Entry, read func(entry types.Entry, name string) ([]byte, error)) ([]Key, error) {
+	var (
+		keys []Key
+		errs []error
+	)
+
+	for _, entry := range entries {
+		if !hasAttachment(entry, SettingsAttachment) {
+			continue
+		}
+
+		data, err := read(entry, SettingsAttachment)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Title, err))
+
+			continue
+		}
+
+		settings, err := ParseSettings(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Title, err))
+
+			continue
+		}
+
+		if !settings.AllowUseOfSshKey || !settings.AddAtDatabaseOpen {
+			continue
+		}
+
+		key, err := readKey(entry, settings, read)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Title, err))
+
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, errors.Join(errs...)
+}
+
+// readKey reads and decrypts the private key of the entry, using the entry
+// password as passphrase as KeeAgent does.
+func readKey(entry types.Entry, settings Settings, read func(entry types.Entry, name string) ([]byte, error)) (Key, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	switch settings.Location.SelectedType {
+	case AttachmentLocation:
+		data, err = read(entry, settings.Location.AttachmentName)
+	case FileLocation:
+		data, err = os.ReadFile(settings.Location.FileName)
+	default:
+		err = fmt.Errorf("unknown key location %q", settings.Location.SelectedType)
+	}
+
+	if err != nil {
+		return Key{}, err //nolint:exhaustruct // No key
+	}
+
+	private, err := ssh.ParseRawPrivateKey(data)
+
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		private, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(entry.Password))
+	}
+
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to read SSH key: %w", err) //nolint:exhaustruct // No key
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return Key{}, fmt.Errorf("unsupported SSH key: %w", err) //nolint:exhaustruct // No key
+	}
+
+	return Key{Entry: entry, Settings: settings, Private: private, Public: signer.PublicKey()}, nil
+}
+
+func hasAttachment(entry types.Entry, name string) bool {
+	for _, attachment := range entry.Attachments {
+		if attachment.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeText converts UTF-16 text, recognised by its byte order mark, to
+// UTF-8.
+func decodeText(data []byte) []byte {
+	var order binary.ByteOrder
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		order = binary.BigEndian
+	default:
+		return data
+	}
+
+	units := make([]uint16, (len(data)-2)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2+2*i:])
+	}
+
+	return []byte(string(utf16.Decode(units)))
+}
@@ -0,0 +1,276 @@
+// Package sshagent adds the SSH keys stored in entries with KeeAgent settings
+// to an SSH agent while their database is unlocked.
+package sshagent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/martinlehoux/kagapass/internal/socket"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// SystemMode uses the agent of $SSH_AUTH_SOCK, or the built-in agent
+	// when none is running.
+	SystemMode = "system"
+	// BuiltinMode serves a built-in agent on its own socket.
+	BuiltinMode = "builtin"
+	// OffMode never adds keys.
+	OffMode = "off"
+)
+
+// ErrDisabled is returned when opening the agent in OffMode.
+var ErrDisabled = errors.New("SSH agent integration is disabled")
+
+// Agent adds the keys of unlocked databases to an SSH agent and removes them
+// when the databases are locked.
+type Agent struct {
+	// client is nil until the built-in agent is served
+	client agent.Agent
+	// socket is the path to set SSH_AUTH_SOCK to
+	socket string
+	// builtin is set for the built-in agent, which cannot ask for confirmation
+	builtin bool
+	// closer is nil until the built-in agent is served
+	closer io.Closer
+
+	mu sync.Mutex
+	// added are the keys to remove when their database is locked, by path
+	added map[string][]ssh.PublicKey
+}
+
+// NewAgent wraps an agent client.
+func NewAgent(client agent.Agent, socket string, builtin bool, closer io.Closer) *Agent {
+	return &Agent{
+		client:  client,
+		socket:  socket,
+		builtin: builtin,
+		closer:  closer,
+		mu:      sync.Mutex{},
+		added:   map[string][]ssh.PublicKey{},
+	}
+}
+
+// Open opens the agent selected by the mode, see SystemMode, BuiltinMode and
+// OffMode.
+func Open(mode string) (*Agent, error) {
+	switch mode {
+	case SystemMode, "":
+		if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+			return Connect(socket)
+		}
+
+		return Builtin(SocketPath()), nil
+	case BuiltinMode:
+		return Builtin(SocketPath()), nil
+	case OffMode:
+		return nil, ErrDisabled
+	default:
+		return nil, fmt.Errorf("unknown SSH agent mode %q", mode)
+	}
+}
+
+// Connect connects to the running agent listening on socket.
+func Connect(socket string) (*Agent, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the SSH agent: %w", err)
+	}
+
+	return NewAgent(agent.NewClient(conn), socket, false, conn), nil
+}
+
+// Builtin returns the built-in agent, served on socket, only accessible to
+// the user, from the first key added until it is closed.
+func Builtin(socket string) *Agent {
+	return NewAgent(nil, socket, true, nil)
+}
+
+// Serve serves the built-in agent on socket right away.
+func Serve(socket string) (*Agent, error) {
+	builtin := Builtin(socket)
+
+	err := builtin.serve()
+	if err != nil {
+		return nil, err
+	}
+
+	return builtin, nil
+}
+
+func (a *Agent) serve() error {
+	listener, err := socket.Listen(a.socket)
+	if errors.Is(err, socket.ErrInUse) {
+		return fmt.Errorf("an SSH agent is %w", err)
+	} else if err != nil {
+		return err
+	}
+
+	keyring := agent.NewKeyring()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				err := agent.ServeAgent(keyring, conn)
+				if err != nil && !errors.Is(err, io.EOF) {
+					log.Printf("failed to serve SSH agent connection: %v", err)
+				}
+			}()
+		}
+	}()
+
+	a.client = keyring
+	a.closer = listener
+
+	return nil
+}
+
+// SocketPath returns $KAGAPASS_SSH_AUTH_SOCK, or ssh-agent.sock in a kagapass
+// directory of $XDG_RUNTIME_DIR or of the temporary directory.
+func SocketPath() string {
+	return socket.Path("KAGAPASS_SSH_AUTH_SOCK", "ssh-agent.sock")
+}
+
+// Socket returns the path to set SSH_AUTH_SOCK to for using the agent.
+func (a *Agent) Socket() string {
+	return a.socket
+}
+
+// Builtin tells whether the agent is the built-in one.
+func (a *Agent) Builtin() bool {
+	return a.builtin
+}
+
+// Add adds the keys of the database to the agent, remembering those to remove
+// when it is locked. Keys failing to be added are reported and skipped.
+func (a *Agent) Add(database types.Database, keys []Key) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.client == nil && len(keys) > 0 {
+		err := a.serve()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var errs []error
+
+	count := 0
+
+	for _, key := range keys {
+		if key.Settings.UseConfirmConstraintWhenAdding && a.builtin {
+			errs = append(errs, fmt.Errorf("%s: the built-in agent cannot ask for confirmation", key.Entry.Title))
+
+			continue
+		}
+
+		var lifetime uint32
+		if key.Settings.UseLifetimeConstraintWhenAdding {
+			lifetime = key.Settings.LifetimeConstraintDuration
+		}
+
+		err := a.client.Add(agent.AddedKey{
+			PrivateKey:           key.Private,
+			Certificate:          nil,
+			Comment:              key.Entry.Title,
+			LifetimeSecs:         lifetime,
+			ConfirmBeforeUse:     key.Settings.UseConfirmConstraintWhenAdding,
+			ConstraintExtensions: nil,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key.Entry.Title, err))
+
+			continue
+		}
+
+		count++
+
+		if key.Settings.RemoveAtDatabaseClose {
+			a.added[database.Path] = append(a.added[database.Path], key.Public)
+		}
+	}
+
+	return count, errors.Join(errs...)
+}
+
+// Remove removes the keys added for the database. Keys already gone, like
+// expired ones, are not an error.
+func (a *Agent) Remove(database types.Database) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.remove(database.Path)
+}
+
+// Close removes the keys of all databases and disconnects from the agent, or
+// stops the built-in one.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var errs []error
+
+	for path := range a.added {
+		errs = append(errs, a.remove(path))
+	}
+
+	if a.closer != nil {
+		errs = append(errs, a.closer.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (a *Agent) remove(path string) error {
+	if len(a.added[path]) == 0 {
+		return nil
+	}
+
+	present, err := a.client.List()
+	if err != nil {
+		return fmt.Errorf("failed to list SSH agent keys: %w", err)
+	}
+
+	var errs []error
+
+	for _, key := range a.added[path] {
+		if !contains(present, key) {
+			continue
+		}
+
+		err := a.client.Remove(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove SSH key %s: %w", ssh.FingerprintSHA256(key), err))
+		}
+	}
+
+	delete(a.added, path)
+
+	return errors.Join(errs...)
+}
+
+func contains(keys []*agent.Key, key ssh.PublicKey) bool {
+	for _, present := range keys {
+		if string(present.Marshal()) == string(key.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
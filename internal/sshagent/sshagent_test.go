@@ -0,0 +1,233 @@
+package sshagent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/martinlehoux/kagapass/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// keeAgentSettings are settings as KeeAgent writes them.
+const keeAgentSettings = `<?xml version="1.0" encoding="utf-16"?>
+<EntrySettings xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <AllowUseOfSshKey>true</AllowUseOfSshKey>
+  <AddAtDatabaseOpen>true</AddAtDatabaseOpen>
+  <RemoveAtDatabaseClose>%s</RemoveAtDatabaseClose>
+  <UseConfirmConstraintWhenAdding>false</UseConfirmConstraintWhenAdding>
+  <UseLifetimeConstraintWhenAdding>true</UseLifetimeConstraintWhenAdding>
+  <LifetimeConstraintDuration>600</LifetimeConstraintDuration>
+  <Location>
+    <SelectedType>attachment</SelectedType>
+    <AttachmentName>id_ed25519</AttachmentName>
+    <SaveAttachmentToTempFile>false</SaveAttachmentToTempFile>
+  </Location>
+</EntrySettings>`
+
+// utf16LE encodes the text as KeeAgent does, with a byte order mark.
+func utf16LE(text string) []byte {
+	data := []byte{0xFF, 0xFE}
+	for _, unit := range utf16.Encode([]rune(text)) {
+		data = append(data, byte(unit), byte(unit>>8))
+	}
+
+	return data
+}
+
+// sshEntry returns an entry holding an ed25519 key encrypted with its
+// password, and its attachments.
+func sshEntry(t *testing.T, title string, removeAtClose string) (types.Entry, map[string][]byte) {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(private, title, []byte(title+"-pass"))
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	entry := types.Entry{
+		Title:       title,
+		Password:    title + "-pass",
+		Attachments: []types.Attachment{{Name: SettingsAttachment}, {Name: "id_ed25519"}},
+	}
+	attachments := map[string][]byte{
+		SettingsAttachment: utf16LE(strings.Replace(keeAgentSettings, "%s", removeAtClose, 1)),
+		"id_ed25519":       pem.EncodeToMemory(block),
+	}
+
+	return entry, attachments
+}
+
+func TestParseSettings(t *testing.T) {
+	settings, err := ParseSettings(utf16LE(strings.Replace(keeAgentSettings, "%s", "false", 1)))
+	if err != nil {
+		t.Fatalf("ParseSettings() failed: %v", err)
+	}
+
+	if !settings.AllowUseOfSshKey || settings.RemoveAtDatabaseClose || settings.LifetimeConstraintDuration != 600 {
+		t.Errorf("Expected the KeeAgent settings, got %+v", settings)
+	}
+
+	if settings.Location.SelectedType != AttachmentLocation || settings.Location.AttachmentName != "id_ed25519" {
+		t.Errorf("Expected the key in the id_ed25519 attachment, got %+v", settings.Location)
+	}
+
+	// Missing elements keep the KeeAgent defaults
+	settings, err = ParseSettings([]byte(`<EntrySettings><AllowUseOfSshKey>true</AllowUseOfSshKey></EntrySettings>`))
+	if err != nil {
+		t.Fatalf("ParseSettings() failed: %v", err)
+	}
+
+	if !settings.AddAtDatabaseOpen || !settings.RemoveAtDatabaseClose {
+		t.Errorf("Expected keys to be added on open and removed on close by default, got %+v", settings)
+	}
+
+	if _, err := ParseSettings([]byte("not xml")); err == nil {
+		t.Error("Expected invalid settings to be reported")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	github, githubAttachments := sshEntry(t, "GitHub", "true")
+	wrong, wrongAttachments := sshEntry(t, "Server", "true")
+	wrong.Password = "wrong"
+	plain := types.Entry{Title: "Bank", Attachments: []types.Attachment{{Name: "statement.pdf"}}}
+
+	attachments := map[string]map[string][]byte{"GitHub": githubAttachments, "Server": wrongAttachments}
+	read := func(entry types.Entry, name string) ([]byte, error) {
+		data, ok := attachments[entry.Title][name]
+		if !ok {
+			return nil, errors.New("attachment not found")
+		}
+
+		return data, nil
+	}
+
+	keys, err := Keys([]types.Entry{github, wrong, plain}, read)
+	if err == nil || !strings.Contains(err.Error(), "Server") {
+		t.Errorf("Expected the key with a wrong passphrase to be reported, got %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].Entry.Title != "GitHub" {
+		t.Fatalf("Expected the key of GitHub, got %d keys", len(keys))
+	}
+}
+
+func TestAgent(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "ssh", "agent.sock")
+
+	builtin, err := Serve(socket)
+	if err != nil {
+		t.Fatalf("Serve() failed: %v", err)
+	}
+	defer builtin.Close()
+
+	if _, err := Serve(socket); err == nil {
+		t.Error("Expected serving on the socket of a running agent to fail")
+	}
+
+	client, err := Connect(socket)
+	if err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+
+	var keys []Key
+
+	for _, entry := range []struct{ title, removeAtClose string }{{"GitHub", "true"}, {"Server", "false"}} {
+		sshEntry, attachments := sshEntry(t, entry.title, entry.removeAtClose)
+
+		entryKeys, err := Keys([]types.Entry{sshEntry}, func(_ types.Entry, name string) ([]byte, error) {
+			return attachments[name], nil
+		})
+		if err != nil {
+			t.Fatalf("Keys() failed: %v", err)
+		}
+
+		keys = append(keys, entryKeys...)
+	}
+
+	database := types.Database{Name: "personal", Path: "personal.kdbx"}
+
+	count, err := client.Add(database, keys)
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 keys to be added, got %d, %v", count, err)
+	}
+
+	listed, _ := builtin.client.List()
+	if len(listed) != 2 || listed[0].Comment != "GitHub" {
+		t.Errorf("Expected both keys in the agent, got %v", listed)
+	}
+
+	if err := client.Remove(database); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+
+	// Only keys removed at database close are removed
+	listed, _ = builtin.client.List()
+	if len(listed) != 1 || listed[0].Comment != "Server" {
+		t.Errorf("Expected only the Server key to stay, got %v", listed)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+
+	// The built-in agent cannot ask for confirmation
+	keys[0].Settings.UseConfirmConstraintWhenAdding = true
+	if _, err := builtin.Add(database, keys[:1]); err == nil {
+		t.Error("Expected the confirmation constraint to be refused by the built-in agent")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open(OffMode); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Expected ErrDisabled, got %v", err)
+	}
+
+	if _, err := Open("unknown"); err == nil {
+		t.Error("Expected an unknown mode to be reported")
+	}
+
+	// Without a running agent, keys go to the built-in one
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("KAGAPASS_SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "ssh", "agent.sock"))
+
+	sshAgent, err := Open(SystemMode)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer sshAgent.Close()
+
+	if !sshAgent.Builtin() {
+		t.Error("Expected the built-in agent")
+	}
+
+	// The built-in agent is only served once it has keys
+	if _, err := os.Stat(sshAgent.Socket()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no socket before adding keys, got %v", err)
+	}
+
+	entry, attachments := sshEntry(t, "GitHub", "true")
+
+	keys, _ := Keys([]types.Entry{entry}, func(_ types.Entry, name string) ([]byte, error) {
+		return attachments[name], nil
+	})
+	if _, err := sshAgent.Add(types.Database{Name: "personal", Path: "personal.kdbx"}, keys); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if _, err := os.Stat(sshAgent.Socket()); err != nil {
+		t.Errorf("Expected the socket once keys are added, got %v", err)
+	}
+}
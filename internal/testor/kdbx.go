@@ -0,0 +1,65 @@
+package testor
+
+import (
+	"os"
+	"testing"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// NewDatabase returns a KDBX 4 database opened with the credentials. The
+// groups replace the default root group when given.
+func NewDatabase(credentials *gokeepasslib.DBCredentials, groups ...gokeepasslib.Group) *gokeepasslib.Database {
+	database := gokeepasslib.NewDatabase(gokeepasslib.WithDatabaseKDBXVersion4())
+	database.Credentials = credentials
+
+	if len(groups) > 0 {
+		database.Content.Root = &gokeepasslib.RootData{Groups: groups} //nolint:exhaustruct // No deleted objects
+	}
+
+	return database
+}
+
+// NewGroup returns a group holding the entries.
+func NewGroup(name string, entries ...gokeepasslib.Entry) gokeepasslib.Group {
+	group := gokeepasslib.NewGroup()
+	group.Name = name
+	group.Entries = entries
+
+	return group
+}
+
+// NewEntry returns an entry with a protected password, and the given extra
+// fields.
+func NewEntry(title string, username string, password string, fields ...gokeepasslib.ValueData) gokeepasslib.Entry {
+	entry := gokeepasslib.NewEntry()
+	entry.Values = append(entry.Values,
+		gokeepasslib.ValueData{Key: "Title", Value: gokeepasslib.V{Content: title, Protected: w.NewBoolWrapper(false)}},
+		gokeepasslib.ValueData{Key: "UserName", Value: gokeepasslib.V{Content: username, Protected: w.NewBoolWrapper(false)}},
+		gokeepasslib.ValueData{Key: "Password", Value: gokeepasslib.V{Content: password, Protected: w.NewBoolWrapper(true)}},
+	)
+	entry.Values = append(entry.Values, fields...)
+
+	return entry
+}
+
+// WriteDatabase locks the protected values of the database and writes it to
+// the path, failing the test on error.
+func WriteDatabase(t testing.TB, path string, database *gokeepasslib.Database) {
+	t.Helper()
+
+	if err := database.LockProtectedEntries(); err != nil {
+		t.Fatalf("Failed to lock entries: %v", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create database file: %v", err)
+	}
+	defer file.Close()
+
+	if err := gokeepasslib.NewEncoder(file).Encode(database); err != nil {
+		t.Fatalf("Failed to encode database: %v", err)
+	}
+}
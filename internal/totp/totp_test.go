@@ -0,0 +1,111 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 appendix B.
+func TestCodeRFC6238(t *testing.T) {
+	secrets := map[Algorithm]string{
+		SHA1:   "12345678901234567890",
+		SHA256: "12345678901234567890123456789012",
+		SHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+
+	tests := []struct {
+		unix      int64
+		algorithm Algorithm
+		code      string
+	}{
+		{59, SHA1, "94287082"},
+		{59, SHA256, "46119246"},
+		{59, SHA512, "90693936"},
+		{1111111109, SHA1, "07081804"},
+		{1111111109, SHA256, "68084774"},
+		{1111111109, SHA512, "25091201"},
+		{2000000000, SHA1, "69279037"},
+		{20000000000, SHA512, "47863826"},
+	}
+
+	for _, test := range tests {
+		totp := TOTP{Secret: []byte(secrets[test.algorithm]), Algorithm: test.algorithm, Digits: 8, Period: 30 * time.Second}
+
+		code := totp.Code(time.Unix(test.unix, 0))
+		if code != test.code {
+			t.Errorf("%s at %d: expected %s, got %s", test.algorithm, test.unix, test.code, code)
+		}
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890123456789012"))
+
+	totp, err := ParseURI("otpauth://totp/ACME:john@example.com?secret=" + secret + "&algorithm=SHA256&digits=8&period=60")
+	if err != nil {
+		t.Fatalf("ParseURI() failed: %v", err)
+	}
+
+	if totp.Algorithm != SHA256 || totp.Digits != 8 || totp.Period != time.Minute {
+		t.Errorf("Unexpected parameters: %+v", totp)
+	}
+
+	if totp.Issuer != "ACME" || totp.Account != "john@example.com" {
+		t.Errorf("Unexpected label: issuer '%s', account '%s'", totp.Issuer, totp.Account)
+	}
+}
+
+func TestParseURIDefaults(t *testing.T) {
+	totp, err := ParseURI("otpauth://totp/Example?secret=JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("ParseURI() failed: %v", err)
+	}
+
+	if totp.Algorithm != SHA1 || totp.Digits != 6 || totp.Period != 30*time.Second {
+		t.Errorf("Expected RFC defaults, got %+v", totp)
+	}
+}
+
+func TestParseURIInvalid(t *testing.T) {
+	for _, uri := range []string{
+		"https://example.com",
+		"otpauth://hotp/Example?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/Example",
+		"otpauth://totp/Example?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/Example?secret=JBSWY3DPEHPK3PXP&digits=0",
+	} {
+		if _, err := ParseURI(uri); err == nil {
+			t.Errorf("Expected error for '%s'", uri)
+		}
+	}
+}
+
+func TestParseLegacy(t *testing.T) {
+	totp, err := ParseLegacy("JBSW Y3DP EHPK 3PXP", "60;8")
+	if err != nil {
+		t.Fatalf("ParseLegacy() failed: %v", err)
+	}
+
+	if totp.Period != time.Minute || totp.Digits != 8 {
+		t.Errorf("Unexpected parameters: %+v", totp)
+	}
+
+	steam, err := ParseLegacy("JBSWY3DPEHPK3PXP", "30;S")
+	if err != nil {
+		t.Fatalf("ParseLegacy() failed: %v", err)
+	}
+
+	code := steam.Code(time.Unix(0, 0))
+	if len(code) != 5 {
+		t.Errorf("Expected a 5 character Steam code, got '%s'", code)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	totp := TOTP{Secret: []byte("secret"), Algorithm: SHA1, Digits: 6, Period: 30 * time.Second}
+
+	if remaining := totp.Remaining(time.Unix(65, 0)); remaining != 25*time.Second {
+		t.Errorf("Expected 25s remaining, got %s", remaining)
+	}
+}
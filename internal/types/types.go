@@ -3,6 +3,7 @@ package types
 import (
 	"time"
 
+	"github.com/martinlehoux/kagapass/internal/totp"
 	"github.com/tobischo/gokeepasslib/v3"
 )
 
@@ -11,6 +12,10 @@ type Database struct {
 	Name         string    `json:"name"`
 	Path         string    `json:"path"`
 	LastAccessed time.Time `json:"last_accessed"`
+	// KeyFile is the path of the key file used along or instead of a password
+	KeyFile string `json:"key_file,omitempty"`
+	// SecretStoredAt is when the password was last stored in the secret store
+	SecretStoredAt time.Time `json:"secret_stored_at,omitzero"`
 }
 
 // DatabaseList holds the list of configured databases.
@@ -19,6 +24,21 @@ type DatabaseList struct {
 	LastUsed  string     `json:"last_used"`
 }
 
+// Field is a custom string field of an entry.
+type Field struct {
+	Key       string
+	Value     string
+	Protected bool
+}
+
+// Attachment describes a file attached to an entry, whose content is read
+// from the database on demand.
+type Attachment struct {
+	Name string
+	// Size is the size of the content in bytes
+	Size int
+}
+
 // Entry represents a KeePass entry with additional display information.
 type Entry struct {
 	Title    string
@@ -26,28 +46,175 @@ type Entry struct {
 	Password string
 	URL      string
 	Notes    string
-	Group    string
-	Modified time.Time
-	Created  time.Time
+	// Fields holds the custom string fields, in database order
+	Fields []Field
+	// Attachments holds the attached files, in database order
+	Attachments []Attachment
+	Tags        []string
+	// Group is the slash-joined path of the group holding the entry, GroupUUID
+	// identifies it in the Group tree
+	Group     string
+	GroupUUID gokeepasslib.UUID
+	Modified  time.Time
+	Created   time.Time
+	// TOTP is nil when the entry has no (valid) one-time password configured
+	TOTP *totp.TOTP
+	// Database is the configured database the entry was loaded from
+	Database Database
 	Raw      gokeepasslib.Entry
 }
 
+// Group is a node of the group tree of a database.
+type Group struct {
+	UUID gokeepasslib.UUID
+	Name string
+	// Path is the slash-joined names from the root group, whose path is empty
+	Path   string
+	IconID int64
+	Groups []Group
+	// EntryCount counts the entries directly in the group
+	EntryCount int
+}
+
+// TotalEntries counts the entries of the group and of all its subgroups.
+func (g Group) TotalEntries() int {
+	total := g.EntryCount
+	for _, child := range g.Groups {
+		total += child.TotalEntries()
+	}
+
+	return total
+}
+
+// All returns the group and all its subgroups, depth first.
+func (g Group) All() []Group {
+	groups := []Group{g}
+	for _, child := range g.Groups {
+		groups = append(groups, child.All()...)
+	}
+
+	return groups
+}
+
+// PolicyKind selects what a password policy generates.
+type PolicyKind string
+
+const (
+	PasswordPolicyKind   PolicyKind = "password"
+	PassphrasePolicyKind PolicyKind = "passphrase"
+)
+
+// PasswordPolicy is a named preset for the password generator.
+type PasswordPolicy struct {
+	Name string     `json:"name"`
+	Kind PolicyKind `json:"kind"`
+
+	// Password settings
+	Length            int  `json:"length,omitempty"`
+	Upper             bool `json:"upper,omitempty"`
+	Lower             bool `json:"lower,omitempty"`
+	Digits            bool `json:"digits,omitempty"`
+	Symbols           bool `json:"symbols,omitempty"`
+	ExcludeLookAlikes bool `json:"exclude_look_alikes,omitempty"`
+
+	// Passphrase settings
+	Words     int    `json:"words,omitempty"`
+	Separator string `json:"separator,omitempty"`
+}
+
 // Config holds application configuration.
 type Config struct {
-	ClipboardClearSeconds int    `json:"clipboard_clear_seconds"`
-	SearchDebounceMs      int    `json:"search_debounce_ms"`
-	MaxSearchResults      int    `json:"max_search_results"`
-	SessionTimeoutHours   int    `json:"session_timeout_hours"`
-	DefaultDatabasePath   string `json:"default_database_path"`
+	ClipboardClearSeconds int `json:"clipboard_clear_seconds"`
+	// ClipboardSelection is where text is copied to: clipboard or primary
+	ClipboardSelection string `json:"clipboard_selection"`
+	// ClipboardBackend is how text is copied: auto, with the clipboard tools
+	// found, or x11, owning the selections in process
+	ClipboardBackend    string           `json:"clipboard_backend"`
+	SearchDebounceMs    int              `json:"search_debounce_ms"`
+	MaxSearchResults    int              `json:"max_search_results"`
+	SessionTimeoutHours int              `json:"session_timeout_hours"`
+	IdleLockMinutes     int              `json:"idle_lock_minutes"`
+	DefaultDatabasePath string           `json:"default_database_path"`
+	PasswordPolicies    []PasswordPolicy `json:"password_policies"`
+	// SecretStore is where master passwords are remembered: keyring, file,
+	// pass, memory or none
+	SecretStore string `json:"secret_store"`
+	// SSHAgent is where the SSH keys of entries with KeeAgent settings are
+	// added on unlock: system, builtin or off
+	SSHAgent string `json:"ssh_agent"`
+	// ScanRoots are the directories searched for databases to add, like
+	// synchronised folders
+	ScanRoots []string `json:"scan_roots"`
 }
 
 // DefaultConfig returns the default application configuration.
 func DefaultConfig() Config {
 	return Config{
 		ClipboardClearSeconds: 10,
+		ClipboardSelection:    "clipboard",
+		ClipboardBackend:      "auto",
 		SearchDebounceMs:      10,
 		MaxSearchResults:      10,
 		SessionTimeoutHours:   0, // 0 means until logout
+		IdleLockMinutes:       0, // 0 disables locking when idle
 		DefaultDatabasePath:   "",
+		PasswordPolicies:      DefaultPasswordPolicies(),
+		SecretStore:           "keyring",
+		SSHAgent:              "system",
+		ScanRoots:             []string{"~/Sync", "~/Nextcloud", "~/Dropbox"},
+	}
+}
+
+// DefaultPasswordPolicies returns the presets available when none are configured.
+func DefaultPasswordPolicies() []PasswordPolicy {
+	return []PasswordPolicy{
+		{
+			Name:              "strong",
+			Kind:              PasswordPolicyKind,
+			Length:            24,
+			Upper:             true,
+			Lower:             true,
+			Digits:            true,
+			Symbols:           true,
+			ExcludeLookAlikes: true,
+			Words:             0,
+			Separator:         "",
+		},
+		{
+			Name:              "alphanumeric",
+			Kind:              PasswordPolicyKind,
+			Length:            20,
+			Upper:             true,
+			Lower:             true,
+			Digits:            true,
+			Symbols:           false,
+			ExcludeLookAlikes: true,
+			Words:             0,
+			Separator:         "",
+		},
+		{
+			Name:              "pin-6",
+			Kind:              PasswordPolicyKind,
+			Length:            6,
+			Upper:             false,
+			Lower:             false,
+			Digits:            true,
+			Symbols:           false,
+			ExcludeLookAlikes: false,
+			Words:             0,
+			Separator:         "",
+		},
+		{
+			Name:              "passphrase",
+			Kind:              PassphrasePolicyKind,
+			Length:            0,
+			Upper:             false,
+			Lower:             false,
+			Digits:            false,
+			Symbols:           false,
+			ExcludeLookAlikes: false,
+			Words:             6,
+			Separator:         "-",
+		},
 	}
 }
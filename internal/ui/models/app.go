@@ -1,19 +1,49 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/martinlehoux/kagapass/internal/agent"
 	"github.com/martinlehoux/kagapass/internal/clipboard"
 	"github.com/martinlehoux/kagapass/internal/config"
 	"github.com/martinlehoux/kagapass/internal/keepass"
 	"github.com/martinlehoux/kagapass/internal/secretstore"
+	"github.com/martinlehoux/kagapass/internal/sshagent"
 	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/status"
 )
 
+// lockCheckInterval is how often the idle and session timeouts are checked.
+const lockCheckInterval = 10 * time.Second
+
+// lockCheckMsg triggers a check of the idle and session timeouts.
+type lockCheckMsg struct{}
+
+// reloadCheckInterval is how often the database files are checked for
+// changes made by other programs, like a file synchronisation.
+const reloadCheckInterval = 2 * time.Second
+
+// reloadCheckMsg triggers a check of the database files.
+type reloadCheckMsg struct{}
+
+// unlockResult is the outcome of an unlock started while the given
+// generation of databases was open.
+type unlockResult struct {
+	generation int
+	msg        tea.Msg
+}
+
+// clipboardClearMsg clears the clipboard once due, in the event loop like the
+// copies, rather than from a timer goroutine while the screen is rendered.
+type clipboardClearMsg struct {
+	clear func()
+}
+
 // Screen represents the current screen being displayed.
 type Screen int
 
@@ -22,6 +52,12 @@ const (
 	PasswordInputScreen
 	MainSearchScreen
 	EntryDetailsScreen
+	EntryEditScreen
+	PasswordGeneratorScreen
+	GroupTreeScreen
+	EntryHistoryScreen
+	DatabaseInfoScreen
+	DatabaseSettingsScreen
 )
 
 // AppModel is the main application model.
@@ -35,15 +71,51 @@ type AppModel struct {
 	keepassLoader *keepass.Loader
 	secretStore   secretstore.SecretStore
 	clipboard     *clipboard.Clipboard
+	// sshAgent receives the SSH keys of unlocked databases, nil when disabled
+	sshAgent *sshagent.Agent
 
 	// Commands
 	unlockDatabase *UnlockDatabase
 
+	// Currently unlocked databases, in opening order, and those still to unlock
+	unlocked      []unlockedDatabase
+	pendingUnlock []types.Database
+	// unlockGeneration changes whenever the databases are closed, so that the
+	// unlocks still running for the previous ones are dropped
+	unlockGeneration int
+
 	// Screen-specific models
-	fileSelector  *FileSelectModel
-	passwordModel *PasswordModel
-	searchModel   *SearchModel
-	detailsModel  *DetailsModel
+	fileSelector   *FileSelectModel
+	passwordModel  *PasswordModel
+	searchModel    *SearchModel
+	detailsModel   *DetailsModel
+	editModel      *EditModel
+	generatorModel *GeneratorModel
+	groupTreeModel *GroupTreeModel
+	historyModel   *HistoryModel
+	infoModel      *InfoModel
+	settingsModel  *SettingsModel
+
+	// Screens to go back to when leaving the edit, generator and info screens
+	editReturnScreen      Screen
+	generatorReturnScreen Screen
+	infoReturnScreen      Screen
+
+	// lastActivity is the time of the last key press, to lock when idle
+	lastActivity time.Time
+	now          func() time.Time
+}
+
+// unlockedDatabase is a database opened for searching and editing.
+type unlockedDatabase struct {
+	database types.Database
+	vault    Vault
+	entries  []types.Entry
+	// reloading is set while the file is checked, and decrypted again after a
+	// change
+	reloading bool
+	// checkFailed is set once a failed check was logged, until one succeeds
+	checkFailed bool
 }
 
 // NewAppModel creates a new application model.
@@ -64,28 +136,75 @@ func NewAppModel() (*AppModel, error) {
 	}
 
 	// Initialize service managers
-	keepassLoader := keepass.NewLoader(os.DirFS("/home/kagamino"))
-	secretStore, err := secretstore.NewKeyring()
-	kcore.Expect(err, "error initializing keyring")
+	keepassLoader := keepass.NewLoader(keepass.RootFS())
+	// Without the configured secret store, passwords are remembered until exit
+	secretStore, secretStoreErr := secretstore.OpenWithFallback(cfg.SecretStore, secretstore.OptionsFromEnv(configMgr.Dir()))
+	if secretStoreErr != nil {
+		log.Printf("failed to open secret store, using memory: %v", secretStoreErr)
+	}
+
+	err = secretstore.MoveKeys(secretStore, configMgr.MigratedPaths())
+	if err != nil {
+		log.Printf("failed to move passwords to the absolute path of their database: %v", err)
+	}
+
+	clipboardBackend, clipboardErr := clipboard.Open(cfg.ClipboardBackend)
+	if clipboardErr != nil {
+		log.Printf("failed to open clipboard backend, using the detected one: %v", clipboardErr)
+	}
+
+	clipboard := clipboard.NewWithBackend(clipboardBackend, clipboard.Selection(cfg.ClipboardSelection))
+	unlockDatabase := NewUnlockDatabase(keepassLoader, secretStore, time.Duration(cfg.SessionTimeoutHours)*time.Hour)
 
-	clipboard := clipboard.New()
-	unlockDatabase := &UnlockDatabase{
-		keepassLoader: keepassLoader,
-		secretStore:   &secretStore,
+	// A running agent keeps the databases unlocked instead of the secret store
+	if agentClient, err := agent.Dial(agent.SocketPath()); err == nil {
+		unlockDatabase.UseAgent(agentClient)
 	}
+
+	sshAgent, sshAgentErr := sshagent.Open(cfg.SSHAgent)
+	if errors.Is(sshAgentErr, sshagent.ErrDisabled) {
+		sshAgentErr = nil
+	} else if sshAgentErr != nil {
+		log.Printf("failed to open SSH agent: %v", sshAgentErr)
+	}
+
 	app := &AppModel{
-		screen:         FileSelectionScreen,
-		config:         cfg,
-		configMgr:      configMgr,
-		databases:      databases,
-		keepassLoader:  keepassLoader,
-		secretStore:    &secretStore,
-		clipboard:      clipboard,
-		unlockDatabase: unlockDatabase,
-		fileSelector:   NewFileSelectModel(databases, unlockDatabase),
-		passwordModel:  nil,
-		searchModel:    nil,
-		detailsModel:   nil,
+		screen:                FileSelectionScreen,
+		config:                cfg,
+		configMgr:             configMgr,
+		databases:             databases,
+		keepassLoader:         keepassLoader,
+		secretStore:           secretStore,
+		clipboard:             clipboard,
+		sshAgent:              sshAgent,
+		unlockDatabase:        unlockDatabase,
+		unlocked:              nil,
+		pendingUnlock:         nil,
+		unlockGeneration:      0,
+		fileSelector:          nil,
+		passwordModel:         nil,
+		searchModel:           nil,
+		detailsModel:          nil,
+		editModel:             nil,
+		generatorModel:        nil,
+		groupTreeModel:        nil,
+		historyModel:          nil,
+		infoModel:             nil,
+		settingsModel:         nil,
+		editReturnScreen:      MainSearchScreen,
+		generatorReturnScreen: MainSearchScreen,
+		infoReturnScreen:      FileSelectionScreen,
+		lastActivity:          time.Now(),
+		now:                   time.Now,
+	}
+	app.fileSelector = NewFileSelectModel(databases, cfg.ScanRoots, app.fileSelectActions())
+
+	if secretStoreErr != nil {
+		app.fileSelector.status = status.Error("Passwords are kept until exit only: " + secretStoreErr.Error())
+	} else if sshAgentErr != nil {
+		app.fileSelector.status = status.Error("SSH keys are not added to an agent: " + sshAgentErr.Error())
+	} else if clipboardErr != nil {
+		app.fileSelector.status = status.Error("Copies use the clipboard tools found: " + clipboardErr.Error())
 	}
 
 	return app, nil
@@ -99,18 +218,20 @@ func (m *AppModel) Init() tea.Cmd {
 		for i, db := range m.databases.Databases {
 			if db.Path == m.databases.LastUsed {
 				// Found the last used database, try to unlock it automatically
-				return m.unlockDatabase.Handle(m.databases.Databases[i], []byte{})
+				return tea.Batch(m.openDatabases([]types.Database{m.databases.Databases[i]}), m.scheduleLockCheck(), m.scheduleReloadCheck())
 			}
 		}
 	}
 
-	return nil
+	return tea.Batch(m.scheduleLockCheck(), m.scheduleReloadCheck())
 }
 
 // Update implements tea.Model.
 func (m *AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		m.lastActivity = m.now()
+
 		switch msg.String() {
 		case "ctrl+q":
 			return m, tea.Quit
@@ -128,8 +249,136 @@ func (m *AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		return m, nil
+	case lockCheckMsg:
+		return m, tea.Batch(m.checkLocks(), m.scheduleLockCheck())
+	case reloadCheckMsg:
+		return m, tea.Batch(m.checkChanges(), m.scheduleReloadCheck())
+	case clipboardClearMsg:
+		msg.clear()
+
+		return m, nil
+	case DatabaseChecked:
+		return m, m.databaseChecked(msg)
+	case DatabaseReloaded:
+		m.replaceDatabase(msg)
+
+		return m, nil
+	case DatabaseReloadFailed:
+		for i := range m.unlocked {
+			if m.unlocked[i].database.Path == msg.Database.Path {
+				m.unlocked[i].reloading = false
+
+				if m.searchModel != nil {
+					m.searchModel.status = status.Error(fmt.Sprintf("Failed to reload %s: %v", msg.Database.Name, msg.Error))
+				}
+			}
+		}
+
+		return m, nil
+	case SecretsExpired:
+		for _, expired := range msg.Databases {
+			for i := range m.databases.Databases {
+				if m.databases.Databases[i].Path == expired.Path {
+					m.databases.Databases[i].SecretStoredAt = time.Time{}
+				}
+			}
+		}
+
+		if m.configMgr != nil {
+			err := m.configMgr.SaveDatabaseList(m.databases)
+			if err != nil {
+				log.Printf("failed to save database list: %v", err)
+			}
+		}
+
+		return m, nil
+	case unlockResult:
+		if msg.generation != m.unlockGeneration {
+			// Unlocked for databases closed since, like after going back to the
+			// file selection or locking when idle
+			if unlocked, ok := msg.msg.(DatabaseUnlocked); ok {
+				unlocked.Vault.Close()
+			}
+
+			return m, nil
+		}
+
+		return m.Update(msg.msg)
 	case DatabaseUnlocked:
-		m.switchMainSearchScreen(msg.Database, msg.Entries)
+		m.rememberDatabase(msg.Database)
+		m.lastActivity = m.now()
+		m.unlocked = append(m.unlocked, unlockedDatabase{
+			database:  msg.Database,
+			vault:     msg.Vault,
+			entries:   msg.Entries,
+			reloading: false,
+		})
+
+		return m, tea.Batch(m.addSSHKeys(msg.Database, msg.Vault, msg.Entries), m.unlockNext())
+	case SSHKeysAdded:
+		m.showSSHKeysAdded(msg)
+
+		return m, nil
+	case EntrySaved:
+		if m.searchModel == nil {
+			// Locked while saving
+			return m, nil
+		}
+
+		m.setEntries(msg.Entry.Database, msg.Entries)
+		cmd := m.switchEntryDetailsScreen(msg.Entry)
+		m.detailsModel.status = status.Success("Entry saved")
+
+		return m, cmd
+	case MasterKeyChanged:
+		m.rememberDatabase(msg.Database)
+
+		if m.configMgr != nil {
+			err := m.configMgr.SaveDatabaseList(m.databases)
+			if err != nil {
+				log.Printf("failed to save database list: %v", err)
+			}
+		}
+
+		if m.searchModel == nil {
+			return m, nil
+		}
+
+		m.setDatabase(msg.Database)
+		m.searchModel.status = status.Success("Master key of " + msg.Database.Name + " changed")
+		m.screen = MainSearchScreen
+
+		return m, nil
+	case EntryDeleted:
+		if m.searchModel == nil {
+			return m, nil
+		}
+
+		m.setEntries(msg.Database, msg.Entries)
+		m.searchModel.status = status.Success("Entry deleted")
+		m.screen = MainSearchScreen
+
+		return m, nil
+	case searchDebounceMsg:
+		// Searches typed before leaving the search screen still complete
+		if m.searchModel != nil {
+			var cmd tea.Cmd
+
+			m.searchModel, cmd = m.searchModel.Update(msg)
+
+			return m, cmd
+		}
+
+		return m, nil
+	case totpTickMsg:
+		// The countdown goes on behind the screens opened from the details
+		if m.detailsModel != nil && m.detailsOpen() {
+			var cmd tea.Cmd
+
+			m.detailsModel, cmd = m.detailsModel.Update(msg)
+
+			return m, cmd
+		}
 
 		return m, nil
 	case DatabaseUnlockFailed:
@@ -163,6 +412,30 @@ func (m *AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case EntryDetailsScreen:
 		m.detailsModel, cmd = m.detailsModel.Update(msg)
 
+		return m, cmd
+	case EntryEditScreen:
+		m.editModel, cmd = m.editModel.Update(msg)
+
+		return m, cmd
+	case PasswordGeneratorScreen:
+		m.generatorModel, cmd = m.generatorModel.Update(msg)
+
+		return m, cmd
+	case GroupTreeScreen:
+		m.groupTreeModel, cmd = m.groupTreeModel.Update(msg)
+
+		return m, cmd
+	case EntryHistoryScreen:
+		m.historyModel, cmd = m.historyModel.Update(msg)
+
+		return m, cmd
+	case DatabaseInfoScreen:
+		m.infoModel, cmd = m.infoModel.Update(msg)
+
+		return m, cmd
+	case DatabaseSettingsScreen:
+		m.settingsModel, cmd = m.settingsModel.Update(msg)
+
 		return m, cmd
 	}
 
@@ -185,6 +458,18 @@ func (m *AppModel) chooseView() string {
 		return m.searchModel.View()
 	case EntryDetailsScreen:
 		return m.detailsModel.View()
+	case EntryEditScreen:
+		return m.editModel.View()
+	case PasswordGeneratorScreen:
+		return m.generatorModel.View()
+	case GroupTreeScreen:
+		return m.groupTreeModel.View()
+	case EntryHistoryScreen:
+		return m.historyModel.View()
+	case DatabaseInfoScreen:
+		return m.infoModel.View()
+	case DatabaseSettingsScreen:
+		return m.settingsModel.View()
 	}
 
 	return "Loading..."
@@ -194,16 +479,49 @@ func (m *AppModel) chooseView() string {
 func (m *AppModel) handleEscape() (*AppModel, tea.Cmd) {
 	switch m.screen {
 	case FileSelectionScreen:
+		// Escape first leaves adding a database
+		if m.fileSelector.cancel() {
+			return m, nil
+		}
+
 		return m, tea.Quit
 	case PasswordInputScreen:
-		m.screen = FileSelectionScreen
+		// Cancelling one password cancels the whole opening
+		m.switchFileSelectionScreen()
 
 		return m, nil
 	case MainSearchScreen:
-		m.screen = FileSelectionScreen
+		m.switchFileSelectionScreen()
 
 		return m, nil
 	case EntryDetailsScreen:
+		// Escape first cancels saving an attachment
+		if !m.detailsModel.cancelSave() {
+			m.screen = MainSearchScreen
+		}
+
+		return m, nil
+	case EntryEditScreen:
+		m.screen = m.editReturnScreen
+
+		return m, nil
+	case PasswordGeneratorScreen:
+		m.screen = m.generatorReturnScreen
+
+		return m, nil
+	case GroupTreeScreen:
+		m.screen = MainSearchScreen
+
+		return m, nil
+	case EntryHistoryScreen:
+		m.screen = EntryDetailsScreen
+
+		return m, nil
+	case DatabaseInfoScreen:
+		m.screen = m.infoReturnScreen
+
+		return m, nil
+	case DatabaseSettingsScreen:
 		m.screen = MainSearchScreen
 
 		return m, nil
@@ -213,19 +531,84 @@ func (m *AppModel) handleEscape() (*AppModel, tea.Cmd) {
 }
 
 func (m *AppModel) switchPasswordInputScreen(database types.Database) {
-	m.passwordModel = NewPasswordModel(m.unlockDatabase, m.switchFileSelectionScreen, database)
+	m.passwordModel = NewPasswordModel(m.unlock, m.switchFileSelectionScreen, database)
 	m.screen = PasswordInputScreen
 }
 
 func (m *AppModel) switchFileSelectionScreen() {
-	m.fileSelector = NewFileSelectModel(m.databases, m.unlockDatabase)
+	m.closeDatabases()
+	m.fileSelector = NewFileSelectModel(m.databases, m.config.ScanRoots, m.fileSelectActions())
 	m.screen = FileSelectionScreen
 }
 
-func (m *AppModel) switchMainSearchScreen(database types.Database, entries []types.Entry) (*AppModel, tea.Cmd) {
-	m.searchModel = NewSearchModel(m.clipboard, entries, m.switchEntryDetailsScreen, database.Name)
+func (m *AppModel) fileSelectActions() FileSelectActions {
+	return FileSelectActions{
+		OpenDatabases:  m.openDatabases,
+		ShowInfo:       m.switchDatabaseHeaderScreen,
+		CreateDatabase: m.createDatabase,
+	}
+}
+
+// createDatabase writes a new empty database file.
+func (m *AppModel) createDatabase(database types.Database, credentials keepass.Credentials) tea.Cmd {
+	return CreateDatabase(m.keepassLoader, database, credentials)
+}
+
+// openDatabases closes the unlocked databases and unlocks the given ones one
+// after the other, prompting for a password when the keyring has none.
+func (m *AppModel) openDatabases(databases []types.Database) tea.Cmd {
+	m.closeDatabases()
+	m.pendingUnlock = databases
+
+	return m.unlockNext()
+}
+
+// unlockNext unlocks the next pending database, or opens the search screen
+// once all of them are unlocked.
+func (m *AppModel) unlockNext() tea.Cmd {
+	if len(m.pendingUnlock) > 0 {
+		database := m.pendingUnlock[0]
+		m.pendingUnlock = m.pendingUnlock[1:]
+
+		return m.unlock(database, []byte{})
+	}
+
+	if len(m.unlocked) == 0 {
+		return nil
+	}
+
+	_, cmd := m.switchMainSearchScreen()
+
+	return cmd
+}
+
+// unlock unlocks the database, tagging the result with the current generation
+// of databases.
+func (m *AppModel) unlock(database types.Database, password []byte) tea.Cmd {
+	generation := m.unlockGeneration
+	cmd := m.unlockDatabase.Handle(database, password)
+
+	return func() tea.Msg {
+		return unlockResult{generation: generation, msg: cmd()}
+	}
+}
+
+func (m *AppModel) switchMainSearchScreen() (*AppModel, tea.Cmd) {
+	names := make([]string, len(m.unlocked))
+	for i, unlocked := range m.unlocked {
+		names[i] = unlocked.database.Name
+	}
+
+	m.searchModel = NewSearchModel(m.clipboard, m.config, m.allEntries(), names, SearchActions{
+		ViewDetails:      m.switchEntryDetailsScreen,
+		EditEntry:        m.switchEntryEditScreen,
+		GeneratePassword: m.switchPasswordGeneratorScreen,
+		BrowseGroups:     m.switchGroupTreeScreen,
+		ShowInfo:         m.switchDatabaseInfoScreen,
+		DatabaseSettings: m.switchDatabaseSettingsScreen,
+	})
 	m.screen = MainSearchScreen
-	m.databases.LastUsed = database.Path
+	m.databases.LastUsed = m.unlocked[0].database.Path
 
 	return m, func() tea.Msg {
 		err := m.configMgr.SaveDatabaseList(m.databases)
@@ -237,7 +620,468 @@ func (m *AppModel) switchMainSearchScreen(database types.Database, entries []typ
 	}
 }
 
-func (m *AppModel) switchEntryDetailsScreen(entry types.Entry) {
-	m.detailsModel = NewDetailsModel(m.clipboard, entry)
+func (m *AppModel) switchEntryDetailsScreen(entry types.Entry) tea.Cmd {
+	m.detailsModel = NewDetailsModel(m.clipboard, m.config, entry, DetailsActions{
+		EditEntry:      m.switchEntryEditScreen,
+		DeleteEntry:    m.deleteEntry,
+		ViewHistory:    m.switchEntryHistoryScreen,
+		ReadAttachment: m.readAttachment,
+	})
 	m.screen = EntryDetailsScreen
+
+	return m.detailsModel.Init()
+}
+
+// detailsOpen reports whether the details screen is shown, or is the one to go
+// back to from the current screen.
+func (m *AppModel) detailsOpen() bool {
+	switch m.screen {
+	case EntryDetailsScreen, EntryHistoryScreen:
+		return true
+	case EntryEditScreen:
+		return m.editReturnScreen == EntryDetailsScreen
+	case PasswordGeneratorScreen:
+		return m.generatorReturnScreen == EntryEditScreen && m.editReturnScreen == EntryDetailsScreen
+	default:
+		return false
+	}
+}
+
+// switchEntryEditScreen opens the edit form, for a new entry when isNew is set.
+func (m *AppModel) switchEntryEditScreen(entry types.Entry, isNew bool) {
+	writer := m.entryWriter(entry)
+	if writer == nil {
+		return
+	}
+
+	entry.Database = writer.database
+	m.editReturnScreen = m.screen
+	m.editModel = NewEditModel(m.saveEntry, m.switchPasswordGeneratorScreen, entry, isNew)
+	m.screen = EntryEditScreen
+}
+
+// switchPasswordGeneratorScreen opens the generator, which fills the password
+// field back in when opened from the edit screen.
+func (m *AppModel) switchPasswordGeneratorScreen() {
+	var use func(password string)
+
+	if m.screen == EntryEditScreen {
+		use = func(password string) {
+			m.editModel.SetPassword(password)
+			m.screen = EntryEditScreen
+		}
+	}
+
+	m.generatorReturnScreen = m.screen
+	m.generatorModel = NewGeneratorModel(m.clipboard, m.config, use)
+	m.screen = PasswordGeneratorScreen
+}
+
+// switchGroupTreeScreen opens the group browser over all unlocked databases.
+func (m *AppModel) switchGroupTreeScreen() {
+	var roots []GroupRoot
+
+	for _, unlocked := range m.unlocked {
+		group, err := unlocked.vault.Groups()
+		if err != nil {
+			log.Printf("failed to read groups of %s: %v", unlocked.database.Name, err)
+
+			continue
+		}
+
+		roots = append(roots, GroupRoot{Database: unlocked.database, Group: group})
+	}
+
+	m.groupTreeModel = NewGroupTreeModel(roots, func(database types.Database, group types.Group) {
+		m.searchModel.SetScope(database, group)
+		m.screen = MainSearchScreen
+	})
+	m.screen = GroupTreeScreen
+}
+
+// switchDatabaseInfoScreen describes the unlocked databases.
+func (m *AppModel) switchDatabaseInfoScreen() {
+	databases := make([]DatabaseInfo, 0, len(m.unlocked))
+
+	for _, unlocked := range m.unlocked {
+		info, err := unlocked.vault.Info()
+		if err != nil {
+			log.Printf("failed to describe %s: %v", unlocked.database.Name, err)
+
+			continue
+		}
+
+		databases = append(databases, DatabaseInfo{Database: unlocked.database, Info: info, Locked: false})
+	}
+
+	m.infoReturnScreen = m.screen
+	m.infoModel = NewInfoModel(databases)
+	m.screen = DatabaseInfoScreen
+}
+
+// switchDatabaseHeaderScreen describes how a locked database is encrypted,
+// from its header.
+func (m *AppModel) switchDatabaseHeaderScreen(database types.Database) {
+	header, err := m.keepassLoader.ReadHeader(database.Path)
+	if err != nil {
+		m.fileSelector.status = status.Error(fmt.Sprintf("Failed to read %s: %v", database.Name, err))
+
+		return
+	}
+
+	info := keepass.Info{Header: header} //nolint:exhaustruct // Only the header is known while locked
+	m.infoReturnScreen = m.screen
+	m.infoModel = NewInfoModel([]DatabaseInfo{{Database: database, Info: info, Locked: true}})
+	m.screen = DatabaseInfoScreen
+}
+
+// switchDatabaseSettingsScreen opens the master key and KDF settings of the
+// database, or of the first unlocked one when none is given.
+func (m *AppModel) switchDatabaseSettingsScreen(database types.Database) {
+	for _, unlocked := range m.unlocked {
+		if database.Path != "" && unlocked.database.Path != database.Path {
+			continue
+		}
+
+		info, err := unlocked.vault.Info()
+		if err != nil {
+			m.searchModel.status = status.Error(fmt.Sprintf("Failed to read %s: %v", unlocked.database.Name, err))
+
+			return
+		}
+
+		// The database is looked up again when saving, it may have been reloaded
+		path := unlocked.database.Path
+		changeMasterKey := func(credentials keepass.Credentials, kdf keepass.KDF) tea.Cmd {
+			for _, current := range m.unlocked {
+				if current.database.Path == path {
+					return m.unlockDatabase.ChangeMasterKey(current.database, current.vault, credentials, kdf)
+				}
+			}
+
+			return func() tea.Msg {
+				return MasterKeyChangeFailed{Error: keepass.ErrVaultClosed}
+			}
+		}
+		m.settingsModel = NewSettingsModel(changeMasterKey, BenchmarkKDF, unlocked.database, info.Header)
+		m.screen = DatabaseSettingsScreen
+
+		return
+	}
+}
+
+// switchEntryHistoryScreen lists the previous versions of the entry.
+func (m *AppModel) switchEntryHistoryScreen(entry types.Entry) {
+	m.historyModel = NewHistoryModel(m.clipboard, m.config, entry, m.restoreVersion)
+	m.screen = EntryHistoryScreen
+}
+
+func (m *AppModel) restoreVersion(entry types.Entry, version types.Entry) tea.Cmd {
+	writer := m.entryWriter(entry)
+	if writer == nil {
+		return nil
+	}
+
+	return writer.Restore(entry, version)
+}
+
+// readAttachment reads an attachment from the database the entry comes from.
+func (m *AppModel) readAttachment(entry types.Entry, name string) ([]byte, error) {
+	for _, unlocked := range m.unlocked {
+		if unlocked.database.Path == entry.Database.Path {
+			return unlocked.vault.Attachment(entry.Raw.UUID, name)
+		}
+	}
+
+	return nil, fmt.Errorf("database %s is not unlocked", entry.Database.Name)
+}
+
+// saveEntry saves the entry to the database it comes from, looked up when
+// saving rather than when the edit screen opened.
+func (m *AppModel) saveEntry(entry types.Entry, isNew bool) tea.Cmd {
+	writer := m.entryWriter(entry)
+	if writer == nil {
+		return func() tea.Msg {
+			return EntryWriteFailed{Error: keepass.ErrVaultClosed}
+		}
+	}
+
+	return writer.Save(entry, isNew)
+}
+
+func (m *AppModel) deleteEntry(entry types.Entry) tea.Cmd {
+	writer := m.entryWriter(entry)
+	if writer == nil {
+		return nil
+	}
+
+	return writer.Delete(entry)
+}
+
+// entryWriter returns the writer of the database the entry comes from. Entries
+// without a database, like new ones, go to the first unlocked database.
+func (m *AppModel) entryWriter(entry types.Entry) *EntryWriter {
+	for _, unlocked := range m.unlocked {
+		if entry.Database.Path == "" || unlocked.database.Path == entry.Database.Path {
+			return &EntryWriter{database: unlocked.database, vault: unlocked.vault}
+		}
+	}
+
+	return nil
+}
+
+// allEntries returns the entries of all unlocked databases.
+func (m *AppModel) allEntries() []types.Entry {
+	var entries []types.Entry
+	for _, unlocked := range m.unlocked {
+		entries = append(entries, unlocked.entries...)
+	}
+
+	return entries
+}
+
+// setEntries replaces the entries of one database after it has been written.
+func (m *AppModel) setEntries(database types.Database, entries []types.Entry) {
+	for i := range m.unlocked {
+		if m.unlocked[i].database.Path == database.Path {
+			m.unlocked[i].entries = entries
+		}
+	}
+
+	m.searchModel.SetEntries(m.allEntries())
+}
+
+// setDatabase replaces the unlocked database after its master key changed,
+// along the database its entries are tagged with.
+func (m *AppModel) setDatabase(database types.Database) {
+	for i := range m.unlocked {
+		unlocked := &m.unlocked[i]
+		if unlocked.database.Path != database.Path {
+			continue
+		}
+
+		unlocked.database = database
+		unlocked.entries = tagEntries(unlocked.entries, database)
+	}
+
+	m.searchModel.SetEntries(m.allEntries())
+}
+
+// rememberDatabase records the key file a database was unlocked with and when
+// its password was stored, saved along the database list when the search
+// screen opens.
+func (m *AppModel) rememberDatabase(database types.Database) {
+	for i := range m.databases.Databases {
+		if m.databases.Databases[i].Path == database.Path {
+			m.databases.Databases[i].KeyFile = database.KeyFile
+			m.databases.Databases[i].SecretStoredAt = database.SecretStoredAt
+		}
+	}
+}
+
+func (m *AppModel) scheduleLockCheck() tea.Cmd {
+	return tea.Tick(lockCheckInterval, func(time.Time) tea.Msg {
+		return lockCheckMsg{}
+	})
+}
+
+func (m *AppModel) scheduleReloadCheck() tea.Cmd {
+	return tea.Tick(reloadCheckInterval, func(time.Time) tea.Msg {
+		return reloadCheckMsg{}
+	})
+}
+
+// checkChanges checks, away from the event loop, whether the files of the
+// databases were written by another program.
+func (m *AppModel) checkChanges() tea.Cmd {
+	var cmds []tea.Cmd
+
+	for i := range m.unlocked {
+		unlocked := &m.unlocked[i]
+		if unlocked.reloading {
+			continue
+		}
+
+		unlocked.reloading = true
+		cmds = append(cmds, CheckDatabase(unlocked.database, unlocked.vault))
+	}
+
+	return tea.Batch(cmds...)
+}
+
+// databaseChecked reloads a database whose file changed.
+func (m *AppModel) databaseChecked(msg DatabaseChecked) tea.Cmd {
+	for i := range m.unlocked {
+		unlocked := &m.unlocked[i]
+		if unlocked.database.Path != msg.Database.Path {
+			continue
+		}
+
+		if msg.Error != nil {
+			unlocked.reloading = false
+
+			// The file may be briefly missing while being replaced, logged
+			// once as the check runs every few seconds
+			if !unlocked.checkFailed {
+				unlocked.checkFailed = true
+
+				log.Printf("failed to check %s: %v", unlocked.database.Name, msg.Error)
+			}
+
+			return nil
+		}
+
+		unlocked.checkFailed = false
+
+		if !msg.Changed {
+			unlocked.reloading = false
+
+			return nil
+		}
+
+		return ReloadDatabase(unlocked.database, unlocked.vault)
+	}
+
+	return nil
+}
+
+// replaceDatabase shows the entries of a reloaded database, refreshing the
+// search list in place.
+func (m *AppModel) replaceDatabase(msg DatabaseReloaded) {
+	for i := range m.unlocked {
+		unlocked := &m.unlocked[i]
+		if unlocked.database.Path != msg.Database.Path {
+			continue
+		}
+
+		unlocked.reloading = false
+
+		if !msg.Changed {
+			return
+		}
+
+		if m.searchModel != nil {
+			m.setEntries(unlocked.database, msg.Entries)
+			m.searchModel.status = status.Success(fmt.Sprintf("Reloaded %s after an external change", unlocked.database.Name))
+		} else {
+			unlocked.entries = msg.Entries
+		}
+
+		return
+	}
+}
+
+// checkLocks locks the databases after the configured idle time, and removes
+// the stored passwords older than the session timeout.
+func (m *AppModel) checkLocks() tea.Cmd {
+	now := m.now()
+
+	idleLock := time.Duration(m.config.IdleLockMinutes) * time.Minute
+	if idleLock > 0 && len(m.unlocked) > 0 && now.Sub(m.lastActivity) >= idleLock {
+		m.lock(fmt.Sprintf("Locked after %d minutes of inactivity", m.config.IdleLockMinutes))
+	}
+
+	return m.unlockDatabase.ExpireSecrets(m.databases.Databases, now)
+}
+
+// lock closes the unlocked databases and drops every screen holding their
+// entries, going back to the file selection.
+func (m *AppModel) lock(reason string) {
+	m.switchFileSelectionScreen()
+	m.fileSelector.status = status.Success(reason)
+	m.passwordModel = nil
+	m.searchModel = nil
+	m.detailsModel = nil
+	m.editModel = nil
+	m.groupTreeModel = nil
+	m.historyModel = nil
+	m.settingsModel = nil
+}
+
+// closeDatabases locks all unlocked databases and drops pending ones.
+func (m *AppModel) closeDatabases() {
+	for _, unlocked := range m.unlocked {
+		m.removeSSHKeys(unlocked.database)
+		unlocked.vault.Close()
+	}
+
+	m.unlocked = nil
+	m.pendingUnlock = nil
+	m.unlockGeneration++
+}
+
+// SendClipboardClears has the program run the clipboard clears, since the
+// OSC 52 backend writes them to the terminal the program renders to.
+func (m *AppModel) SendClipboardClears(p *tea.Program) {
+	m.clipboard.RunClearsWith(func(clear func()) {
+		p.Send(clipboardClearMsg{clear: clear})
+	})
+}
+
+// Close removes the SSH keys of the unlocked databases from the agent, or
+// stops the built-in one, before exiting.
+func (m *AppModel) Close() {
+	m.closeDatabases()
+
+	if m.sshAgent != nil {
+		err := m.sshAgent.Close()
+		if err != nil {
+			log.Printf("failed to close SSH agent: %v", err)
+		}
+	}
+}
+
+// addSSHKeys adds the SSH keys of a newly unlocked database to the agent.
+func (m *AppModel) addSSHKeys(database types.Database, vault Vault, entries []types.Entry) tea.Cmd {
+	if m.sshAgent == nil {
+		return nil
+	}
+
+	return AddSSHKeys(m.sshAgent, database, vault, entries)
+}
+
+// showSSHKeysAdded reports the keys added to the agent, and removes them when
+// the database was locked meanwhile.
+func (m *AppModel) showSSHKeysAdded(msg SSHKeysAdded) {
+	if !m.isUnlocked(msg.Database) {
+		m.removeSSHKeys(msg.Database)
+
+		return
+	}
+
+	if m.searchModel == nil || (msg.Count == 0 && msg.Error == nil) {
+		return
+	}
+
+	text := fmt.Sprintf("Added %d SSH keys of %s to the agent", msg.Count, msg.Database.Name)
+	if m.sshAgent.Builtin() {
+		text += ", use it with SSH_AUTH_SOCK=" + m.sshAgent.Socket()
+	}
+
+	if msg.Error != nil {
+		m.searchModel.status = status.Error(fmt.Sprintf("%s, failed: %v", text, msg.Error))
+	} else {
+		m.searchModel.status = status.Success(text)
+	}
+}
+
+func (m *AppModel) removeSSHKeys(database types.Database) {
+	if m.sshAgent == nil {
+		return
+	}
+
+	err := m.sshAgent.Remove(database)
+	if err != nil {
+		log.Printf("failed to remove SSH keys of %s: %v", database.Name, err)
+	}
+}
+
+func (m *AppModel) isUnlocked(database types.Database) bool {
+	for _, unlocked := range m.unlocked {
+		if unlocked.database.Path == database.Path {
+			return true
+		}
+	}
+
+	return false
 }
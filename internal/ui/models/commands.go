@@ -2,18 +2,28 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/martinlehoux/kagapass/internal/agent"
 	"github.com/martinlehoux/kagapass/internal/keepass"
 	"github.com/martinlehoux/kagapass/internal/secretstore"
+	"github.com/martinlehoux/kagapass/internal/sshagent"
 	"github.com/martinlehoux/kagapass/internal/types"
 )
 
 type DatabaseUnlocked struct {
 	Database types.Database
-	Entries  []types.Entry
+	// Vault serves the database, KeePass is the database itself when unlocked
+	// in memory rather than by the agent
+	Vault   Vault
+	KeePass *keepass.KeePass
+	Entries []types.Entry
 }
 
 type DatabaseUnlockFailed struct {
@@ -21,66 +31,478 @@ type DatabaseUnlockFailed struct {
 	Error    error
 }
 
+// ErrNoStoredPassword is returned when a database cannot be unlocked without
+// asking for its password.
+var ErrNoStoredPassword = errors.New("no stored password")
+
+var errSessionExpired = errors.New("session expired")
+
+// Agent keeps databases unlocked across launches, see the agent package.
+type Agent interface {
+	Unlock(database types.Database, credentials keepass.Credentials) error
+	Vault(database types.Database) *agent.Vault
+}
+
 type UnlockDatabase struct {
 	keepassLoader *keepass.Loader
 	secretStore   secretstore.SecretStore
+	// sessionTimeout is how long stored passwords are kept, zero for ever
+	sessionTimeout time.Duration
+	// agent, when running, replaces the secret store
+	agent Agent
+}
+
+// NewUnlockDatabase creates the unlock command. The secret store may be nil, in
+// which case a password is always required.
+func NewUnlockDatabase(
+	keepassLoader *keepass.Loader,
+	secretStore secretstore.SecretStore,
+	sessionTimeout time.Duration,
+) *UnlockDatabase {
+	return &UnlockDatabase{
+		keepassLoader:  keepassLoader,
+		secretStore:    secretStore,
+		sessionTimeout: sessionTimeout,
+		agent:          nil,
+	}
+}
+
+// UseAgent has the agent unlock the databases given a password, and reads and
+// writes the databases it holds through it, instead of decrypting them and
+// using the secret store. The master key of these databases never leaves the
+// agent.
+func (u *UnlockDatabase) UseAgent(agent Agent) {
+	u.agent = agent
 }
 
 func (u *UnlockDatabase) Handle(database types.Database, password []byte) tea.Cmd {
 	return func() tea.Msg {
-		if len(password) > 0 {
-			entries, err := u.unlockDatabaseWithPassword(database, password)
-			if err != nil {
-				return DatabaseUnlockFailed{Database: database, Error: err}
+		if u.agent != nil {
+			msg, ok := u.unlockInAgent(database, password)
+			if ok {
+				return msg
 			}
+		}
 
-			if u.secretStore != nil {
-				err := u.secretStore.Store(database.Path, []byte(password))
-				if err != nil {
-					log.Printf("failed to store password in keyring: %v", err)
-				} else {
-					log.Println("Successfully stored password in keyring:", database.Name)
+		if len(password) > 0 {
+			msg := u.unlockDatabaseWithPassword(database, password)
+			if unlocked, ok := msg.(DatabaseUnlocked); ok && u.secretStore != nil {
+				if storedAt := u.storePassword(database, password); !storedAt.IsZero() {
+					unlocked.Database.SecretStoredAt = storedAt
 				}
+
+				return unlocked
 			}
 
-			return DatabaseUnlocked{Database: database, Entries: entries}
+			return msg
 		}
 
-		if u.secretStore != nil {
-			password, err := u.secretStore.Get(database.Path)
-			if err != nil {
+		password, err := u.storedPassword(database)
+		if err != nil {
+			if database.KeyFile == "" {
 				return DatabaseUnlockFailed{Database: database, Error: err}
 			}
 
-			entries, err := u.unlockDatabaseWithPassword(database, password)
-			if err != nil {
-				return DatabaseUnlockFailed{Database: database, Error: err}
+			// The key file may be enough on its own
+			msg := u.unlockDatabaseWithPassword(database, nil)
+			if failed, ok := msg.(DatabaseUnlockFailed); ok {
+				return DatabaseUnlockFailed{Database: database, Error: fmt.Errorf("%w: %w", err, failed.Error)}
 			}
 
-			return DatabaseUnlocked{Database: database, Entries: entries}
+			return msg
+		}
+
+		// Passwords stored before their time was recorded count from now
+		if database.SecretStoredAt.IsZero() {
+			database.SecretStoredAt = time.Now()
 		}
 
-		return DatabaseUnlockFailed{Database: database, Error: errors.New("unknown")}
+		return u.unlockDatabaseWithPassword(database, password)
 	}
 }
 
-func (u *UnlockDatabase) unlockDatabaseWithPassword(database types.Database, password []byte) ([]types.Entry, error) {
-	keepass, err := u.keepassLoader.Load(database.Path, password)
+// unlockInAgent has the agent unlock the database with the password, or reads
+// it from the agent when it already holds it. It reports false when the
+// database is to be unlocked in memory, because the agent stopped or does not
+// hold it and no password was given.
+func (u *UnlockDatabase) unlockInAgent(database types.Database, password []byte) (tea.Msg, bool) {
+	if len(password) > 0 {
+		err := u.agent.Unlock(database, keepass.Credentials{Password: password, KeyFile: database.KeyFile})
+		if errors.Is(err, agent.ErrNotRunning) {
+			log.Printf("failed to unlock database in agent: %v", err)
+
+			return nil, false
+		} else if err != nil {
+			return DatabaseUnlockFailed{Database: database, Error: kcore.Wrap(err, "failed to open database")}, true
+		}
+
+		log.Println("Successfully unlocked database in agent:", database.Name)
+	}
+
+	vault := u.agent.Vault(database)
+
+	entries, err := vault.Entries()
+	if err != nil {
+		if len(password) > 0 {
+			return DatabaseUnlockFailed{Database: database, Error: kcore.Wrap(err, "failed to get entries")}, true
+		}
+
+		return nil, false
+	}
+
+	return DatabaseUnlocked{Database: database, Vault: vault, KeePass: nil, Entries: tagEntries(entries, database)}, true
+}
+
+func (u *UnlockDatabase) storedPassword(database types.Database) ([]byte, error) {
+	if u.secretStore == nil {
+		return nil, ErrNoStoredPassword
+	}
+
+	if u.secretExpired(database, time.Now()) {
+		err := u.secretStore.Remove(database.Path)
+		if err != nil {
+			log.Printf("failed to remove expired password from keyring: %v", err)
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrNoStoredPassword, errSessionExpired)
+	}
+
+	password, err := u.secretStore.Get(database.Path)
 	if err != nil {
-		return nil, kcore.Wrap(err, "failed to open database")
+		return nil, fmt.Errorf("%w: %w", ErrNoStoredPassword, err)
+	}
+
+	return password, nil
+}
+
+// MasterKeyChanged is sent once a database has been encrypted with new
+// credentials.
+type MasterKeyChanged struct {
+	Database types.Database
+}
+
+type MasterKeyChangeFailed struct {
+	Error error
+}
+
+// ChangeMasterKey re-encrypts the unlocked database with new credentials and
+// KDF settings, then remembers the new password in the secret store. When the
+// agent holds the database, it keeps the new master key and a password stored
+// before is removed instead. A nil password keeps the current one.
+func (u *UnlockDatabase) ChangeMasterKey(
+	database types.Database,
+	vault Vault,
+	credentials keepass.Credentials,
+	kdf keepass.KDF,
+) tea.Cmd {
+	return func() tea.Msg {
+		err := vault.ChangeMasterKey(credentials, kdf)
+		if err != nil {
+			return MasterKeyChangeFailed{Error: kcore.Wrap(err, "failed to change master key")}
+		}
+
+		database.KeyFile = credentials.KeyFile
+
+		if u.secretStore != nil && credentials.Password != nil {
+			// The agent keeps the new master key of the databases it holds
+			if _, inAgent := vault.(*agent.Vault); inAgent {
+				database.SecretStoredAt = u.storePassword(database, nil)
+			} else {
+				database.SecretStoredAt = u.storePassword(database, credentials.Password)
+			}
+		}
+
+		return MasterKeyChanged{Database: database}
 	}
+}
 
-	defer func() {
-		err := keepass.Close()
+// storePassword replaces the stored password of the database, or removes it
+// when the key file alone unlocks the database. It returns when the password
+// was stored, zero when it was not.
+func (u *UnlockDatabase) storePassword(database types.Database, password []byte) time.Time {
+	if len(password) == 0 {
+		err := u.secretStore.Remove(database.Path)
 		if err != nil {
-			log.Printf("failed to close database: %v", err)
+			log.Printf("failed to remove password from keyring: %v", err)
 		}
-	}()
 
-	entries, err := keepass.Entries()
+		return time.Time{}
+	}
+
+	err := u.secretStore.Store(database.Path, password)
+	if err != nil {
+		log.Printf("failed to store password in keyring: %v", err)
+
+		return time.Time{}
+	}
+
+	log.Println("Successfully stored password in keyring:", database.Name)
+
+	return time.Now()
+}
+
+// SecretsExpired is sent once the expired passwords have been removed.
+type SecretsExpired struct {
+	Databases []types.Database
+}
+
+// ExpireSecrets removes the stored passwords older than the session timeout.
+func (u *UnlockDatabase) ExpireSecrets(databases []types.Database, now time.Time) tea.Cmd {
+	if u.secretStore == nil {
+		return nil
+	}
+
+	var expired []types.Database
+
+	for _, database := range databases {
+		if u.secretExpired(database, now) {
+			expired = append(expired, database)
+		}
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	return func() tea.Msg {
+		for _, database := range expired {
+			err := u.secretStore.Remove(database.Path)
+			if err != nil {
+				log.Printf("failed to remove expired password from keyring: %v", err)
+			}
+		}
+
+		return SecretsExpired{Databases: expired}
+	}
+}
+
+// secretExpired reports whether the stored password of the database is older
+// than the session timeout. Without a known storage time it is not, the time
+// is recorded when unlocking with it.
+func (u *UnlockDatabase) secretExpired(database types.Database, now time.Time) bool {
+	if u.sessionTimeout <= 0 {
+		return false
+	}
+
+	return !database.SecretStoredAt.IsZero() && now.Sub(database.SecretStoredAt) >= u.sessionTimeout
+}
+
+// unlockDatabaseWithPassword decrypts the database in memory.
+func (u *UnlockDatabase) unlockDatabaseWithPassword(database types.Database, password []byte) tea.Msg {
+	opened, err := u.keepassLoader.Load(database.Path, keepass.Credentials{Password: password, KeyFile: database.KeyFile})
+	if err != nil {
+		return DatabaseUnlockFailed{Database: database, Error: kcore.Wrap(err, "failed to open database")}
+	}
+
+	entries, err := opened.Entries()
+	if err != nil {
+		closeDatabase(opened)
+
+		return DatabaseUnlockFailed{Database: database, Error: kcore.Wrap(err, "failed to get entries")}
+	}
+
+	return DatabaseUnlocked{Database: database, Vault: keepass.NewVault(opened), KeePass: opened, Entries: tagEntries(entries, database)}
+}
+
+// tagEntries records the database the entries come from, so that entries of
+// several unlocked databases can be searched together and written back.
+func tagEntries(entries []types.Entry, database types.Database) []types.Entry {
+	for i := range entries {
+		entries[i].Database = database
+	}
+
+	return entries
+}
+
+func closeDatabase(keepass *keepass.KeePass) {
+	err := keepass.Close()
 	if err != nil {
-		return nil, kcore.Wrap(err, "failed to get entries")
+		log.Printf("failed to close database: %v", err)
+	}
+}
+
+// DatabaseChecked is sent once a database file has been checked for changes
+// made by another program.
+type DatabaseChecked struct {
+	Database types.Database
+	Changed  bool
+	Error    error
+}
+
+// CheckDatabase reports whether the database file changed since it was read.
+func CheckDatabase(database types.Database, vault Vault) tea.Cmd {
+	return func() tea.Msg {
+		changed, err := vault.Changed()
+
+		return DatabaseChecked{Database: database, Changed: changed, Error: err}
+	}
+}
+
+// DatabaseReloaded is sent once a database has been checked for changes made
+// by another program, and decrypted again when there were.
+type DatabaseReloaded struct {
+	Database types.Database
+	Entries  []types.Entry
+	// Changed is unset when the file was found unchanged
+	Changed bool
+}
+
+type DatabaseReloadFailed struct {
+	Database types.Database
+	Error    error
+}
+
+// ReloadDatabase decrypts the database file again with the credentials it was
+// unlocked with, once the current write is done.
+func ReloadDatabase(database types.Database, vault Vault) tea.Cmd {
+	return func() tea.Msg {
+		entries, changed, err := vault.Reload()
+		if err != nil {
+			return DatabaseReloadFailed{Database: database, Error: err}
+		}
+
+		return DatabaseReloaded{Database: database, Entries: tagEntries(entries, database), Changed: changed}
+	}
+}
+
+// SSHKeysAdded is sent once the SSH keys of an unlocked database have been
+// added to the agent.
+type SSHKeysAdded struct {
+	Database types.Database
+	Count    int
+	// Error reports the keys that could not be read or added
+	Error error
+}
+
+// AddSSHKeys adds the keys of the entries with KeeAgent settings to the
+// agent. Encrypted keys are decrypted with the entry password, which may take
+// a while.
+func AddSSHKeys(sshAgent *sshagent.Agent, database types.Database, vault Vault, entries []types.Entry) tea.Cmd {
+	return func() tea.Msg {
+		keys, readErr := sshagent.Keys(entries, func(entry types.Entry, name string) ([]byte, error) {
+			return vault.Attachment(entry.Raw.UUID, name)
+		})
+		count, addErr := sshAgent.Add(database, keys)
+
+		return SSHKeysAdded{Database: database, Count: count, Error: errors.Join(readErr, addErr)}
+	}
+}
+
+// DatabasesScanned is sent once the scan roots have been searched for
+// databases.
+type DatabasesScanned struct {
+	Paths []string
+	// Error reports the roots that could not be searched
+	Error error
+}
+
+// ScanDatabases searches the roots for databases, which may take a while in
+// large directories.
+func ScanDatabases(roots []string) tea.Cmd {
+	return func() tea.Msg {
+		paths, err := keepass.FindDatabases(roots)
+
+		return DatabasesScanned{Paths: paths, Error: err}
+	}
+}
+
+// KDFBenchmarked is sent once the KDF settings have been tuned for an unlock
+// time.
+type KDFBenchmarked struct {
+	KDF   keepass.KDF
+	Error error
+}
+
+// BenchmarkKDF tunes the KDF settings to take about target to unlock, which
+// takes a while with large Argon2 memory.
+func BenchmarkKDF(kdf keepass.KDF, target time.Duration) tea.Cmd {
+	return func() tea.Msg {
+		tuned, err := keepass.Benchmark(kdf, target)
+
+		return KDFBenchmarked{KDF: tuned, Error: err}
 	}
+}
 
-	return entries, nil
+// DatabaseCreated is sent once a new database file has been written.
+type DatabaseCreated struct {
+	Database types.Database
+}
+
+type DatabaseCreateFailed struct {
+	Error error
+}
+
+// CreateDatabase writes a new empty database, named after its file, with the
+// default Argon2d settings. Argon2id, the default of KeePassXC, is not
+// supported by gokeepasslib.
+func CreateDatabase(loader *keepass.Loader, database types.Database, credentials keepass.Credentials) tea.Cmd {
+	return func() tea.Msg {
+		name := strings.TrimSuffix(database.Name, filepath.Ext(database.Name))
+
+		err := loader.Create(database.Path, name, credentials, keepass.DefaultKDF(keepass.Argon2d))
+		if err != nil {
+			return DatabaseCreateFailed{Error: kcore.Wrap(err, "failed to create database")}
+		}
+
+		return DatabaseCreated{Database: database}
+	}
+}
+
+type EntrySaved struct {
+	Entry   types.Entry
+	Entries []types.Entry
+}
+
+type EntryDeleted struct {
+	Database types.Database
+	Entries  []types.Entry
+}
+
+type EntryWriteFailed struct {
+	Error error
+}
+
+// EntryWriter applies entry changes to an unlocked database and saves it.
+type EntryWriter struct {
+	database types.Database
+	vault    Vault
+}
+
+// Save creates the entry when it has never been saved, or updates it otherwise.
+func (e *EntryWriter) Save(entry types.Entry, isNew bool) tea.Cmd {
+	return func() tea.Msg {
+		saved, entries, err := e.vault.SaveEntry(entry, isNew)
+		if err != nil {
+			return EntryWriteFailed{Error: err}
+		}
+
+		saved.Database = e.database
+
+		return EntrySaved{Entry: saved, Entries: tagEntries(entries, e.database)}
+	}
+}
+
+// Restore makes a previous version of the entry its current one.
+func (e *EntryWriter) Restore(entry types.Entry, version types.Entry) tea.Cmd {
+	return func() tea.Msg {
+		restored, entries, err := e.vault.RestoreVersion(entry.Raw.UUID, version.Modified)
+		if err != nil {
+			return EntryWriteFailed{Error: err}
+		}
+
+		restored.Database = e.database
+
+		return EntrySaved{Entry: restored, Entries: tagEntries(entries, e.database)}
+	}
+}
+
+func (e *EntryWriter) Delete(entry types.Entry) tea.Cmd {
+	return func() tea.Msg {
+		entries, err := e.vault.DeleteEntry(entry.Raw.UUID)
+		if err != nil {
+			return EntryWriteFailed{Error: err}
+		}
+
+		return EntryDeleted{Database: e.database, Entries: tagEntries(entries, e.database)}
+	}
 }
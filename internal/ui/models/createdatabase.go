@@ -0,0 +1,174 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/types"
+)
+
+const (
+	createNameField = iota
+	createDirField
+	createPasswordField
+	createConfirmField
+	createKeyFileField
+	createFieldCount
+)
+
+var createFieldLabels = [createFieldCount]string{"Name", "Directory", "Password", "Confirm", "Key file"}
+
+// CreateDatabaseModel is the form of a new database: its name, directory and
+// master key.
+type CreateDatabaseModel struct {
+	inputs [createFieldCount]textinput.Model
+	focus  int
+}
+
+// NewCreateDatabaseModel creates the form of a new database in dir.
+func NewCreateDatabaseModel(dir string) *CreateDatabaseModel {
+	m := &CreateDatabaseModel{
+		inputs: [createFieldCount]textinput.Model{},
+		focus:  createNameField,
+	}
+
+	for i := range m.inputs {
+		m.inputs[i] = textinput.New()
+		m.inputs[i].Prompt = ""
+		m.inputs[i].Width = 40
+	}
+
+	for _, field := range []int{createPasswordField, createConfirmField} {
+		m.inputs[field].EchoMode = textinput.EchoPassword
+		m.inputs[field].EchoCharacter = '•'
+	}
+
+	m.inputs[createNameField].Placeholder = "Personal"
+	m.inputs[createDirField].SetValue(dir)
+	m.inputs[createKeyFileField].Placeholder = "(none)"
+
+	m.focusField(createNameField)
+
+	return m
+}
+
+// Update implements tea.Model.
+func (m *CreateDatabaseModel) Update(msg tea.Msg) (*CreateDatabaseModel, tea.Cmd) {
+	var cmd tea.Cmd
+
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "tab", "down":
+			m.focusField((m.focus + 1) % createFieldCount)
+
+			return m, nil
+		case "shift+tab", "up":
+			m.focusField((m.focus + createFieldCount - 1) % createFieldCount)
+
+			return m, nil
+		case "ctrl+p":
+			for _, field := range []int{createPasswordField, createConfirmField} {
+				if m.inputs[field].EchoMode == textinput.EchoPassword {
+					m.inputs[field].EchoMode = textinput.EchoNormal
+				} else {
+					m.inputs[field].EchoMode = textinput.EchoPassword
+				}
+			}
+
+			return m, nil
+		}
+	}
+
+	m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+
+	return m, cmd
+}
+
+// View implements tea.Model.
+func (m *CreateDatabaseModel) View() string {
+	var b strings.Builder
+
+	b.WriteString("Create a new KeePass database:\n\n")
+
+	labelStyle := lipgloss.NewStyle().Width(11)
+	focusedLabelStyle := labelStyle.Foreground(lipgloss.Color("#7D56F4"))
+
+	for i, input := range m.inputs {
+		label := labelStyle.Render(createFieldLabels[i] + ":")
+		if m.focus == i {
+			label = focusedLabelStyle.Render(createFieldLabels[i] + ":")
+		}
+
+		b.WriteString(label + input.View() + "\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#626262")).
+		Render("Keys are derived with "+keepass.DefaultKDF(keepass.Argon2d).String()+", tune them with Ctrl+S once unlocked.") + "\n\n")
+
+	// Footer
+	footer := "[Tab] Next field  [Ctrl+P] Toggle Pass  [Enter] Create  [Esc] Cancel"
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
+
+func (m *CreateDatabaseModel) focusField(field int) {
+	for i := range m.inputs {
+		m.inputs[i].Blur()
+	}
+
+	m.focus = field
+	m.inputs[field].Focus()
+}
+
+// request returns the database to create, named after its file, with the key
+// file it is protected by, and its credentials, or why the form is invalid.
+func (m *CreateDatabaseModel) request() (types.Database, keepass.Credentials, error) {
+	credentials := keepass.Credentials{Password: nil, KeyFile: ""}
+
+	name := strings.TrimSpace(m.inputs[createNameField].Value())
+	if name == "" || strings.ContainsRune(name, '/') || strings.HasPrefix(name, ".") {
+		return types.Database{}, credentials, errors.New("name must be a file name") //nolint:exhaustruct // No database
+	}
+
+	if !keepass.IsDatabaseName(name) {
+		name += ".kdbx"
+	}
+
+	dir, err := keepass.CanonicalPath(m.inputs[createDirField].Value())
+	if err != nil {
+		return types.Database{}, credentials, fmt.Errorf("invalid directory: %w", err) //nolint:exhaustruct // No database
+	}
+
+	password := m.inputs[createPasswordField].Value()
+	if password != m.inputs[createConfirmField].Value() {
+		return types.Database{}, credentials, errors.New("passwords do not match") //nolint:exhaustruct // No database
+	}
+
+	if keyFile := strings.TrimSpace(m.inputs[createKeyFileField].Value()); keyFile != "" {
+		credentials.KeyFile, err = keepass.CanonicalPath(keyFile)
+		if err != nil {
+			return types.Database{}, credentials, fmt.Errorf("invalid key file: %w", err) //nolint:exhaustruct // No database
+		}
+	}
+
+	if password == "" && credentials.KeyFile == "" {
+		return types.Database{}, credentials, keepass.ErrNoMasterKey //nolint:exhaustruct // No database
+	}
+
+	credentials.Password = []byte(password)
+
+	database := newDatabase(filepath.Join(dir, name))
+	database.KeyFile = credentials.KeyFile
+
+	return database, credentials, nil
+}
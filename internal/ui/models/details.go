@@ -1,42 +1,117 @@
 package models
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/martinlehoux/kagapass/internal/clipboard"
+	"github.com/martinlehoux/kagapass/internal/keepass"
 	"github.com/martinlehoux/kagapass/internal/types"
 	"github.com/martinlehoux/kagapass/internal/ui/status"
 	"github.com/martinlehoux/kagapass/internal/ui/style"
 )
 
+// previewLines is how many lines of a text attachment are previewed.
+const previewLines = 20
+
+// DetailsActions are what the entry details screen asks the application to do.
+type DetailsActions struct {
+	EditEntry      func(entry types.Entry, isNew bool)
+	DeleteEntry    func(entry types.Entry) tea.Cmd
+	ViewHistory    func(entry types.Entry)
+	ReadAttachment func(entry types.Entry, name string) ([]byte, error)
+}
+
 // DetailsModel handles the entry details screen.
 type DetailsModel struct {
+	actions DetailsActions
+
 	entry        types.Entry
 	scroll       int
 	clipboard    *clipboard.Clipboard
+	config       types.Config
 	status       status.Status
 	showPassword bool
+	// Index of the selected custom field, then attachment
+	fieldCursor int
+	// Whether the next delete key press confirms the deletion
+	confirmDelete bool
+	// preview holds the first lines of the previewed attachment
+	previewName string
+	preview     []string
+	// savePath is focused while choosing where to save an attachment
+	savePath textinput.Model
 }
 
 // NewDetailsModel creates a new details model.
-func NewDetailsModel(clipboard *clipboard.Clipboard, entry types.Entry) *DetailsModel {
+func NewDetailsModel(clipboard *clipboard.Clipboard, config types.Config, entry types.Entry, actions DetailsActions) *DetailsModel {
 	return &DetailsModel{
-		entry:        entry,
-		scroll:       0,
-		clipboard:    clipboard,
-		status:       status.Status{},
-		showPassword: false,
+		entry:         entry,
+		scroll:        0,
+		clipboard:     clipboard,
+		config:        config,
+		status:        status.Status{},
+		showPassword:  false,
+		fieldCursor:   0,
+		confirmDelete: false,
+		previewName:   "",
+		preview:       nil,
+		savePath:      textinput.New(),
+		actions:       actions,
+	}
+}
+
+// totpTickMsg refreshes the TOTP countdown of the details model that scheduled it.
+type totpTickMsg struct {
+	details *DetailsModel
+}
+
+// Init starts the TOTP countdown when the entry has one.
+func (m *DetailsModel) Init() tea.Cmd {
+	if m.entry.TOTP == nil {
+		return nil
 	}
+
+	return m.tick()
+}
+
+func (m *DetailsModel) tick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return totpTickMsg{details: m}
+	})
 }
 
 // Update implements tea.Model.
 func (m *DetailsModel) Update(msg tea.Msg) (*DetailsModel, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.savePath.Focused() {
+			if msg.String() == "enter" {
+				m.saveAttachment()
+
+				return m, nil
+			}
+
+			var cmd tea.Cmd
+
+			m.savePath, cmd = m.savePath.Update(msg)
+
+			return m, cmd
+		}
+
+		if msg.String() != "ctrl+d" {
+			m.confirmDelete = false
+		}
+
 		switch msg.String() {
 		case "up", "k":
 			if m.scroll > 0 {
@@ -46,27 +121,9 @@ func (m *DetailsModel) Update(msg tea.Msg) (*DetailsModel, tea.Cmd) {
 			// TODO: Implement proper scrolling based on content height
 			m.scroll++
 		case "ctrl+b":
-			if m.clipboard != nil && m.entry.Username != "" {
-				err := m.clipboard.Copy(m.entry.Username, 30*time.Second)
-				if err != nil {
-					m.status = status.Error("Failed to copy username")
-				} else {
-					m.status = status.Success("Username copied to clipboard (will clear in 30s)")
-				}
-			} else {
-				m.status = status.Error("No username to copy")
-			}
+			m.status = copyToClipboard(m.clipboard, m.config, "username", m.entry.Username)
 		case "ctrl+c":
-			if m.clipboard != nil && m.entry.Password != "" {
-				err := m.clipboard.Copy(m.entry.Password, 30*time.Second)
-				if err != nil {
-					m.status = status.Error("Failed to copy password")
-				} else {
-					m.status = status.Success("Password copied to clipboard (will clear in 30s)")
-				}
-			} else {
-				m.status = status.Error("No password to copy")
-			}
+			m.status = copyToClipboard(m.clipboard, m.config, "password", m.entry.Password)
 		case "ctrl+p":
 			m.showPassword = !m.showPassword
 			if m.showPassword {
@@ -74,6 +131,58 @@ func (m *DetailsModel) Update(msg tea.Msg) (*DetailsModel, tea.Cmd) {
 			} else {
 				m.status = status.Success("Password hidden")
 			}
+		case "tab":
+			if m.fieldCursor < len(m.entry.Fields)+len(m.entry.Attachments)-1 {
+				m.fieldCursor++
+			}
+		case "shift+tab":
+			if m.fieldCursor > 0 {
+				m.fieldCursor--
+			}
+		case "enter":
+			if m.fieldCursor < len(m.entry.Fields) {
+				field := m.entry.Fields[m.fieldCursor]
+				m.status = copyToClipboard(m.clipboard, m.config, printable(field.Key), field.Value)
+			} else if attachment, ok := m.selectedAttachment(); ok {
+				m.togglePreview(attachment)
+			}
+		case "ctrl+s":
+			if attachment, ok := m.selectedAttachment(); ok {
+				m.savePath.SetValue(attachment.Name)
+				m.savePath.CursorEnd()
+				m.savePath.Focus()
+				m.status = status.Status{}
+			}
+		case "ctrl+t":
+			m.status = copyTOTPCode(m.clipboard, m.entry)
+		case "ctrl+e":
+			m.actions.EditEntry(m.entry, false)
+		case "ctrl+o":
+			if len(m.entry.Raw.Histories) == 0 || len(m.entry.Raw.Histories[0].Entries) == 0 {
+				m.status = status.Error("No previous versions")
+
+				return m, nil
+			}
+
+			m.actions.ViewHistory(m.entry)
+		case "ctrl+d":
+			if !m.confirmDelete {
+				m.confirmDelete = true
+				m.status = status.Error("Press Ctrl+D again to delete this entry")
+
+				return m, nil
+			}
+
+			m.confirmDelete = false
+
+			return m, m.actions.DeleteEntry(m.entry)
+		}
+	case EntryWriteFailed:
+		m.status = status.Error(msg.Error.Error())
+	case totpTickMsg:
+		// Ticks from a previous details screen are dropped to end their loop
+		if msg.details == m {
+			return m, m.tick()
 		}
 	}
 
@@ -89,13 +198,13 @@ func (m *DetailsModel) View() string {
 	b.WriteString(m.status.Render() + "\n\n")
 
 	// Entry details
-	b.WriteString(fmt.Sprintf("Title:    %s\n", m.entry.Title))
-	b.WriteString(fmt.Sprintf("Username: %s\n", m.entry.Username))
+	b.WriteString(fmt.Sprintf("Title:    %s\n", printable(m.entry.Title)))
+	b.WriteString(fmt.Sprintf("Username: %s\n", printable(m.entry.Username)))
 
 	// Show password based on visibility toggle
 	var passwordDisplay string
 	if m.showPassword {
-		passwordDisplay = m.entry.Password
+		passwordDisplay = printable(m.entry.Password)
 	} else {
 		passwordDisplay = strings.Repeat("*", 12)
 	}
@@ -103,21 +212,98 @@ func (m *DetailsModel) View() string {
 	b.WriteString(fmt.Sprintf("Password: %s\n", passwordDisplay))
 
 	if m.entry.URL != "" {
-		b.WriteString(fmt.Sprintf("URL:      %s\n", m.entry.URL))
+		b.WriteString(fmt.Sprintf("URL:      %s\n", printable(m.entry.URL)))
 	}
 
 	if m.entry.Group != "" {
-		b.WriteString(fmt.Sprintf("Group:    %s\n", m.entry.Group))
+		b.WriteString(fmt.Sprintf("Group:    %s\n", printable(m.entry.Group)))
+	}
+
+	if len(m.entry.Tags) > 0 {
+		b.WriteString(fmt.Sprintf("Tags:     %s\n", printable(strings.Join(m.entry.Tags, ", "))))
+	}
+
+	if m.entry.TOTP != nil {
+		now := time.Now()
+		b.WriteString(fmt.Sprintf("TOTP:     %s (%ds)\n", m.entry.TOTP.Code(now), int(m.entry.TOTP.Remaining(now).Seconds())))
 	}
 
 	b.WriteString("\n")
 
+	// Custom fields section
+	if len(m.entry.Fields) > 0 {
+		b.WriteString("Fields:\n")
+
+		for i, field := range m.entry.Fields {
+			cursor := " "
+			if m.fieldCursor == i {
+				cursor = "▶"
+			}
+
+			value := field.Value
+			if field.Protected && !m.showPassword {
+				value = strings.Repeat("*", 12)
+			}
+
+			// Continuation lines of multi-line values are indented under the key
+			key := printable(field.Key)
+			lines := printableLines(value)
+			line := fmt.Sprintf("  %s %s: %s", cursor, key, lines[0])
+
+			if m.fieldCursor == i {
+				line = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Render(line)
+			}
+
+			b.WriteString(line + "\n")
+
+			for _, continuation := range lines[1:] {
+				b.WriteString(strings.Repeat(" ", len(key)+6) + continuation + "\n")
+			}
+		}
+
+		b.WriteString("\n")
+	}
+
+	// Attachments section
+	if len(m.entry.Attachments) > 0 {
+		b.WriteString("Attachments:\n")
+
+		for i, attachment := range m.entry.Attachments {
+			selected := m.fieldCursor == len(m.entry.Fields)+i
+
+			cursor := " "
+			if selected {
+				cursor = "▶"
+			}
+
+			line := fmt.Sprintf("  %s %s (%s)", cursor, printable(attachment.Name), formatSize(attachment.Size))
+			if selected {
+				line = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Render(line)
+			}
+
+			b.WriteString(line + "\n")
+
+			if attachment.Name == m.previewName {
+				for _, previewLine := range m.preview {
+					b.WriteString("      " + previewLine + "\n")
+				}
+			}
+		}
+
+		if m.savePath.Focused() {
+			if attachment, ok := m.selectedAttachment(); ok {
+				b.WriteString(fmt.Sprintf("\nSave %s to: %s\n", printable(attachment.Name), m.savePath.View()))
+			}
+		}
+
+		b.WriteString("\n")
+	}
+
 	// Notes section
 	if m.entry.Notes != "" {
 		b.WriteString("Notes:\n")
 		// TODO: Handle scrolling for long notes
-		noteLines := strings.Split(m.entry.Notes, "\n")
-		for _, line := range noteLines {
+		for _, line := range printableLines(m.entry.Notes) {
 			b.WriteString(line + "\n")
 		}
 
@@ -136,10 +322,204 @@ func (m *DetailsModel) View() string {
 	b.WriteString("\n")
 
 	// Footer
-	footer := "[Ctrl+B] Copy User  [Ctrl+C] Copy Pass  [Ctrl+P] Toggle Pass  [Esc] Back"
+	footer := "[Ctrl+B] Copy User  [Ctrl+C] Copy Pass  [Ctrl+P] Toggle Pass  [Ctrl+T] Copy TOTP  [Ctrl+E] Edit  [Ctrl+O] History  [Ctrl+D] Delete  [Esc] Back"
+	if len(m.entry.Attachments) > 0 {
+		footer = "[Tab] Select  [Enter] Copy Field/Preview  [Ctrl+S] Save Attachment  " + footer
+	} else if len(m.entry.Fields) > 0 {
+		footer = "[Tab] Select Field  [Enter] Copy Field  " + footer
+	}
+
+	if m.savePath.Focused() {
+		footer = "[Enter] Save  [Esc] Cancel"
+	}
 	b.WriteString(lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#626262")).
 		Render(footer))
 
 	return b.String()
 }
+
+// cancelSave stops choosing where to save an attachment, reporting whether it
+// was being chosen.
+func (m *DetailsModel) cancelSave() bool {
+	if !m.savePath.Focused() {
+		return false
+	}
+
+	m.savePath.Blur()
+	m.savePath.Reset()
+
+	return true
+}
+
+func (m *DetailsModel) selectedAttachment() (types.Attachment, bool) {
+	index := m.fieldCursor - len(m.entry.Fields)
+	if index < 0 || index >= len(m.entry.Attachments) {
+		return types.Attachment{}, false //nolint:exhaustruct // No attachment
+	}
+
+	return m.entry.Attachments[index], true
+}
+
+// togglePreview shows the first lines of a text attachment, or hides them.
+func (m *DetailsModel) togglePreview(attachment types.Attachment) {
+	if m.previewName == attachment.Name {
+		m.previewName = ""
+		m.preview = nil
+
+		return
+	}
+
+	content, err := m.actions.ReadAttachment(m.entry, attachment.Name)
+	if err != nil {
+		m.status = status.Error("Failed to read " + printable(attachment.Name) + ": " + err.Error())
+
+		return
+	}
+
+	if !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		m.status = status.Error(printable(attachment.Name) + " is not a text file, save it to open it")
+
+		return
+	}
+
+	lines := printableLines(strings.TrimRight(string(content), "\n"))
+
+	if len(lines) > previewLines {
+		lines = append(lines[:previewLines], fmt.Sprintf("… %d more lines", len(lines)-previewLines))
+	}
+
+	m.previewName = attachment.Name
+	m.preview = lines
+	m.status = status.Status{}
+}
+
+// printable replaces the control characters of a line read from a database
+// but tabs, so that escape sequences it contains are shown instead of run by
+// the terminal.
+func printable(line string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\t' && unicode.IsControl(r) {
+			return utf8.RuneError
+		}
+
+		return r
+	}, line)
+}
+
+// printableLines splits the text into printable lines.
+func printableLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = printable(strings.TrimSuffix(line, "\r"))
+	}
+
+	return lines
+}
+
+// saveAttachment writes the selected attachment to the chosen path, readable
+// by the user only. Existing files are not overwritten.
+func (m *DetailsModel) saveAttachment() {
+	attachment, ok := m.selectedAttachment()
+	if !ok {
+		return
+	}
+
+	if strings.TrimSpace(m.savePath.Value()) == "" {
+		m.status = status.Error("No path to save " + printable(attachment.Name) + " to")
+
+		return
+	}
+
+	path, err := keepass.ExpandPath(m.savePath.Value())
+	if err == nil {
+		var content []byte
+
+		content, err = m.actions.ReadAttachment(m.entry, attachment.Name)
+		if err == nil {
+			err = writeNewFile(path, content)
+		}
+	}
+
+	if err != nil {
+		m.status = status.Error("Failed to save " + printable(attachment.Name) + ": " + err.Error())
+
+		return
+	}
+
+	m.cancelSave()
+	m.status = status.Success(fmt.Sprintf("Saved %s to %s", printable(attachment.Name), path))
+}
+
+// writeNewFile creates the file with mode 0600, failing when it exists.
+func writeNewFile(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(content)
+
+	return errors.Join(err, file.Close())
+}
+
+// formatSize formats a size in bytes with a binary unit.
+func formatSize(size int) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value := float64(size) / unit
+	for _, suffix := range []string{"KiB", "MiB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+
+		value /= unit
+	}
+
+	return fmt.Sprintf("%.1f GiB", value)
+}
+
+// copyToClipboard copies a value, cleared after the configured delay, and
+// reports it with the name of the value.
+func copyToClipboard(clipboard *clipboard.Clipboard, config types.Config, name string, value string) status.Status {
+	if clipboard == nil || value == "" {
+		return status.Error("No " + name + " to copy")
+	}
+
+	clearAfter := time.Duration(config.ClipboardClearSeconds) * time.Second
+
+	err := clipboard.Copy(value, clearAfter)
+	if err != nil {
+		return status.Error("Failed to copy " + name)
+	}
+
+	first, size := utf8.DecodeRuneInString(name)
+	name = string(unicode.ToUpper(first)) + name[size:]
+
+	if clearAfter <= 0 {
+		return status.Success(name + " copied to clipboard")
+	}
+
+	return status.Success(fmt.Sprintf("%s copied to clipboard (will clear in %ds)", name, config.ClipboardClearSeconds))
+}
+
+// copyTOTPCode copies the current TOTP code of the entry and reports the outcome.
+func copyTOTPCode(clipboard *clipboard.Clipboard, entry types.Entry) status.Status {
+	if clipboard == nil || entry.TOTP == nil {
+		return status.Error("No TOTP to copy")
+	}
+
+	now := time.Now()
+
+	// Keep the code in the clipboard no longer than it is valid
+	err := clipboard.Copy(entry.TOTP.Code(now), entry.TOTP.Remaining(now))
+	if err != nil {
+		return status.Error("Failed to copy TOTP code")
+	}
+
+	return status.Success(fmt.Sprintf("TOTP code copied to clipboard (valid for %ds)", int(entry.TOTP.Remaining(now).Seconds())))
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/status"
+	"github.com/martinlehoux/kagapass/internal/ui/style"
+)
+
+const (
+	editTitleField = iota
+	editUsernameField
+	editPasswordField
+	editURLField
+	editGroupField
+	editNotesField
+	editFieldCount
+)
+
+var editFieldLabels = [editNotesField]string{"Title", "Username", "Password", "URL", "Group"}
+
+// EditModel handles the entry creation and edition screen.
+type EditModel struct {
+	// Commands
+	save func(entry types.Entry, isNew bool) tea.Cmd
+
+	// Actions
+	generatePassword func()
+
+	entry  types.Entry
+	isNew  bool
+	inputs [editNotesField]textinput.Model
+	notes  textarea.Model
+	focus  int
+	status status.Status
+}
+
+// NewEditModel creates an edit model for the given entry, or for a new entry
+// in the given group when isNew is set.
+func NewEditModel(
+	save func(entry types.Entry, isNew bool) tea.Cmd,
+	generatePassword func(),
+	entry types.Entry,
+	isNew bool,
+) *EditModel {
+	m := &EditModel{
+		save:             save,
+		generatePassword: generatePassword,
+		entry:            entry,
+		isNew:            isNew,
+		inputs:           [editNotesField]textinput.Model{},
+		notes:            textarea.New(),
+		focus:            editTitleField,
+		status:           status.Status{},
+	}
+
+	values := [editNotesField]string{entry.Title, entry.Username, entry.Password, entry.URL, entry.Group}
+	for i := range m.inputs {
+		m.inputs[i] = textinput.New()
+		m.inputs[i].Prompt = ""
+		m.inputs[i].Width = 40
+		m.inputs[i].SetValue(values[i])
+	}
+
+	m.inputs[editPasswordField].EchoMode = textinput.EchoPassword
+	m.inputs[editPasswordField].EchoCharacter = '•'
+
+	m.notes.ShowLineNumbers = false
+	m.notes.SetWidth(60)
+	m.notes.SetHeight(5)
+	m.notes.SetValue(entry.Notes)
+
+	m.focusField(editTitleField)
+
+	return m
+}
+
+// Update implements tea.Model.
+func (m *EditModel) Update(msg tea.Msg) (*EditModel, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		key := msg.String()
+		// Arrows move between fields, except in notes where they move between lines
+		if m.focus != editNotesField {
+			switch key {
+			case "down":
+				key = "tab"
+			case "up":
+				key = "shift+tab"
+			}
+		}
+
+		switch key {
+		case "tab":
+			m.focusField((m.focus + 1) % editFieldCount)
+
+			return m, nil
+		case "shift+tab":
+			m.focusField((m.focus + editFieldCount - 1) % editFieldCount)
+
+			return m, nil
+		case "ctrl+s":
+			return m.submit()
+		case "ctrl+p":
+			if m.inputs[editPasswordField].EchoMode == textinput.EchoPassword {
+				m.inputs[editPasswordField].EchoMode = textinput.EchoNormal
+			} else {
+				m.inputs[editPasswordField].EchoMode = textinput.EchoPassword
+			}
+
+			return m, nil
+		case "ctrl+g":
+			m.generatePassword()
+
+			return m, nil
+		}
+	case EntryWriteFailed:
+		m.status = status.Error(msg.Error.Error())
+
+		return m, nil
+	}
+
+	if m.focus == editNotesField {
+		m.notes, cmd = m.notes.Update(msg)
+	} else {
+		m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+	}
+
+	return m, cmd
+}
+
+// View implements tea.Model.
+func (m *EditModel) View() string {
+	var b strings.Builder
+
+	title := "Edit Entry"
+	if m.isNew {
+		title = "New Entry"
+	}
+
+	b.WriteString(style.ViewTitle.Render(title) + "\n\n")
+
+	b.WriteString(m.status.Render() + "\n\n")
+
+	if m.entry.Database.Name != "" {
+		b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#626262")).Render("Database: "+m.entry.Database.Name) + "\n\n")
+	}
+
+	labelStyle := lipgloss.NewStyle().Width(10)
+	focusedLabelStyle := labelStyle.Foreground(lipgloss.Color("#7D56F4"))
+
+	for i, input := range m.inputs {
+		label := labelStyle.Render(editFieldLabels[i] + ":")
+		if m.focus == i {
+			label = focusedLabelStyle.Render(editFieldLabels[i] + ":")
+		}
+
+		b.WriteString(label + input.View() + "\n")
+	}
+
+	b.WriteString("\n")
+
+	notesLabel := "Notes:"
+	if m.focus == editNotesField {
+		notesLabel = focusedLabelStyle.Render(notesLabel)
+	}
+
+	b.WriteString(notesLabel + "\n")
+	b.WriteString(m.notes.View() + "\n\n")
+
+	// Footer
+	footer := "[Tab] Next field  [Ctrl+P] Toggle Pass  [Ctrl+G] Generate  [Ctrl+S] Save  [Esc] Cancel"
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
+
+// SetPassword replaces the password field, e.g. with a generated one.
+func (m *EditModel) SetPassword(password string) {
+	m.inputs[editPasswordField].SetValue(password)
+	m.focusField(editPasswordField)
+	m.status = status.Success("Generated password set")
+}
+
+func (m *EditModel) focusField(field int) {
+	for i := range m.inputs {
+		m.inputs[i].Blur()
+	}
+
+	m.notes.Blur()
+
+	m.focus = field
+	if field == editNotesField {
+		m.notes.Focus()
+	} else {
+		m.inputs[field].Focus()
+	}
+}
+
+// submit validates the form and hands the updated entry over to be saved.
+func (m *EditModel) submit() (*EditModel, tea.Cmd) {
+	entry := m.entry
+	entry.Title = strings.TrimSpace(m.inputs[editTitleField].Value())
+	entry.Username = m.inputs[editUsernameField].Value()
+	entry.Password = m.inputs[editPasswordField].Value()
+	entry.URL = strings.TrimSpace(m.inputs[editURLField].Value())
+	entry.Group = strings.Trim(strings.TrimSpace(m.inputs[editGroupField].Value()), "/")
+	entry.Notes = m.notes.Value()
+
+	if entry.Title == "" {
+		m.status = status.Error("Title cannot be empty")
+
+		return m, nil
+	}
+
+	m.status = status.Success("Saving...")
+
+	return m, m.save(entry, m.isNew)
+}
@@ -0,0 +1,189 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/keepass"
+)
+
+// filePicked is sent when a database file is chosen in the file picker.
+type filePicked struct {
+	path string
+}
+
+// pickerEntry is a directory or database listed by the file picker.
+type pickerEntry struct {
+	name  string
+	isDir bool
+}
+
+// FilePickerModel browses directories to choose a database file, listing
+// only directories and .kdbx files.
+type FilePickerModel struct {
+	dir     string
+	entries []pickerEntry
+	cursor  int
+	// err is why the directory could not be listed
+	err        error
+	showHidden bool
+}
+
+// NewFilePickerModel creates a file picker listing dir.
+func NewFilePickerModel(dir string) *FilePickerModel {
+	m := &FilePickerModel{
+		dir:        dir,
+		entries:    nil,
+		cursor:     0,
+		err:        nil,
+		showHidden: false,
+	}
+	m.list("")
+
+	return m
+}
+
+// Update implements tea.Model.
+func (m *FilePickerModel) Update(msg tea.Msg) (*FilePickerModel, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.entries)-1 {
+				m.cursor++
+			}
+		case "enter", "right", "l":
+			if m.cursor >= len(m.entries) {
+				return m, nil
+			}
+
+			entry := m.entries[m.cursor]
+			path := filepath.Join(m.dir, entry.name)
+
+			if entry.isDir {
+				m.open(path)
+
+				return m, nil
+			}
+
+			return m, func() tea.Msg {
+				return filePicked{path: path}
+			}
+		case "backspace", "left", "h":
+			m.open(filepath.Dir(m.dir))
+		case "~":
+			if home, err := os.UserHomeDir(); err == nil {
+				m.open(home)
+			}
+		case ".":
+			m.showHidden = !m.showHidden
+			m.list(m.selectedName())
+		}
+	}
+
+	return m, nil
+}
+
+// View implements tea.Model.
+func (m *FilePickerModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("Browse %s:\n\n", m.dir))
+
+	switch {
+	case m.err != nil:
+		b.WriteString("Cannot list this directory: " + m.err.Error() + "\n")
+	case len(m.entries) == 0:
+		b.WriteString("No directories or .kdbx files here.\n")
+	}
+
+	for i, entry := range m.entries {
+		cursor := " "
+		if m.cursor == i {
+			cursor = "▶"
+		}
+
+		name := entry.name
+		if entry.isDir {
+			name += "/"
+		}
+
+		line := fmt.Sprintf("  %s %s", cursor, name)
+		if m.cursor == i {
+			line = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Render(line)
+		}
+
+		b.WriteString(line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	// Footer
+	footer := "[↑/↓] Navigate  [Enter] Open/Add  [Backspace] Parent  [~] Home  [.] Hidden Files  [Esc] Cancel"
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
+
+// open lists another directory, with the cursor on the directory coming from
+// when going up.
+func (m *FilePickerModel) open(dir string) {
+	selected := ""
+	if filepath.Dir(m.dir) == dir {
+		selected = filepath.Base(m.dir)
+	}
+
+	m.dir = dir
+	m.list(selected)
+}
+
+func (m *FilePickerModel) selectedName() string {
+	if m.cursor >= len(m.entries) {
+		return ""
+	}
+
+	return m.entries[m.cursor].name
+}
+
+// list reads the directories and databases of the current directory,
+// directories first, with the cursor on the selected name when listed.
+func (m *FilePickerModel) list(selected string) {
+	m.entries = nil
+	m.cursor = 0
+
+	entries, err := os.ReadDir(m.dir)
+	m.err = err
+
+	var files []pickerEntry
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !m.showHidden {
+			continue
+		}
+
+		switch {
+		case keepass.IsDir(filepath.Join(m.dir, name), entry):
+			m.entries = append(m.entries, pickerEntry{name: name, isDir: true})
+		case keepass.IsDatabaseName(name):
+			files = append(files, pickerEntry{name: name, isDir: false})
+		}
+	}
+
+	m.entries = append(m.entries, files...)
+
+	for i, entry := range m.entries {
+		if entry.name == selected {
+			m.cursor = i
+		}
+	}
+}
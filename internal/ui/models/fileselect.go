@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -9,29 +10,60 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/keepass"
 	"github.com/martinlehoux/kagapass/internal/types"
 	"github.com/martinlehoux/kagapass/internal/ui/status"
 	"github.com/martinlehoux/kagapass/internal/ui/style"
 )
 
+// FileSelectActions are what the file selection screen asks the application
+// to do.
+type FileSelectActions struct {
+	OpenDatabases  func(databases []types.Database) tea.Cmd
+	ShowInfo       func(database types.Database)
+	CreateDatabase func(database types.Database, credentials keepass.Credentials) tea.Cmd
+}
+
 // FileSelectModel handles the file selection screen.
 type FileSelectModel struct {
-	// Commands
-	unlockDatabase *UnlockDatabase
+	actions FileSelectActions
 
 	databases     types.DatabaseList
 	cursor        int
 	databaseInput textinput.Model
 	status        status.Status
+	// marked holds the paths of the databases to open together
+	marked map[string]bool
+	// completions are the candidates of the last ambiguous path completion
+	completions []string
+	// scanRoots are the directories searched for databases to add
+	scanRoots []string
+	// picker is set while browsing for a database to add
+	picker *FilePickerModel
+	// creator is set while filling in a new database
+	creator *CreateDatabaseModel
+	// found are the scanned databases not in the list yet, set while choosing
+	// those to add
+	found       []string
+	foundCursor int
+	foundMarked map[string]bool
 }
 
-func NewFileSelectModel(databases types.DatabaseList, unlockDatabase *UnlockDatabase) *FileSelectModel {
+func NewFileSelectModel(databases types.DatabaseList, scanRoots []string, actions FileSelectActions) *FileSelectModel {
 	return &FileSelectModel{
-		unlockDatabase: unlockDatabase,
-		databases:      databases,
-		databaseInput:  textinput.New(),
-		cursor:         0,
-		status:         status.Status{},
+		actions:       actions,
+		databases:     databases,
+		databaseInput: textinput.New(),
+		cursor:        0,
+		status:        status.Status{},
+		marked:        map[string]bool{},
+		completions:   nil,
+		scanRoots:     scanRoots,
+		picker:        nil,
+		creator:       nil,
+		found:         nil,
+		foundCursor:   0,
+		foundMarked:   map[string]bool{},
 	}
 }
 
@@ -44,17 +76,52 @@ func (m *FileSelectModel) Update(msg tea.Msg) (*FileSelectModel, tea.Cmd) {
 	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
+	case DatabasesScanned:
+		m.showScanned(msg)
+	case filePicked:
+		return m.addPicked(msg.path)
+	case DatabaseCreated:
+		m.creator = nil
+		m.status = status.Success("Created database: " + msg.Database.Name)
+
+		return m, m.appendDatabases(msg.Database)
+	case DatabaseCreateFailed:
+		m.status = status.Error(msg.Error.Error())
 	case tea.KeyMsg:
+		// Sub-screens take priority over the list
+		if msg.String() == "esc" && m.cancel() {
+			return m, nil
+		}
+
+		if m.picker != nil {
+			m.picker, cmd = m.picker.Update(msg)
+
+			return m, cmd
+		}
+
+		if m.creator != nil {
+			if msg.String() == "enter" {
+				return m.create()
+			}
+
+			m.creator, cmd = m.creator.Update(msg)
+
+			return m, cmd
+		}
+
+		if m.found != nil {
+			return m.updateFound(msg)
+		}
+
 		// Handle input mode first - takes priority over navigation
 		if m.databaseInput.Focused() {
 			switch msg.String() {
-			case "esc":
-				m.databaseInput.Blur()
-				m.databaseInput.Reset()
-				m.status = status.Status{}
 			case "enter":
 				return m.addDatabase()
+			case "tab":
+				m.completePath()
 			default:
+				m.completions = nil
 				m.databaseInput, cmd = m.databaseInput.Update(msg)
 
 				return m, cmd
@@ -73,13 +140,43 @@ func (m *FileSelectModel) Update(msg tea.Msg) (*FileSelectModel, tea.Cmd) {
 			case "a":
 				m.databaseInput.Focus()
 				m.status = status.Status{}
+			case "b":
+				m.picker = NewFilePickerModel(m.browseDir())
+				m.status = status.Status{}
+			case "n":
+				m.creator = NewCreateDatabaseModel(m.browseDir())
+				m.status = status.Status{}
+			case "s":
+				if len(m.scanRoots) == 0 {
+					m.status = status.Error("No directories to scan, set scan_roots in the configuration")
+
+					return m, nil
+				}
+
+				m.status = status.Success("Scanning " + strings.Join(m.scanRoots, ", ") + "...")
+
+				return m, ScanDatabases(m.scanRoots)
+			case "i":
+				if m.cursor < len(m.databases.Databases) {
+					m.actions.ShowInfo(m.databases.Databases[m.cursor])
+				}
 			case "d":
 				m, cmd = m.removeDatabase()
 
 				return m, cmd
+			case " ":
+				if m.cursor < len(m.databases.Databases) {
+					path := m.databases.Databases[m.cursor].Path
+					if m.marked[path] {
+						delete(m.marked, path)
+					} else {
+						m.marked[path] = true
+					}
+				}
 			case "enter":
-				if len(m.databases.Databases) > 0 && m.cursor < len(m.databases.Databases) {
-					return m, m.unlockDatabase.Handle(m.databases.Databases[m.cursor], []byte{})
+				databases := m.selectedDatabases()
+				if len(databases) > 0 {
+					return m, m.actions.OpenDatabases(databases)
 				}
 			case "esc":
 				return m, tea.Quit
@@ -99,17 +196,42 @@ func (m *FileSelectModel) View() string {
 
 	b.WriteString(m.status.Render() + "\n\n")
 
+	if m.picker != nil {
+		b.WriteString(m.picker.View())
+
+		return b.String()
+	}
+
+	if m.creator != nil {
+		b.WriteString(m.creator.View())
+
+		return b.String()
+	}
+
+	if m.found != nil {
+		b.WriteString(m.foundView())
+
+		return b.String()
+	}
+
 	if m.databaseInput.Focused() {
 		b.WriteString("Enter path to KeePass database (.kdbx file):\n\n")
 		b.WriteString(m.databaseInput.View() + "\n\n")
-		b.WriteString("[Enter] Add  [Esc] Cancel\n")
+
+		if len(m.completions) > 0 {
+			b.WriteString(lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#626262")).
+				Render(strings.Join(m.completions, "  ")) + "\n\n")
+		}
+
+		b.WriteString("[Tab] Complete  [Enter] Add  [Esc] Cancel\n")
 
 		return b.String()
 	}
 
 	if len(m.databases.Databases) == 0 {
 		b.WriteString("No KeePass databases configured.\n\n")
-		b.WriteString("Press 'a' to add a database file, 'Esc' to quit.\n")
+		b.WriteString("Press 'a' to type the path of a database file, 'b' to browse for one, 's' to scan for databases, 'n' to create one, 'Esc' to quit.\n")
 
 		return b.String()
 	}
@@ -123,12 +245,17 @@ func (m *FileSelectModel) View() string {
 			cursor = "▶"
 		}
 
+		mark := "[ ]"
+		if m.marked[db.Path] {
+			mark = "[x]"
+		}
+
 		name := db.Name
 		if name == "" {
 			name = fmt.Sprintf("Database %d", i+1)
 		}
 
-		line := fmt.Sprintf("  %s %s", cursor, name)
+		line := fmt.Sprintf("  %s %s %s", cursor, mark, name)
 
 		if len(db.Path) > 0 {
 			maxPathLen := 40
@@ -152,7 +279,7 @@ func (m *FileSelectModel) View() string {
 	b.WriteString("\n")
 
 	// Footer
-	footer := "[Enter] Open  [Esc] Quit  [a] Add new file  [d] Remove"
+	footer := "[Enter] Open  [Space] Mark  [Esc] Quit  [a] Add new file  [b] Browse  [s] Scan  [n] New  [d] Remove  [i] Info"
 	b.WriteString(lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#626262")).
 		Render(footer))
@@ -160,50 +287,295 @@ func (m *FileSelectModel) View() string {
 	return b.String()
 }
 
-// addDatabase validates and adds a new database to the list.
-func (m *FileSelectModel) addDatabase() (*FileSelectModel, tea.Cmd) {
-	path := strings.TrimSpace(m.databaseInput.Value())
+// foundView lists the scanned databases to choose those to add.
+func (m *FileSelectModel) foundView() string {
+	var b strings.Builder
 
-	// Validate path
-	if path == "" {
-		m.status = status.Error("Path cannot be empty")
+	b.WriteString(fmt.Sprintf("Found %d databases not in the list:\n\n", len(m.found)))
 
-		return m, nil
+	for i, path := range m.found {
+		cursor := " "
+		if m.foundCursor == i {
+			cursor = "▶"
+		}
+
+		mark := "[ ]"
+		if m.foundMarked[path] {
+			mark = "[x]"
+		}
+
+		line := fmt.Sprintf("  %s %s %s", cursor, mark, path)
+		if m.foundCursor == i {
+			line = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Render(line)
+		}
+
+		b.WriteString(line + "\n")
 	}
 
-	// Check if already in list
-	for _, db := range m.databases.Databases {
-		if db.Path == path {
-			m.status = status.Error("Database already in list")
+	b.WriteString("\n")
+
+	// Footer
+	footer := "[↑/↓] Navigate  [Space] Mark  [Enter] Add Marked  [Esc] Cancel"
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
+
+// cancel leaves typing a path, the file picker, the new database form or the
+// scan results, reporting whether there was one to leave.
+func (m *FileSelectModel) cancel() bool {
+	switch {
+	case m.picker != nil:
+		m.picker = nil
+	case m.creator != nil:
+		m.creator = nil
+	case m.found != nil:
+		m.found = nil
+		m.foundMarked = map[string]bool{}
+	case m.databaseInput.Focused():
+		m.databaseInput.Blur()
+		m.databaseInput.Reset()
+		m.completions = nil
+	default:
+		return false
+	}
+
+	m.status = status.Status{}
+
+	return true
+}
+
+// completePath completes the typed path, listing the candidates when they
+// differ.
+func (m *FileSelectModel) completePath() {
+	completed, candidates := keepass.CompletePath(m.databaseInput.Value())
+	m.databaseInput.SetValue(completed)
+	m.databaseInput.CursorEnd()
+
+	m.completions = nil
+	if len(candidates) > 1 {
+		m.completions = candidates
+	}
+}
+
+// browseDir returns the directory of the database under the cursor to start
+// browsing from, or the home directory.
+func (m *FileSelectModel) browseDir() string {
+	if m.cursor < len(m.databases.Databases) {
+		dir := filepath.Dir(m.databases.Databases[m.cursor].Path)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		return home
+	}
+
+	return "/"
+}
+
+// showScanned offers to add the scanned databases that are not in the list.
+func (m *FileSelectModel) showScanned(msg DatabasesScanned) {
+	var found []string
+
+	for _, path := range msg.Paths {
+		if !m.listed(path) {
+			found = append(found, path)
+		}
+	}
+
+	switch {
+	case msg.Error != nil:
+		m.status = status.Error("Failed to scan: " + msg.Error.Error())
+	case len(found) == 0:
+		m.status = status.Success("No new databases in " + strings.Join(m.scanRoots, ", "))
+	default:
+		m.status = status.Status{}
+	}
+
+	if len(found) == 0 {
+		return
+	}
+
+	m.found = found
+	m.foundCursor = 0
+
+	m.foundMarked = map[string]bool{}
+	for _, path := range found {
+		m.foundMarked[path] = true
+	}
+}
+
+func (m *FileSelectModel) updateFound(msg tea.KeyMsg) (*FileSelectModel, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k":
+		if m.foundCursor > 0 {
+			m.foundCursor--
+		}
+	case "down", "j":
+		if m.foundCursor < len(m.found)-1 {
+			m.foundCursor++
+		}
+	case " ":
+		path := m.found[m.foundCursor]
+		m.foundMarked[path] = !m.foundMarked[path]
+	case "enter":
+		var databases []types.Database
+
+		for _, path := range m.found {
+			if m.foundMarked[path] {
+				databases = append(databases, newDatabase(path))
+			}
+		}
+
+		if len(databases) == 0 {
+			m.status = status.Error("No database marked")
 
 			return m, nil
 		}
+
+		m.found = nil
+		m.foundMarked = map[string]bool{}
+		m.status = status.Success(fmt.Sprintf("Added %d databases", len(databases)))
+
+		return m, m.appendDatabases(databases...)
 	}
 
-	// Add database
-	newDB := types.Database{
-		Name:         filepath.Base(path),
-		Path:         path,
-		LastAccessed: time.Now(),
+	return m, nil
+}
+
+// addDatabase validates and adds the typed database to the list.
+func (m *FileSelectModel) addDatabase() (*FileSelectModel, tea.Cmd) {
+	database, ok := m.checkDatabase(m.databaseInput.Value())
+	if !ok {
+		return m, nil
 	}
 
-	m.databases.Databases = append(m.databases.Databases, newDB)
-	m.cursor = len(m.databases.Databases) - 1
 	m.databaseInput.Blur()
 	m.databaseInput.Reset()
-	m.status = status.Success("Added database: " + newDB.Name)
+	m.completions = nil
+	m.status = status.Success("Added database: " + database.Name)
 
-	return m, func() tea.Msg {
+	return m, m.appendDatabases(database)
+}
+
+// addPicked adds the database chosen in the file picker, leaving it open when
+// the file cannot be added.
+func (m *FileSelectModel) addPicked(path string) (*FileSelectModel, tea.Cmd) {
+	database, ok := m.checkDatabase(path)
+	if !ok {
+		return m, nil
+	}
+
+	m.picker = nil
+	m.status = status.Success("Added database: " + database.Name)
+
+	return m, m.appendDatabases(database)
+}
+
+// create hands the new database over to be written, once the form is valid.
+func (m *FileSelectModel) create() (*FileSelectModel, tea.Cmd) {
+	database, credentials, err := m.creator.request()
+	if err != nil {
+		m.status = status.Error("Cannot create database: " + err.Error())
+
+		return m, nil
+	}
+
+	if m.listed(database.Path) {
+		m.status = status.Error("Database already in list")
+
+		return m, nil
+	}
+
+	m.status = status.Success("Creating " + database.Path + "...")
+
+	return m, m.actions.CreateDatabase(database, credentials)
+}
+
+// checkDatabase returns the database at the canonical path, or reports why it
+// cannot be added.
+func (m *FileSelectModel) checkDatabase(path string) (types.Database, bool) {
+	path, err := keepass.CanonicalPath(path)
+	if err != nil {
+		m.status = status.Error("Invalid path: " + err.Error())
+
+		return types.Database{}, false //nolint:exhaustruct // No database
+	}
+
+	err = keepass.CheckDatabase(path)
+	if err != nil {
+		m.status = status.Error(err.Error())
+
+		return types.Database{}, false //nolint:exhaustruct // No database
+	}
+
+	if m.listed(path) {
+		m.status = status.Error("Database already in list")
+
+		return types.Database{}, false //nolint:exhaustruct // No database
+	}
+
+	return newDatabase(path), true
+}
+
+func (m *FileSelectModel) listed(path string) bool {
+	for _, db := range m.databases.Databases {
+		if db.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *FileSelectModel) appendDatabases(databases ...types.Database) tea.Cmd {
+	m.databases.Databases = append(m.databases.Databases, databases...)
+	m.cursor = len(m.databases.Databases) - 1
+
+	return func() tea.Msg {
 		return UpdateDatabaseListMsg{DatabaseList: m.databases}
 	}
 }
 
+func newDatabase(path string) types.Database {
+	return types.Database{
+		Name:           filepath.Base(path),
+		Path:           path,
+		LastAccessed:   time.Now(),
+		KeyFile:        "",
+		SecretStoredAt: time.Time{},
+	}
+}
+
+// selectedDatabases returns the marked databases in list order, or the one
+// under the cursor when none is marked.
+func (m *FileSelectModel) selectedDatabases() []types.Database {
+	var databases []types.Database
+
+	for _, db := range m.databases.Databases {
+		if m.marked[db.Path] {
+			databases = append(databases, db)
+		}
+	}
+
+	if len(databases) == 0 && m.cursor < len(m.databases.Databases) {
+		databases = append(databases, m.databases.Databases[m.cursor])
+	}
+
+	return databases
+}
+
 func (m *FileSelectModel) removeDatabase() (*FileSelectModel, tea.Cmd) {
 	if m.cursor < 0 || m.cursor >= len(m.databases.Databases) {
 		return m, nil
 	}
 
 	deleted := m.databases.Databases[m.cursor]
+	delete(m.marked, deleted.Path)
 	m.databases.Databases = append(m.databases.Databases[:m.cursor], m.databases.Databases[m.cursor+1:]...)
 	m.cursor = max(0, m.cursor-1)
 	m.status = status.Success("Removed database: " + deleted.Name)
@@ -1,15 +1,20 @@
 package models
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/martinlehoux/kagapass/internal/keepass"
 	"github.com/martinlehoux/kagapass/internal/testor"
 	"github.com/martinlehoux/kagapass/internal/types"
 )
 
 func TestInputModeNavigationKeyConflicts(t *testing.T) {
-	model := NewFileSelectModel(types.DatabaseList{}, unlockDatabase)
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{})
 
 	// Enter input mode by pressing 'a'
 	model, _ = model.Update(testor.KeyMsgRune('a'))
@@ -57,7 +62,7 @@ func TestNavigationKeysDisabledInInputMode(t *testing.T) {
 			{Name: "test1.kdbx", Path: "/path1"},
 			{Name: "test2.kdbx", Path: "/path2"},
 		},
-	}, unlockDatabase)
+	}, nil, FileSelectActions{})
 
 	// Enter input mode
 	model, _ = model.Update(testor.KeyMsgRune('a'))
@@ -110,7 +115,7 @@ func TestNavigationKeysDisabledInInputMode(t *testing.T) {
 }
 
 func TestInputModeEscapeBehavior(t *testing.T) {
-	model := NewFileSelectModel(types.DatabaseList{}, unlockDatabase)
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{})
 
 	// Enter input mode
 	model, _ = model.Update(testor.KeyMsgRune('a'))
@@ -147,7 +152,7 @@ func TestNavigationWorksWhenNotInInputMode(t *testing.T) {
 			{Name: "test2.kdbx", Path: "/path2"},
 			{Name: "test3.kdbx", Path: "/path3"},
 		},
-	}, unlockDatabase)
+	}, nil, FileSelectActions{})
 
 	// Test vim-style navigation works in normal mode
 	initialCursor := model.cursor
@@ -171,7 +176,7 @@ func TestNavigationWorksWhenNotInInputMode(t *testing.T) {
 }
 
 func TestFileSelectInputModeToggling(t *testing.T) {
-	model := NewFileSelectModel(types.DatabaseList{}, unlockDatabase)
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{})
 
 	// Initially not in input mode
 	if model.databaseInput.Focused() {
@@ -196,3 +201,305 @@ func TestFileSelectInputModeToggling(t *testing.T) {
 		t.Error("Should be able to re-enter input mode")
 	}
 }
+
+func TestFileSelectAddDatabase(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeEmptyDatabase(t, home)
+
+	if err := os.WriteFile(filepath.Join(home, "notes.txt"), []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{})
+
+	add := func(path string) tea.Cmd {
+		// The input stays focused after a refused path
+		if !model.databaseInput.Focused() {
+			model, _ = model.Update(testor.KeyMsgRune('a'))
+		}
+
+		for _, r := range path {
+			model, _ = model.Update(testor.KeyMsgRune(r))
+		}
+
+		var cmd tea.Cmd
+
+		model, cmd = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+		return cmd
+	}
+
+	if cmd := add("~/test.kdbx"); cmd == nil {
+		t.Fatalf("Expected the database to be added, got status %q", model.status.Render())
+	}
+
+	expected, _ := filepath.EvalSymlinks(filepath.Join(home, "test.kdbx"))
+	if len(model.databases.Databases) != 1 || model.databases.Databases[0].Path != expected {
+		t.Errorf("Expected the canonical path %s, got %+v", expected, model.databases.Databases)
+	}
+
+	// The same file by another path is already in the list
+	model.databaseInput.Reset()
+	if cmd := add(home + "/./test.kdbx"); cmd != nil || !strings.Contains(model.status.Render(), "already in list") {
+		t.Errorf("Expected the duplicate to be refused, got status %q", model.status.Render())
+	}
+
+	model.databaseInput.Reset()
+	if cmd := add("~/notes.txt"); cmd != nil || !strings.Contains(model.status.Render(), "not a KeePass 2 database") {
+		t.Errorf("Expected other files to be refused, got status %q", model.status.Render())
+	}
+
+	model.databaseInput.Reset()
+	if cmd := add("~/missing.kdbx"); cmd != nil || !strings.Contains(model.status.Render(), "Invalid path") {
+		t.Errorf("Expected missing files to be refused, got status %q", model.status.Render())
+	}
+}
+
+func TestFileSelectCompletePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if err := os.MkdirAll(filepath.Join(home, "Sync", "work"), 0o700); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	writeEmptyDatabase(t, filepath.Join(home, "Sync"))
+
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{})
+	model, _ = model.Update(testor.KeyMsgRune('a'))
+
+	for _, r := range "~/Sy" {
+		model, _ = model.Update(testor.KeyMsgRune(r))
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	if model.databaseInput.Value() != "~/Sync/" {
+		t.Errorf("Expected ~/Sync/, got %q", model.databaseInput.Value())
+	}
+
+	// Both the directory and the database match, the candidates are listed
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	if !strings.Contains(model.View(), "test.kdbx") || !strings.Contains(model.View(), "work/") {
+		t.Errorf("Expected the candidates to be listed, got %q", model.View())
+	}
+
+	model, _ = model.Update(testor.KeyMsgRune('t'))
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+
+	if model.databaseInput.Value() != "~/Sync/test.kdbx" {
+		t.Errorf("Expected ~/Sync/test.kdbx, got %q", model.databaseInput.Value())
+	}
+
+	if _, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter}); cmd == nil {
+		t.Errorf("Expected the completed database to be added, got status %q", model.status.Render())
+	}
+}
+
+func TestFileSelectBrowse(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	for _, dir := range []string{"Sync", ".cache"} {
+		if err := os.Mkdir(filepath.Join(home, dir), 0o700); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	writeEmptyDatabase(t, filepath.Join(home, "Sync"))
+
+	if err := os.WriteFile(filepath.Join(home, "Sync", "notes.txt"), []byte("notes"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{})
+	model, _ = model.Update(testor.KeyMsgRune('b'))
+
+	// Hidden directories are only listed on demand
+	if model.picker == nil || len(model.picker.entries) != 1 || model.picker.entries[0].name != "Sync" {
+		t.Fatalf("Expected the picker to list Sync only, got %+v", model.picker)
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	// Other files are not listed
+	if len(model.picker.entries) != 1 || model.picker.entries[0].name != "test.kdbx" {
+		t.Fatalf("Expected the picker to list test.kdbx only, got %+v", model.picker.entries)
+	}
+
+	model, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatal("Expected the database to be picked")
+	}
+
+	model, cmd = model.Update(cmd())
+	if cmd == nil || model.picker != nil {
+		t.Fatalf("Expected the database to be added, got status %q", model.status.Render())
+	}
+
+	if len(model.databases.Databases) != 1 || model.databases.Databases[0].Name != "test.kdbx" {
+		t.Errorf("Expected test.kdbx in the list, got %+v", model.databases.Databases)
+	}
+
+	// Browsing starts from the directory of the selected database, going up
+	// selects the directory left
+	model, _ = model.Update(testor.KeyMsgRune('b'))
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	model, _ = model.Update(testor.KeyMsgRune('.'))
+
+	if len(model.picker.entries) != 2 || model.picker.entries[model.picker.cursor].name != "Sync" {
+		t.Errorf("Expected .cache and Sync with Sync selected, got %+v", model.picker)
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if model.picker != nil {
+		t.Error("Expected Esc to close the picker")
+	}
+}
+
+func TestFileSelectScan(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	for _, dir := range []string{"Sync", "Nextcloud"} {
+		if err := os.Mkdir(filepath.Join(home, dir), 0o700); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+
+		writeEmptyDatabase(t, filepath.Join(home, dir))
+	}
+
+	root, _ := filepath.EvalSymlinks(home)
+	listed := filepath.Join(root, "Sync", "test.kdbx")
+
+	model := NewFileSelectModel(types.DatabaseList{
+		Databases: []types.Database{{Name: "test.kdbx", Path: listed}},
+	}, []string{"~/Sync", "~/Nextcloud", "~/Dropbox"}, FileSelectActions{})
+
+	model, cmd := model.Update(testor.KeyMsgRune('s'))
+	if cmd == nil {
+		t.Fatal("Expected a scan")
+	}
+
+	// Databases already in the list are not offered
+	model, _ = model.Update(cmd())
+	if len(model.found) != 1 || model.found[0] != filepath.Join(root, "Nextcloud", "test.kdbx") {
+		t.Fatalf("Expected the Nextcloud database only, got %v", model.found)
+	}
+
+	// Unmarking every database adds none
+	model, _ = model.Update(testor.KeyMsgRune(' '))
+	if model, cmd = model.Update(tea.KeyMsg{Type: tea.KeyEnter}); cmd != nil {
+		t.Error("Expected no database to be added")
+	}
+
+	model, _ = model.Update(testor.KeyMsgRune(' '))
+
+	model, cmd = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil || model.found != nil {
+		t.Fatalf("Expected the marked databases to be added, got status %q", model.status.Render())
+	}
+
+	if len(model.databases.Databases) != 2 {
+		t.Errorf("Expected 2 databases, got %+v", model.databases.Databases)
+	}
+
+	// Everything is in the list now
+	model, cmd = model.Update(testor.KeyMsgRune('s'))
+	model, _ = model.Update(cmd())
+
+	if model.found != nil || !strings.Contains(model.status.Render(), "No new databases") {
+		t.Errorf("Expected no new databases, got %v, status %q", model.found, model.status.Render())
+	}
+}
+
+func TestAppEscapeLeavesAddingDatabase(t *testing.T) {
+	app := &AppModel{screen: FileSelectionScreen, now: time.Now}
+	app.fileSelector = NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{OpenDatabases: app.openDatabases})
+
+	for _, key := range []rune{'a', 'b'} {
+		app.Update(testor.KeyMsgRune(key))
+
+		if _, cmd := app.Update(tea.KeyMsg{Type: tea.KeyEsc}); cmd != nil {
+			t.Errorf("Expected Esc to leave adding with %c, not to quit", key)
+		}
+	}
+
+	if _, cmd := app.Update(tea.KeyMsg{Type: tea.KeyEsc}); cmd == nil {
+		t.Error("Expected Esc to quit from the list")
+	}
+}
+
+func TestFileSelectCreateDatabase(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	loader := keepass.NewLoader(keepass.RootFS())
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{CreateDatabase: func(database types.Database, credentials keepass.Credentials) tea.Cmd {
+		return CreateDatabase(loader, database, credentials)
+	}})
+	model, _ = model.Update(testor.KeyMsgRune('n'))
+
+	if model.creator == nil || model.creator.inputs[createDirField].Value() != home {
+		t.Fatalf("Expected the new database form in the home directory, got %q", model.View())
+	}
+
+	typeText := func(text string) {
+		for _, r := range text {
+			model, _ = model.Update(testor.KeyMsgRune(r))
+		}
+	}
+
+	typeText("Personal")
+
+	// A password or a key file is required
+	if _, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter}); cmd != nil || !strings.Contains(model.View(), "a password or a key file is required") {
+		t.Fatalf("Expected the missing master key to be reported, got %q", model.View())
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	typeText("secret")
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	typeText("secret")
+
+	model, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatalf("Expected the database to be created, got %q", model.View())
+	}
+
+	model, cmd = model.Update(cmd())
+	if model.creator != nil || !strings.Contains(model.View(), "Created database: Personal.kdbx") {
+		t.Fatalf("Expected the database to be created, got %q", model.View())
+	}
+
+	// The new database is registered right away
+	path := filepath.Join(home, "Personal.kdbx")
+
+	updated, ok := cmd().(UpdateDatabaseListMsg)
+	if !ok || len(updated.DatabaseList.Databases) != 1 || updated.DatabaseList.Databases[0].Path != path {
+		t.Fatalf("Expected the database list to be updated, got %+v", updated)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("Expected the database to be readable by the user only, got %v, %v", info, err)
+	}
+
+	if header, err := loader.ReadHeader(path); err != nil || header.KDF != keepass.DefaultKDF(keepass.Argon2d) {
+		t.Errorf("Expected the default Argon2d settings, got %+v, %v", header, err)
+	}
+
+	// Creating it again keeps the existing file
+	model.databases = types.DatabaseList{}
+	model, _ = model.Update(testor.KeyMsgRune('n'))
+	typeText("Personal")
+	model.creator.inputs[createPasswordField].SetValue("other")
+	model.creator.inputs[createConfirmField].SetValue("other")
+
+	_, cmd = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	model, _ = model.Update(cmd())
+
+	if model.creator == nil || !strings.Contains(model.View(), "file already exists") {
+		t.Errorf("Expected the existing file to be reported, got %q", model.View())
+	}
+}
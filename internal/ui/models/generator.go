@@ -0,0 +1,145 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/clipboard"
+	"github.com/martinlehoux/kagapass/internal/generator"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/status"
+	"github.com/martinlehoux/kagapass/internal/ui/style"
+)
+
+// GeneratorModel handles the password generator screen.
+type GeneratorModel struct {
+	config    types.Config
+	policies  []types.PasswordPolicy
+	cursor    int
+	password  string
+	clipboard *clipboard.Clipboard
+	status    status.Status
+
+	// Actions
+	// use is nil when the generator is not opened from the edit screen
+	use func(password string)
+}
+
+// NewGeneratorModel creates a new generator model and generates a first password.
+func NewGeneratorModel(clipboard *clipboard.Clipboard, config types.Config, use func(password string)) *GeneratorModel {
+	m := &GeneratorModel{
+		config:    config,
+		policies:  config.PasswordPolicies,
+		cursor:    0,
+		password:  "",
+		clipboard: clipboard,
+		status:    status.Status{},
+		use:       use,
+	}
+	m.generate()
+
+	return m
+}
+
+// Update implements tea.Model.
+func (m *GeneratorModel) Update(msg tea.Msg) (*GeneratorModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+				m.generate()
+			}
+		case "down", "j":
+			if m.cursor < len(m.policies)-1 {
+				m.cursor++
+				m.generate()
+			}
+		case "r", "ctrl+r":
+			m.generate()
+		case "ctrl+c":
+			m.status = copyToClipboard(m.clipboard, m.config, "password", m.password)
+		case "enter":
+			if m.use != nil && m.password != "" {
+				m.use(m.password)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// View implements tea.Model.
+func (m *GeneratorModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(style.ViewTitle.Render("Password Generator") + "\n\n")
+
+	b.WriteString(m.status.Render() + "\n\n")
+
+	passwordStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#7D56F4")).
+		Background(lipgloss.Color("#2A2A2A")).
+		Padding(0, 1)
+
+	password := m.password
+	if password == "" {
+		password = "(none)"
+	}
+
+	b.WriteString(passwordStyle.Render(password) + "\n\n")
+
+	if len(m.policies) == 0 {
+		b.WriteString("No password policies configured.\n")
+	}
+
+	descriptionStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#626262"))
+
+	for i, policy := range m.policies {
+		cursor := " "
+		if m.cursor == i {
+			cursor = "▶"
+		}
+
+		line := fmt.Sprintf("  %s %s", cursor, policy.Name)
+		if m.cursor == i {
+			line = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Render(line)
+		}
+
+		b.WriteString(line + " " + descriptionStyle.Render("("+generator.Describe(policy)+")") + "\n")
+	}
+
+	b.WriteString("\n")
+
+	// Footer
+	footer := "[↑/↓] Policy  [R] Regenerate  [Ctrl+C] Copy  [Esc] Back"
+	if m.use != nil {
+		footer = "[↑/↓] Policy  [R] Regenerate  [Ctrl+C] Copy  [Enter] Use  [Esc] Back"
+	}
+
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
+
+func (m *GeneratorModel) generate() {
+	if m.cursor >= len(m.policies) {
+		return
+	}
+
+	password, err := generator.Generate(m.policies[m.cursor])
+	if err != nil {
+		m.password = ""
+		m.status = status.Error("Invalid policy: " + err.Error())
+
+		return
+	}
+
+	m.password = password
+	m.status = status.Status{}
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/status"
+	"github.com/martinlehoux/kagapass/internal/ui/style"
+)
+
+// groupIcons maps KeePass standard icon IDs to their closest symbol. Other
+// icons are shown as folders.
+var groupIcons = map[int64]string{
+	0:  "🔑",
+	1:  "🌐",
+	3:  "🖥",
+	19: "✉",
+	25: "📬",
+	29: "💻",
+	30: "💻",
+	37: "💰",
+	43: "🗑",
+	60: "🏠",
+	61: "⭐",
+	66: "💰",
+}
+
+// GroupRoot is the group tree of one unlocked database.
+type GroupRoot struct {
+	Database types.Database
+	Group    types.Group
+}
+
+// groupRow is a visible line of the tree.
+type groupRow struct {
+	database types.Database
+	group    types.Group
+	depth    int
+	key      string
+}
+
+// GroupTreeModel handles the group browser screen.
+type GroupTreeModel struct {
+	roots []GroupRoot
+	// expanded holds the keys of the expanded groups
+	expanded map[string]bool
+	rows     []groupRow
+	cursor   int
+	status   status.Status
+
+	// Actions
+	selectGroup func(database types.Database, group types.Group)
+}
+
+// NewGroupTreeModel creates a group browser with the root groups expanded.
+func NewGroupTreeModel(roots []GroupRoot, selectGroup func(database types.Database, group types.Group)) *GroupTreeModel {
+	m := &GroupTreeModel{
+		roots:       roots,
+		expanded:    map[string]bool{},
+		rows:        nil,
+		cursor:      0,
+		status:      status.Status{},
+		selectGroup: selectGroup,
+	}
+
+	for _, root := range roots {
+		m.expanded[groupKey(root.Database, root.Group)] = true
+	}
+
+	m.refreshRows()
+
+	return m
+}
+
+// Update implements tea.Model.
+func (m *GroupTreeModel) Update(msg tea.Msg) (*GroupTreeModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.rows)-1 {
+				m.cursor++
+			}
+		case "right", "l":
+			if row, ok := m.selectedRow(); ok && len(row.group.Groups) > 0 {
+				if m.expanded[row.key] {
+					// Already expanded, move to the first subgroup
+					m.cursor++
+				} else {
+					m.expanded[row.key] = true
+					m.refreshRows()
+				}
+			}
+		case "left", "h":
+			if row, ok := m.selectedRow(); ok {
+				if m.expanded[row.key] && len(row.group.Groups) > 0 {
+					delete(m.expanded, row.key)
+					m.refreshRows()
+				} else {
+					m.moveToParent(row)
+				}
+			}
+		case " ":
+			if row, ok := m.selectedRow(); ok && len(row.group.Groups) > 0 {
+				m.expanded[row.key] = !m.expanded[row.key]
+				m.refreshRows()
+			}
+		case "enter":
+			if row, ok := m.selectedRow(); ok {
+				m.selectGroup(row.database, row.group)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// View implements tea.Model.
+func (m *GroupTreeModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(style.ViewTitle.Render("Groups") + "\n\n")
+
+	b.WriteString(m.status.Render() + "\n\n")
+
+	if len(m.rows) == 0 {
+		b.WriteString("No groups in database.\n")
+	}
+
+	countStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#626262"))
+
+	for i, row := range m.rows {
+		cursor := " "
+		if m.cursor == i {
+			cursor = "▶"
+		}
+
+		toggle := " "
+		if len(row.group.Groups) > 0 {
+			toggle = "▸"
+			if m.expanded[row.key] {
+				toggle = "▾"
+			}
+		}
+
+		name := row.group.Name
+		if row.depth == 0 && len(m.roots) > 1 {
+			name = row.database.Name
+		}
+
+		line := fmt.Sprintf("  %s %s%s %s %s", cursor, strings.Repeat("  ", row.depth), toggle, m.icon(row), printable(name))
+		if m.cursor == i {
+			line = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Render(line)
+		}
+
+		b.WriteString(line + " " + countStyle.Render(fmt.Sprintf("(%d)", row.group.TotalEntries())) + "\n")
+	}
+
+	b.WriteString("\n")
+
+	// Footer
+	footer := "[↑/↓] Navigate  [←/→] Collapse/Expand  [Space] Toggle  [Enter] Search in group  [Esc] Back"
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
+
+func (m *GroupTreeModel) icon(row groupRow) string {
+	if icon, ok := groupIcons[row.group.IconID]; ok {
+		return icon
+	}
+
+	if m.expanded[row.key] && len(row.group.Groups) > 0 {
+		return "📂"
+	}
+
+	return "📁"
+}
+
+func (m *GroupTreeModel) selectedRow() (groupRow, bool) {
+	if m.cursor >= len(m.rows) {
+		return groupRow{}, false //nolint:exhaustruct // No row
+	}
+
+	return m.rows[m.cursor], true
+}
+
+// moveToParent moves the cursor to the closest row above with a lower depth.
+func (m *GroupTreeModel) moveToParent(row groupRow) {
+	for i := m.cursor - 1; i >= 0; i-- {
+		if m.rows[i].depth < row.depth {
+			m.cursor = i
+
+			return
+		}
+	}
+}
+
+// refreshRows flattens the expanded part of the trees into rows.
+func (m *GroupTreeModel) refreshRows() {
+	m.rows = nil
+
+	var walk func(database types.Database, group types.Group, depth int)
+
+	walk = func(database types.Database, group types.Group, depth int) {
+		key := groupKey(database, group)
+		m.rows = append(m.rows, groupRow{database: database, group: group, depth: depth, key: key})
+
+		if m.expanded[key] {
+			for _, child := range group.Groups {
+				walk(database, child, depth+1)
+			}
+		}
+	}
+
+	for _, root := range m.roots {
+		walk(root.Database, root.Group, 0)
+	}
+
+	m.cursor = min(m.cursor, max(len(m.rows)-1, 0))
+}
+
+// groupKey identifies a group across databases, whose UUIDs may collide when
+// they are copies of each other.
+func groupKey(database types.Database, group types.Group) string {
+	return database.Path + "/" + fmt.Sprintf("%x", group.UUID)
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/clipboard"
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/status"
+	"github.com/martinlehoux/kagapass/internal/ui/style"
+)
+
+// fieldChange is a field that differs between a version and the one that
+// replaced it.
+type fieldChange struct {
+	name      string
+	before    string
+	after     string
+	protected bool
+}
+
+// HistoryModel handles the entry history screen, listing the previous
+// versions of an entry.
+type HistoryModel struct {
+	entry types.Entry
+	// versions are the previous versions, most recent first
+	versions     []types.Entry
+	cursor       int
+	clipboard    *clipboard.Clipboard
+	config       types.Config
+	status       status.Status
+	showPassword bool
+	// Whether the next restore key press confirms the restore
+	confirmRestore bool
+
+	// Actions
+	restoreVersion func(entry types.Entry, version types.Entry) tea.Cmd
+}
+
+// NewHistoryModel creates a new history model.
+func NewHistoryModel(
+	clipboard *clipboard.Clipboard,
+	config types.Config,
+	entry types.Entry,
+	restoreVersion func(entry types.Entry, version types.Entry) tea.Cmd,
+) *HistoryModel {
+	return &HistoryModel{
+		entry:          entry,
+		versions:       keepass.History(entry),
+		cursor:         0,
+		clipboard:      clipboard,
+		config:         config,
+		status:         status.Status{},
+		showPassword:   false,
+		confirmRestore: false,
+		restoreVersion: restoreVersion,
+	}
+}
+
+// Update implements tea.Model.
+func (m *HistoryModel) Update(msg tea.Msg) (*HistoryModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.String() != "ctrl+r" {
+			m.confirmRestore = false
+		}
+
+		switch msg.String() {
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.versions)-1 {
+				m.cursor++
+			}
+		case "ctrl+c":
+			if version, ok := m.selectedVersion(); ok {
+				m.status = copyToClipboard(m.clipboard, m.config, "password", version.Password)
+			}
+		case "ctrl+p":
+			m.showPassword = !m.showPassword
+			if m.showPassword {
+				m.status = status.Success("Passwords revealed")
+			} else {
+				m.status = status.Success("Passwords hidden")
+			}
+		case "ctrl+r":
+			version, ok := m.selectedVersion()
+			if !ok {
+				return m, nil
+			}
+
+			if !m.confirmRestore {
+				m.confirmRestore = true
+				m.status = status.Error("Press Ctrl+R again to restore this version")
+
+				return m, nil
+			}
+
+			m.confirmRestore = false
+
+			return m, m.restoreVersion(m.entry, version)
+		}
+	case EntryWriteFailed:
+		m.status = status.Error(msg.Error.Error())
+	}
+
+	return m, nil
+}
+
+// View implements tea.Model.
+func (m *HistoryModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(style.ViewTitle.Render("History of "+printable(m.entry.Title)) + "\n\n")
+
+	b.WriteString(m.status.Render() + "\n\n")
+
+	if len(m.versions) == 0 {
+		b.WriteString("No previous versions.\n")
+	}
+
+	for i, version := range m.versions {
+		cursor := " "
+		if m.cursor == i {
+			cursor = "▶"
+		}
+
+		var names []string
+		for _, change := range diffVersions(version, m.replacement(i)) {
+			names = append(names, change.name)
+		}
+
+		summary := "no field changed"
+		if len(names) > 0 {
+			summary = strings.Join(names, ", ") + " changed"
+		}
+
+		line := fmt.Sprintf("  %s %s  %s", cursor, version.Modified.Format("2006-01-02 15:04:05"), summary)
+		if m.cursor == i {
+			line = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Render(line)
+		}
+
+		b.WriteString(line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	// Changes made by the next version to the selected one
+	if version, ok := m.selectedVersion(); ok {
+		changes := diffVersions(version, m.replacement(m.cursor))
+		if len(changes) > 0 {
+			b.WriteString("Changes made after this version:\n")
+		}
+
+		for _, change := range changes {
+			hidden := change.protected && !m.showPassword
+			b.WriteString(fmt.Sprintf("  %s: %s → %s\n", change.name, displayValue(change.before, hidden), displayValue(change.after, hidden)))
+		}
+
+		if len(changes) > 0 {
+			b.WriteString("\n")
+		}
+	}
+
+	// Footer
+	footer := "[↑/↓] Navigate  [Ctrl+C] Copy Pass  [Ctrl+P] Toggle Pass  [Ctrl+R] Restore  [Esc] Back"
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
+
+func (m *HistoryModel) selectedVersion() (types.Entry, bool) {
+	if m.cursor >= len(m.versions) {
+		return types.Entry{}, false //nolint:exhaustruct // No version
+	}
+
+	return m.versions[m.cursor], true
+}
+
+// replacement returns the version that replaced the i-th one, the entry
+// itself for the most recent one.
+func (m *HistoryModel) replacement(i int) types.Entry {
+	if i == 0 {
+		return m.entry
+	}
+
+	return m.versions[i-1]
+}
+
+// diffVersions lists the fields that differ between two versions, standard
+// fields first then custom fields in order.
+func diffVersions(before types.Entry, after types.Entry) []fieldChange {
+	var changes []fieldChange
+
+	add := func(name string, beforeValue string, afterValue string, protected bool) {
+		if beforeValue != afterValue {
+			changes = append(changes, fieldChange{name: name, before: beforeValue, after: afterValue, protected: protected})
+		}
+	}
+
+	add("Title", before.Title, after.Title, false)
+	add("Username", before.Username, after.Username, false)
+	add("Password", before.Password, after.Password, true)
+	add("URL", before.URL, after.URL, false)
+	add("Notes", before.Notes, after.Notes, false)
+	add("Tags", strings.Join(before.Tags, ", "), strings.Join(after.Tags, ", "), false)
+
+	var keys []string
+
+	fields := map[string][2]types.Field{}
+
+	for i, version := range []types.Entry{before, after} {
+		for _, field := range version.Fields {
+			if _, ok := fields[field.Key]; !ok {
+				keys = append(keys, field.Key)
+			}
+
+			pair := fields[field.Key]
+			pair[i] = field
+			fields[field.Key] = pair
+		}
+	}
+
+	for _, key := range keys {
+		pair := fields[key]
+		add(key, pair[0].Value, pair[1].Value, pair[0].Protected || pair[1].Protected)
+	}
+
+	return changes
+}
+
+// displayValue shortens a value to its first line, or hides it, showing
+// whether it is empty.
+func displayValue(value string, hidden bool) string {
+	switch {
+	case value == "":
+		return "(empty)"
+	case hidden:
+		return strings.Repeat("*", 12)
+	default:
+		return matchedValue(value)
+	}
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/style"
+)
+
+// DatabaseInfo is what the info screen shows of one database.
+type DatabaseInfo struct {
+	Database types.Database
+	Info     keepass.Info
+	// Locked is set when only the header of the database was read
+	Locked bool
+}
+
+// InfoModel handles the database info screen, describing how databases are
+// encrypted and what they hold.
+type InfoModel struct {
+	databases []DatabaseInfo
+}
+
+// NewInfoModel creates a new info model.
+func NewInfoModel(databases []DatabaseInfo) *InfoModel {
+	return &InfoModel{
+		databases: databases,
+	}
+}
+
+// Update implements tea.Model.
+func (m *InfoModel) Update(_ tea.Msg) (*InfoModel, tea.Cmd) {
+	return m, nil
+}
+
+// View implements tea.Model.
+func (m *InfoModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(style.ViewTitle.Render("Database Info") + "\n\n")
+
+	for _, database := range m.databases {
+		info := database.Info
+
+		b.WriteString(fmt.Sprintf("%s (%s)\n", database.Database.Name, database.Database.Path))
+		b.WriteString(fmt.Sprintf("  Format:      KDBX %s\n", info.Version))
+		b.WriteString(fmt.Sprintf("  Cipher:      %s\n", info.Cipher))
+		b.WriteString(fmt.Sprintf("  Compression: %s\n", info.Compression))
+		b.WriteString(fmt.Sprintf("  KDF:         %s\n", info.KDF))
+
+		if database.Locked {
+			b.WriteString("  Unlock the database to see its name, recycle bin and contents.\n\n")
+
+			continue
+		}
+
+		if info.Name != "" {
+			b.WriteString(fmt.Sprintf("  Name:        %s\n", info.Name))
+		}
+
+		if info.Description != "" {
+			b.WriteString(fmt.Sprintf("  Description: %s\n", matchedValue(info.Description)))
+		}
+
+		if !info.MasterKeyChanged.IsZero() {
+			b.WriteString(fmt.Sprintf("  Master key:  changed %s\n", info.MasterKeyChanged.Local().Format("2006-01-02 15:04:05")))
+		}
+
+		recycleBin := "disabled"
+		if info.RecycleBin {
+			recycleBin = "enabled"
+			if info.RecycleBinGroup != "" {
+				recycleBin += ", in " + info.RecycleBinGroup
+			}
+		}
+
+		b.WriteString(fmt.Sprintf("  Recycle bin: %s\n", recycleBin))
+		b.WriteString(fmt.Sprintf("  Contents:    %d entries in %d groups\n\n", info.Entries, info.Groups))
+	}
+
+	// Footer
+	footer := "[Esc] Back"
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
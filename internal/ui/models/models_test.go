@@ -1,7 +1,14 @@
 package models
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,9 +16,16 @@ import (
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	passagent "github.com/martinlehoux/kagapass/internal/agent"
 	"github.com/martinlehoux/kagapass/internal/clipboard"
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/sshagent"
 	"github.com/martinlehoux/kagapass/internal/testor"
+	"github.com/martinlehoux/kagapass/internal/totp"
 	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/tobischo/gokeepasslib/v3"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 var unlockDatabase = &UnlockDatabase{
@@ -28,7 +42,7 @@ func TestFileSelectModelWithDatabases(t *testing.T) {
 		LastUsed: "/path/to/test1.kdbx",
 	}
 
-	model := NewFileSelectModel(dbList, unlockDatabase)
+	model := NewFileSelectModel(dbList, nil, FileSelectActions{})
 	if len(model.databases.Databases) != 2 {
 		t.Errorf("Expected 2 databases, got %d", len(model.databases.Databases))
 	}
@@ -43,7 +57,7 @@ func TestFileSelectModelNavigation(t *testing.T) {
 		},
 	}
 
-	model := NewFileSelectModel(dbList, unlockDatabase)
+	model := NewFileSelectModel(dbList, nil, FileSelectActions{})
 
 	// Test down navigation
 	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyDown})
@@ -72,7 +86,7 @@ func TestFileSelectModelNavigation(t *testing.T) {
 }
 
 func TestFileSelectModelInputMode(t *testing.T) {
-	model := NewFileSelectModel(types.DatabaseList{}, unlockDatabase)
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{})
 
 	// Enter input mode
 	model, _ = model.Update(testor.KeyMsgRune('a'))
@@ -112,7 +126,7 @@ func TestFileSelectModelInputMode(t *testing.T) {
 }
 
 func TestFileSelectModelView(t *testing.T) {
-	model := NewFileSelectModel(types.DatabaseList{}, unlockDatabase)
+	model := NewFileSelectModel(types.DatabaseList{}, nil, FileSelectActions{})
 
 	view := model.View()
 	if view == "" {
@@ -130,7 +144,7 @@ func TestFileSelectModelView(t *testing.T) {
 		},
 	}
 
-	model = NewFileSelectModel(dbList, unlockDatabase)
+	model = NewFileSelectModel(dbList, nil, FileSelectActions{})
 	view = model.View()
 
 	if !strings.Contains(view, "test.kdbx") {
@@ -139,11 +153,11 @@ func TestFileSelectModelView(t *testing.T) {
 }
 
 func TestSearchModelSearch(t *testing.T) {
-	model := NewSearchModel(clipboard.New(), []types.Entry{
+	model := NewSearchModel(clipboard.New(), types.DefaultConfig(), []types.Entry{
 		{Title: "GitHub Personal", Username: "user1"},
 		{Title: "Gmail", Username: "user2"},
 		{Title: "GitHub Work", Username: "user3"},
-	}, func(entry types.Entry) {}, "test")
+	}, []string{"test"}, SearchActions{})
 
 	// Search for "github"
 	model.searchInput = "github"
@@ -171,11 +185,11 @@ func TestSearchModelSearch(t *testing.T) {
 }
 
 func TestSearchModelNavigation(t *testing.T) {
-	model := NewSearchModel(clipboard.New(), []types.Entry{
+	model := NewSearchModel(clipboard.New(), types.DefaultConfig(), []types.Entry{
 		{Title: "Entry1", Username: "user1"},
 		{Title: "Entry2", Username: "user2"},
 		{Title: "Entry3", Username: "user3"},
-	}, func(entry types.Entry) {}, "")
+	}, nil, SearchActions{})
 
 	model.searchInput = "entry"
 	model.search()
@@ -206,7 +220,7 @@ func TestDetailsModelView(t *testing.T) {
 		Notes:    "Test notes",
 		Group:    "Test/Group",
 	}
-	model := NewDetailsModel(clipboard.New(), entry)
+	model := NewDetailsModel(clipboard.New(), types.DefaultConfig(), entry, DetailsActions{})
 
 	// Test view with entry
 	view := model.View()
@@ -231,7 +245,7 @@ func TestDetailsModelView(t *testing.T) {
 
 func TestPasswordModelInput(t *testing.T) {
 	model := &PasswordModel{
-		unlockDatabase: unlockDatabase,
+		unlock: unlockDatabase.Handle,
 	}
 
 	// Type password
@@ -262,7 +276,7 @@ func TestPasswordModelView(t *testing.T) {
 		Name: "test.kdbx",
 		Path: "/path/to/test.kdbx",
 	}
-	model := NewPasswordModel(unlockDatabase, func() {}, db)
+	model := NewPasswordModel(unlockDatabase.Handle, func() {}, db)
 
 	view := model.View()
 	if !strings.Contains(view, "Enter Master Password") {
@@ -408,62 +422,1277 @@ func TestSessionPersistence(t *testing.T) {
 }
 
 func TestDatabaseUnlockFlow(t *testing.T) {
-	// Create temporary directory for testing
-	tmpDir, err := os.MkdirTemp("", "kagapass-unlock-test-")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
+	// Keep the configuration, secret store and agents of the user out of the test
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("KAGAPASS_AGENT_SOCK", filepath.Join(home, "agent.sock"))
+
+	configDir := filepath.Join(home, ".config", "kagapass")
+	if err := os.MkdirAll(configDir, 0o700); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
 	}
-	defer os.RemoveAll(tmpDir)
 
-	// Override home directory for testing
-	originalHome := os.Getenv("HOME")
-	os.Setenv("HOME", tmpDir)
-	defer os.Setenv("HOME", originalHome)
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"secret_store": "memory", "ssh_agent": "off"}`), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	writeEmptyDatabase(t, home)
 
-	// Create app model
 	app, err := NewAppModel()
 	if err != nil {
 		t.Fatalf("Failed to create app model: %v", err)
 	}
+	defer app.Close()
 
-	// Create a database entry (simulating a real KeePass file)
-	// db := types.Database{
-	// 	Name:         "real.kdbx",
-	// 	Path:         "/nonexistent/path/real.kdbx",
-	// 	LastAccessed: time.Now(),
-	// }
-
-	// Step 1: Simulate TryKeyringUnlockMsg (what happens when you press Enter on a database)
-	// tryUnlockMsg := TryKeyringUnlockMsg{Database: &db}
-	app1, cmd1 := app.Update(testor.KeyMsgRune('a')) //	TODO
-
-	if cmd1 == nil {
-		t.Error("Expected command from TryKeyringUnlockMsg, got nil")
-		return
+	// Without a stored password, opening a database asks for it
+	cmd := app.openDatabases([]types.Database{{Name: "real.kdbx", Path: filepath.Join(home, "test.kdbx")}})
+	if cmd == nil {
+		t.Fatal("Expected opening the database to unlock it")
 	}
 
-	// Execute the command
-	msg1 := cmd1()
-
-	// Step 2: Handle the resulting message (should be SwitchScreenMsg to password screen)
-	app2, cmd2 := app1.Update(msg1)
+	app.Update(cmd())
 
-	// Verify we switched to password input screen
-	view := app2.View()
+	view := app.View()
 	if !strings.Contains(view, "Enter Master Password") {
 		t.Error("Expected to switch to password input screen")
 	}
+
 	if !strings.Contains(view, "real.kdbx") {
 		t.Error("Expected password screen to show database name")
 	}
 
-	// The unlock flow is working correctly:
-	// 1. TryKeyringUnlockMsg received
-	// 2. No stored password found, switch to password screen
-	// 3. Password screen displayed correctly
+	for _, key := range "secret" {
+		app.Update(testor.KeyMsgRune(key))
+	}
+
+	_, cmd = app.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatal("Expected Enter to unlock the database")
+	}
+
+	app.Update(cmd())
+
+	if app.screen != MainSearchScreen {
+		t.Errorf("Expected the search screen once unlocked, got %d: %s", app.screen, app.View())
+	}
+}
+
+func TestGeneratorModelUsePassword(t *testing.T) {
+	var used string
+
+	model := NewGeneratorModel(clipboard.New(), types.DefaultConfig(), func(password string) {
+		used = password
+	})
+
+	if model.password == "" {
+		t.Fatal("Expected a password to be generated on open")
+	}
+
+	first := model.password
+
+	// Moving to another policy regenerates the password
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyDown})
+	if model.cursor != 1 {
+		t.Errorf("Expected cursor at 1, got %d", model.cursor)
+	}
+
+	if model.password == first {
+		t.Error("Expected a new password after changing policy")
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if used != model.password {
+		t.Errorf("Expected generated password '%s' to be used, got '%s'", model.password, used)
+	}
+}
+
+func TestDetailsModelCustomFields(t *testing.T) {
+	entry := types.Entry{
+		Title: "AWS",
+		Fields: []types.Field{
+			{Key: "Account ID", Value: "123456789012"},
+			{Key: "API Key", Value: "AKIASECRET", Protected: true},
+		},
+	}
+	model := NewDetailsModel(clipboard.New(), types.DefaultConfig(), entry, DetailsActions{})
+
+	view := model.View()
+	if !strings.Contains(view, "Account ID: 123456789012") {
+		t.Error("Expected view to contain unprotected custom field")
+	}
+
+	if strings.Contains(view, "AKIASECRET") {
+		t.Error("Protected custom field should be masked")
+	}
+
+	// Select the second field
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	if model.fieldCursor != 1 {
+		t.Errorf("Expected field cursor at 1, got %d", model.fieldCursor)
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	if model.fieldCursor != 1 {
+		t.Errorf("Expected field cursor to stay at 1, got %d", model.fieldCursor)
+	}
+
+	// Revealing shows protected fields
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyCtrlP})
+	if !strings.Contains(model.View(), "AKIASECRET") {
+		t.Error("Expected protected custom field to be revealed")
+	}
+}
+
+func TestDetailsModelTOTPBehindEdit(t *testing.T) {
+	code, err := totp.ParseURI("otpauth://totp/GitHub?secret=JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("ParseURI() failed: %v", err)
+	}
+
+	app := &AppModel{screen: MainSearchScreen, now: time.Now}
+	app.switchEntryDetailsScreen(types.Entry{Title: "GitHub", TOTP: &code})
+
+	tick := totpTickMsg{details: app.detailsModel}
+
+	// The edit screen opened from the details keeps the countdown going
+	app.screen = EntryEditScreen
+	app.editReturnScreen = EntryDetailsScreen
+
+	if _, cmd := app.Update(tick); cmd == nil {
+		t.Error("Expected the countdown to go on behind the edit screen")
+	}
+
+	app.screen = MainSearchScreen
+
+	if _, cmd := app.Update(tick); cmd != nil {
+		t.Error("Expected the countdown to stop once the details are left")
+	}
+}
+
+func TestFileSelectModelOpenMarkedDatabases(t *testing.T) {
+	dbList := types.DatabaseList{
+		Databases: []types.Database{
+			{Name: "personal.kdbx", Path: "/path/to/personal.kdbx"},
+			{Name: "work.kdbx", Path: "/path/to/work.kdbx"},
+			{Name: "shared.kdbx", Path: "/path/to/shared.kdbx"},
+		},
+	}
+
+	var opened []types.Database
+
+	model := NewFileSelectModel(dbList, nil, FileSelectActions{OpenDatabases: func(databases []types.Database) tea.Cmd {
+		opened = databases
+
+		return nil
+	}})
+
+	// Without marks, the database under the cursor is opened
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if len(opened) != 1 || opened[0].Name != "personal.kdbx" {
+		t.Errorf("Expected only personal.kdbx to be opened, got %+v", opened)
+	}
+
+	// Mark shared then personal, they open in list order
+	model.cursor = 2
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}})
+	model.cursor = 0
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}})
+
+	if !strings.Contains(model.View(), "[x] shared.kdbx") {
+		t.Error("Expected view to show shared.kdbx as marked")
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if len(opened) != 2 || opened[0].Name != "personal.kdbx" || opened[1].Name != "shared.kdbx" {
+		t.Errorf("Expected personal.kdbx and shared.kdbx to be opened, got %+v", opened)
+	}
+}
+
+func TestSearchModelMultipleDatabases(t *testing.T) {
+	personal := types.Database{Name: "personal.kdbx", Path: "/path/to/personal.kdbx"}
+	work := types.Database{Name: "work.kdbx", Path: "/path/to/work.kdbx"}
+
+	var created types.Entry
+
+	model := NewSearchModel(clipboard.New(), types.DefaultConfig(), []types.Entry{
+		{Title: "GitHub Personal", Database: personal},
+		{Title: "GitHub Work", Database: work},
+	}, []string{"personal.kdbx", "work.kdbx"}, SearchActions{EditEntry: func(entry types.Entry, isNew bool) { created = entry }})
+
+	model.searchInput = "github work"
+	model.search()
+
+	view := model.View()
+	if !strings.Contains(view, "KagaPass - personal.kdbx, work.kdbx") {
+		t.Error("Expected title to list all unlocked databases")
+	}
+
+	if !strings.Contains(view, "[work.kdbx]") {
+		t.Error("Expected result to be tagged with its source database")
+	}
+
+	// New entries go to the database of the selected result
+	model.Update(tea.KeyMsg{Type: tea.KeyCtrlN})
+	if created.Database != work {
+		t.Errorf("Expected new entry in work.kdbx, got %+v", created.Database)
+	}
+}
+
+func testGroupTree() (types.Database, types.Group) {
+	database := types.Database{Name: "personal.kdbx", Path: "/path/to/personal.kdbx"}
+	root := types.Group{
+		UUID: gokeepasslib.NewUUID(), Name: "Root", Path: "", EntryCount: 1,
+		Groups: []types.Group{
+			{
+				UUID: gokeepasslib.NewUUID(), Name: "Work", Path: "Work", EntryCount: 1,
+				Groups: []types.Group{{UUID: gokeepasslib.NewUUID(), Name: "Infra", Path: "Work/Infra", EntryCount: 2}},
+			},
+			{UUID: gokeepasslib.NewUUID(), Name: "Personal", Path: "Personal", IconID: 60},
+		},
+	}
+
+	return database, root
+}
+
+func TestGroupTreeModel(t *testing.T) {
+	database, root := testGroupTree()
+
+	var selected types.Group
+
+	model := NewGroupTreeModel([]GroupRoot{{Database: database, Group: root}}, func(database types.Database, group types.Group) {
+		selected = group
+	})
+
+	// Root is expanded, its subgroups collapsed
+	if len(model.rows) != 3 {
+		t.Fatalf("Expected 3 visible groups, got %d", len(model.rows))
+	}
+
+	view := model.View()
+	if !strings.Contains(view, "Root") || !strings.Contains(view, "(4)") || !strings.Contains(view, "🏠") {
+		t.Errorf("Expected view to show groups with counts and icons, got:\n%s", view)
+	}
+
+	// Expand Work
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyDown})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRight})
+
+	if len(model.rows) != 4 || model.rows[2].group.Name != "Infra" {
+		t.Fatalf("Expected Infra to be visible after expanding Work, got %d rows", len(model.rows))
+	}
+
+	// Moving right again enters the subgroup, left goes back to its parent
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRight})
+	if model.cursor != 2 {
+		t.Errorf("Expected cursor on Infra, got %d", model.cursor)
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyLeft})
+	if model.cursor != 1 {
+		t.Errorf("Expected cursor back on Work, got %d", model.cursor)
+	}
+
+	// Collapse Work
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyLeft})
+	if len(model.rows) != 3 {
+		t.Errorf("Expected Work to be collapsed, got %d rows", len(model.rows))
+	}
+
+	model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	if selected.Path != "Work" {
+		t.Errorf("Expected Work to be selected, got '%s'", selected.Path)
+	}
+}
+
+func TestSearchModelScope(t *testing.T) {
+	database, root := testGroupTree()
+	work := root.Groups[0]
+	infra := work.Groups[0]
+
+	var created types.Entry
+
+	model := NewSearchModel(clipboard.New(), types.DefaultConfig(), []types.Entry{
+		{Title: "Bank", Group: "", GroupUUID: root.UUID, Database: database},
+		{Title: "Jira", Group: "Work", GroupUUID: work.UUID, Database: database},
+		{Title: "AWS", Group: "Work/Infra", GroupUUID: infra.UUID, Database: database},
+		{Title: "Azure", Group: "Work/Infra", GroupUUID: infra.UUID, Database: database},
+	}, nil, SearchActions{EditEntry: func(entry types.Entry, isNew bool) { created = entry }})
+
+	model.SetScope(database, work)
+
+	// An empty search lists the whole subtree
+	if len(model.filteredItems) != 3 {
+		t.Fatalf("Expected 3 entries in Work, got %d", len(model.filteredItems))
+	}
+
+	if !strings.Contains(model.View(), "Search in Work:") {
+		t.Error("Expected search label to show the group")
+	}
+
+	model, _ = model.Update(testor.KeyMsgRune('a'))
+
+	for _, match := range model.filteredItems {
+		if model.entries[match.Index].Title == "Bank" {
+			t.Error("Expected entries outside of the group to be excluded")
+		}
+	}
+
+	model.Update(tea.KeyMsg{Type: tea.KeyCtrlN})
+
+	if created.Group != "Work" {
+		t.Errorf("Expected new entry in Work, got '%s'", created.Group)
+	}
+
+	// Backspace on an empty search leaves the group
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+
+	if model.scope != nil {
+		t.Error("Expected scope to be cleared")
+	}
+}
+
+func TestSearchModelDebounce(t *testing.T) {
+	config := types.DefaultConfig()
+	config.SearchDebounceMs = 100
+
+	model := NewSearchModel(clipboard.New(), config, []types.Entry{
+		{Title: "GitHub"},
+		{Title: "Gmail"},
+	}, nil, SearchActions{})
+
+	model, first := model.Update(testor.KeyMsgRune('g'))
+	model, second := model.Update(testor.KeyMsgRune('h'))
+
+	if first == nil || second == nil {
+		t.Fatal("Expected typing to schedule a search")
+	}
+
+	if len(model.filteredItems) != 0 {
+		t.Error("Expected no search before the debounce delay")
+	}
+
+	// The tick of the first keystroke is superseded by the second one
+	model, _ = model.Update(searchDebounceMsg{search: model, seq: 1})
+	if len(model.filteredItems) != 0 {
+		t.Error("Expected the outdated tick to be ignored")
+	}
+
+	model, _ = model.Update(searchDebounceMsg{search: model, seq: 2})
+	if len(model.filteredItems) != 1 {
+		t.Errorf("Expected 1 result for 'gh', got %d", len(model.filteredItems))
+	}
+
+	// Acting on the results runs the pending search first
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyDown})
+
+	if len(model.filteredItems) != 2 || model.cursor != 1 {
+		t.Errorf("Expected the pending search for 'g' to run, got %d results", len(model.filteredItems))
+	}
+}
+
+func TestSearchModelMaxResults(t *testing.T) {
+	config := types.DefaultConfig()
+	config.MaxSearchResults = 2
+
+	model := NewSearchModel(clipboard.New(), config, []types.Entry{
+		{Title: "Entry1"},
+		{Title: "Entry2"},
+		{Title: "Entry3"},
+	}, nil, SearchActions{})
+
+	model.searchInput = "entry"
+	model.search()
+
+	view := model.View()
+	if strings.Contains(view, "Entry3") || !strings.Contains(view, "and 1 more") {
+		t.Errorf("Expected 2 results shown out of 3, got:\n%s", view)
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyDown})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyDown})
+
+	if model.cursor != 1 {
+		t.Errorf("Expected cursor to stop on the last shown result, got %d", model.cursor)
+	}
+}
+
+func TestPasswordModelKeyFile(t *testing.T) {
+	model := NewPasswordModel(unlockDatabase.Handle, func() {}, types.Database{Name: "infra.kdbx", Path: "/path/to/infra.kdbx"})
+
+	// Nothing to unlock with yet
+	_, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd != nil {
+		t.Error("Expected no unlock without password nor key file")
+	}
+
+	// Typing goes to the key file once focused
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	for _, r := range "infra.key" {
+		model, _ = model.Update(testor.KeyMsgRune(r))
+	}
+
+	if model.password != "" || model.keyFile.Value() != "infra.key" {
+		t.Errorf("Expected key file 'infra.key' and no password, got '%s' and '%s'", model.keyFile.Value(), model.password)
+	}
+
+	// A key file alone is enough to try unlocking
+	model, cmd = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil || model.database.KeyFile != "infra.key" {
+		t.Error("Expected unlock with the key file")
+	}
+
+	if !strings.Contains(model.View(), "Key File:") {
+		t.Error("Expected view to show the key file input")
+	}
+}
+
+type memorySecretStore map[string][]byte
+
+func (m memorySecretStore) Store(key string, secret []byte) error {
+	m[key] = secret
+
+	return nil
+}
+
+func (m memorySecretStore) Get(key string) ([]byte, error) {
+	secret, ok := m[key]
+	if !ok {
+		return nil, errors.New("secret not found")
+	}
+
+	return secret, nil
+}
+
+func (m memorySecretStore) Remove(key string) error {
+	delete(m, key)
+
+	return nil
+}
+
+func TestUnlockDatabaseSessionTimeout(t *testing.T) {
+	secretStore := memorySecretStore{"/path/to/old.kdbx": []byte("secret"), "/path/to/new.kdbx": []byte("secret")}
+	unlock := NewUnlockDatabase(nil, secretStore, time.Hour)
+
+	now := time.Now()
+	old := types.Database{Name: "old.kdbx", Path: "/path/to/old.kdbx", SecretStoredAt: now.Add(-2 * time.Hour)}
+	recent := types.Database{Name: "new.kdbx", Path: "/path/to/new.kdbx", SecretStoredAt: now.Add(-time.Minute)}
+
+	msg := unlock.ExpireSecrets([]types.Database{old, recent}, now)()
+
+	expired, ok := msg.(SecretsExpired)
+	if !ok || len(expired.Databases) != 1 || expired.Databases[0].Path != old.Path {
+		t.Fatalf("Expected only old.kdbx to expire, got %+v", msg)
+	}
+
+	if _, ok := secretStore[old.Path]; ok {
+		t.Error("Expected expired secret to be removed")
+	}
+
+	if _, ok := secretStore[recent.Path]; !ok {
+		t.Error("Expected recent secret to be kept")
+	}
+
+	// A secret stored at an unknown time is used, and counts from now
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	unlock = NewUnlockDatabase(keepass.NewLoader(keepass.DirFS(dir)), secretStore, time.Hour)
+	secretStore["test.kdbx"] = []byte("secret")
+
+	if msg := unlock.ExpireSecrets([]types.Database{{Name: "test.kdbx", Path: "test.kdbx"}}, now); msg != nil {
+		t.Error("Expected secret without storage time not to expire")
+	}
+
+	msg = unlock.Handle(types.Database{Name: "test.kdbx", Path: "test.kdbx"}, nil)()
+
+	unlocked, ok := msg.(DatabaseUnlocked)
+	if !ok {
+		t.Fatalf("Expected unlock with the stored password, got %+v", msg)
+	}
+
+	unlocked.Vault.Close()
+
+	if unlocked.Database.SecretStoredAt.Before(now) {
+		t.Errorf("Expected the storage time to be recorded, got %v", unlocked.Database.SecretStoredAt)
+	}
+}
+
+func TestIdleLock(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	unlock := NewUnlockDatabase(keepass.NewLoader(keepass.DirFS(dir)), nil, 0)
+	now := time.Now()
+	app := &AppModel{
+		screen:         FileSelectionScreen,
+		config:         types.Config{IdleLockMinutes: 5},
+		unlockDatabase: unlock,
+		lastActivity:   now,
+		now:            func() time.Time { return now },
+	}
+	app.fileSelector = NewFileSelectModel(app.databases, nil, FileSelectActions{OpenDatabases: app.openDatabases})
+
+	msg := unlock.Handle(types.Database{Name: "test.kdbx", Path: "test.kdbx"}, []byte("secret"))()
+	app.Update(msg)
+
+	if app.screen != MainSearchScreen {
+		t.Fatalf("Expected search screen after unlock, got %d", app.screen)
+	}
+
+	// Activity postpones the lock
+	now = now.Add(4 * time.Minute)
+	app.Update(tea.KeyMsg{Type: tea.KeyDown})
+
+	now = now.Add(4 * time.Minute)
+	app.Update(lockCheckMsg{})
+
+	if app.screen != MainSearchScreen {
+		t.Fatal("Expected databases to stay unlocked while active")
+	}
+
+	now = now.Add(2 * time.Minute)
+	app.Update(lockCheckMsg{})
+
+	if app.screen != FileSelectionScreen || len(app.unlocked) != 0 || app.searchModel != nil {
+		t.Error("Expected databases to be locked and entries dropped after 5 idle minutes")
+	}
+
+	if !strings.Contains(app.View(), "Locked after 5 minutes of inactivity") {
+		t.Error("Expected lock reason to be shown")
+	}
+}
+
+func TestUnlockDatabaseAgent(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	loader := keepass.NewLoader(keepass.DirFS(dir))
+	socket := filepath.Join(dir, "agent", "agent.sock")
+
+	listener, err := passagent.Listen(socket)
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+
+	served := make(chan error)
+	go func() {
+		served <- passagent.NewServer(loader, 0).Serve(listener)
+	}()
+
+	stopped := false
+	stop := func() {
+		if !stopped {
+			stopped = true
+
+			listener.Close()
+			<-served
+		}
+	}
+	t.Cleanup(stop)
+
+	client, err := passagent.Dial(socket)
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+
+	secretStore := memorySecretStore{}
+	unlock := NewUnlockDatabase(loader, secretStore, 0)
+	unlock.UseAgent(client)
+
+	test := types.Database{Name: "test.kdbx", Path: "test.kdbx"}
+
+	if _, ok := unlock.Handle(test, []byte("wrong"))().(DatabaseUnlockFailed); !ok {
+		t.Error("Expected a wrong password to fail in the agent")
+	}
+
+	// Databases unlocked with a password go to the agent instead of the secret store
+	unlocked, ok := unlock.Handle(test, []byte("secret"))().(DatabaseUnlocked)
+	if !ok {
+		t.Fatal("Expected database to unlock with its password")
+	}
+
+	if _, inAgent := unlocked.Vault.(*passagent.Vault); !inAgent || unlocked.KeePass != nil {
+		t.Error("Expected the database to be read from the agent, not decrypted in memory")
+	}
+
+	if len(secretStore) != 0 {
+		t.Error("Expected nothing in the secret store while using the agent")
+	}
+
+	// Writes go through the agent, which serves them to scripts
+	entry := types.Entry{Title: "GitHub", Username: "octocat", Password: "hunter2"} //nolint:exhaustruct // Only the fields to save
+	if _, _, err := unlocked.Vault.SaveEntry(entry, true); err != nil {
+		t.Fatalf("SaveEntry() failed: %v", err)
+	}
+
+	if value, err := client.Get("test.kdbx", "GitHub", ""); err != nil || value != "hunter2" {
+		t.Errorf("Expected the agent to serve the saved entry, got '%s', %v", value, err)
+	}
+
+	// Without a password, the entries come from the agent
+	unlocked, ok = unlock.Handle(test, nil)().(DatabaseUnlocked)
+	if !ok || len(unlocked.Entries) != 2 || unlocked.Entries[1].Password != "hunter2" {
+		t.Errorf("Expected database to unlock from the agent, got %+v", unlocked)
+	}
+
+	// The agent keeps the new master key, a password stored before is stale
+	secretStore[test.Path] = []byte("secret")
+	kdf := keepass.KDF{Type: keepass.AESKDF, Rounds: 1000, Iterations: 0, Memory: 0, Parallelism: 0}
+
+	if msg := unlock.ChangeMasterKey(test, unlocked.Vault, keepass.PasswordCredentials([]byte("changed")), kdf)(); msg != (MasterKeyChanged{Database: test}) {
+		t.Fatalf("Expected the master key to change in the agent, got %+v", msg)
+	}
+
+	if len(secretStore) != 0 {
+		t.Error("Expected the stored password to be removed once the agent changed the master key")
+	}
+
+	if err := client.Lock(test.Path); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+
+	msg := unlock.Handle(test, nil)()
+	if failed, ok := msg.(DatabaseUnlockFailed); !ok || !errors.Is(failed.Error, ErrNoStoredPassword) {
+		t.Errorf("Expected unlock to require a password once locked in the agent, got %+v", msg)
+	}
+
+	// Once the agent stopped, databases are decrypted in memory again
+	stop()
+
+	unlocked, ok = unlock.Handle(test, []byte("changed"))().(DatabaseUnlocked)
+	if !ok || unlocked.KeePass == nil {
+		t.Fatalf("Expected database to unlock in memory without the agent, got %+v", unlocked)
+	}
+
+	unlocked.Vault.Close()
+}
+
+// writeEmptyDatabase writes test.kdbx, protected by the password "secret".
+// checkChanges runs the change check of the app, returning the reload it
+// starts.
+func checkChanges(app *AppModel) tea.Cmd {
+	cmd := app.checkChanges()
+	if cmd == nil {
+		return nil
+	}
+
+	_, reload := app.Update(cmd())
+
+	return reload
+}
+
+func writeEmptyDatabase(t *testing.T, dir string) {
+	t.Helper()
+
+	testor.WriteDatabase(t, filepath.Join(dir, "test.kdbx"), testor.NewDatabase(gokeepasslib.NewPasswordCredentials("secret")))
+}
+
+func TestStaleUnlockDropped(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	unlock := NewUnlockDatabase(keepass.NewLoader(keepass.DirFS(dir)), nil, 0)
+	app := &AppModel{screen: FileSelectionScreen, unlockDatabase: unlock, now: time.Now}
+	app.fileSelector = NewFileSelectModel(app.databases, nil, app.fileSelectActions())
+	app.switchPasswordInputScreen(types.Database{Name: "test.kdbx", Path: "test.kdbx"})
+
+	for _, key := range "secret" {
+		app.Update(testor.KeyMsgRune(key))
+	}
+
+	_, cmd := app.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatal("Expected Enter to unlock the database")
+	}
+
+	// Going back to the file selection while the key is derived
+	app.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	app.Update(cmd())
+
+	if app.screen != FileSelectionScreen || len(app.unlocked) != 0 || app.searchModel != nil {
+		t.Errorf("Expected the unlock to be dropped, got screen %d with %d databases", app.screen, len(app.unlocked))
+	}
+
+	// Unlocks started afterwards still open
+	app.switchPasswordInputScreen(types.Database{Name: "test.kdbx", Path: "test.kdbx"})
+
+	for _, key := range "secret" {
+		app.Update(testor.KeyMsgRune(key))
+	}
+
+	_, cmd = app.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	app.Update(cmd())
+
+	if app.screen != MainSearchScreen || len(app.unlocked) != 1 {
+		t.Errorf("Expected the database to be unlocked, got screen %d with %d databases", app.screen, len(app.unlocked))
+	}
+}
+
+func TestDatabaseReload(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	loader := keepass.NewLoader(keepass.DirFS(dir))
+
+	// addEntries saves the entries as another program would
+	addEntries := func(titles ...string) {
+		other, err := loader.Load("test.kdbx", keepass.PasswordCredentials([]byte("secret")))
+		if err != nil {
+			t.Fatalf("Load() failed: %v", err)
+		}
+
+		for _, title := range titles {
+			if _, err := other.CreateEntry(types.Entry{Title: title}); err != nil {
+				t.Fatalf("CreateEntry() failed: %v", err)
+			}
+		}
+
+		if err := other.Save(); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+	}
+
+	addEntries("GitHub", "GitLab", "Bank")
+
+	unlock := NewUnlockDatabase(loader, nil, 0)
+	app := &AppModel{
+		screen:         FileSelectionScreen,
+		unlockDatabase: unlock,
+		now:            time.Now,
+	}
+	app.fileSelector = NewFileSelectModel(app.databases, nil, FileSelectActions{OpenDatabases: app.openDatabases})
+
+	app.Update(unlock.Handle(types.Database{Name: "test.kdbx", Path: "test.kdbx"}, []byte("secret"))())
+
+	for _, key := range "git" {
+		app.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{key}})
+	}
+
+	app.Update(tea.KeyMsg{Type: tea.KeyDown})
+
+	selected := app.searchModel.entries[app.searchModel.filteredItems[app.searchModel.cursor].Index].Title
+
+	if cmd := checkChanges(app); cmd != nil {
+		t.Fatal("Expected no reload before the file changes")
+	}
+
+	addEntries("Gitea")
+
+	cmd := checkChanges(app)
+	if cmd == nil {
+		t.Fatal("Expected the changed file to be reloaded")
+	}
+
+	app.Update(cmd())
+
+	if app.searchModel.searchInput != "git" {
+		t.Errorf("Expected the query to be kept, got '%s'", app.searchModel.searchInput)
+	}
+
+	if len(app.searchModel.filteredItems) != 3 {
+		t.Errorf("Expected the synced entry in the results, got %d results", len(app.searchModel.filteredItems))
+	}
+
+	if title := app.searchModel.entries[app.searchModel.filteredItems[app.searchModel.cursor].Index].Title; title != selected {
+		t.Errorf("Expected the cursor to stay on %s, got %s", selected, title)
+	}
+
+	if !strings.Contains(app.View(), "Reloaded test.kdbx after an external change") {
+		t.Error("Expected the reload to be shown")
+	}
+
+	// A file that no longer decrypts is reported, and not retried until it changes again
+	if err := os.WriteFile(filepath.Join(dir, "test.kdbx"), []byte("corrupted"), 0o600); err != nil {
+		t.Fatalf("Failed to corrupt database file: %v", err)
+	}
+
+	app.Update(checkChanges(app)())
+
+	if !strings.Contains(app.View(), "Failed to reload test.kdbx") {
+		t.Error("Expected the failed reload to be shown")
+	}
+
+	if cmd := checkChanges(app); cmd != nil {
+		t.Error("Expected a failed reload not to be retried")
+	}
+
+	if len(app.searchModel.filteredItems) != 3 {
+		t.Error("Expected the entries to be kept after a failed reload")
+	}
+
+	// A failing check is logged once, not every few seconds
+	if err := os.Remove(filepath.Join(dir, "test.kdbx")); err != nil {
+		t.Fatalf("Failed to remove database file: %v", err)
+	}
+
+	var logged bytes.Buffer
+
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for range 3 {
+		if cmd := checkChanges(app); cmd != nil {
+			t.Error("Expected no reload of a missing file")
+		}
+	}
+
+	if lines := strings.Count(logged.String(), "failed to check test.kdbx"); lines != 1 {
+		t.Errorf("Expected the failed check to be logged once, got %d times", lines)
+	}
+}
+
+func TestDatabaseReloadWhileEditing(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	loader := keepass.NewLoader(keepass.DirFS(dir))
+
+	// addEntry saves the entry as another program would
+	addEntry := func(entry types.Entry) {
+		other, err := loader.Load("test.kdbx", keepass.PasswordCredentials([]byte("secret")))
+		if err != nil {
+			t.Fatalf("Load() failed: %v", err)
+		}
+
+		if _, err := other.CreateEntry(entry); err != nil {
+			t.Fatalf("CreateEntry() failed: %v", err)
+		}
+
+		if err := other.Save(); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+	}
+
+	addEntry(types.Entry{Title: "GitHub", Password: "hunter2"})
+	addEntry(types.Entry{Title: "Bank", Password: "1234"})
+
+	unlock := NewUnlockDatabase(loader, nil, 0)
+	app := &AppModel{screen: FileSelectionScreen, unlockDatabase: unlock, now: time.Now}
+	app.fileSelector = NewFileSelectModel(app.databases, nil, FileSelectActions{OpenDatabases: app.openDatabases})
+	app.Update(unlock.Handle(types.Database{Name: "test.kdbx", Path: "test.kdbx"}, []byte("secret"))())
+
+	for _, key := range "github" {
+		app.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{key}})
+	}
+
+	app.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	app.Update(tea.KeyMsg{Type: tea.KeyCtrlE})
+
+	if app.screen != EntryEditScreen {
+		t.Fatalf("Expected the edit screen, got %d", app.screen)
+	}
+
+	// The database is reloaded under the open edit form
+	addEntry(types.Entry{Title: "GitLab", Password: "glpat"})
+	app.Update(checkChanges(app)())
+
+	app.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'!'}})
+
+	_, cmd := app.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	if cmd == nil {
+		t.Fatal("Expected Ctrl+S to save the entry")
+	}
+
+	app.Update(cmd())
+
+	if app.screen != EntryDetailsScreen {
+		t.Fatalf("Expected the details screen after saving, got %d: %s", app.screen, app.View())
+	}
+
+	saved, err := loader.Load("test.kdbx", keepass.PasswordCredentials([]byte("secret")))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	entries, _ := saved.Entries()
+
+	passwords := map[string]string{}
+	for _, entry := range entries {
+		passwords[entry.Title] = entry.Password
+	}
+
+	if passwords["GitHub!"] != "hunter2" || passwords["Bank"] != "1234" || passwords["GitLab"] != "glpat" {
+		t.Errorf("Expected the edit saved over the reload with intact passwords, got %v", passwords)
+	}
+}
+
+func TestEntryHistory(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	loader := keepass.NewLoader(keepass.DirFS(dir))
+
+	database, err := loader.Load("test.kdbx", keepass.PasswordCredentials([]byte("secret")))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	entry, _ := database.CreateEntry(types.Entry{Title: "GitHub", Username: "octocat", Password: "first"})
+
+	// Versions are told apart by their modification time, stored in seconds
+	older := entry.Raw.Times.LastModificationTime.Time.Add(-time.Hour)
+	entry.Raw.Times.LastModificationTime.Time = older
+
+	entry.Password = "second"
+	entry.Username = "hubot"
+	_, _ = database.UpdateEntry(entry)
+
+	if err := database.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	unlock := NewUnlockDatabase(loader, nil, 0)
+	app := &AppModel{screen: FileSelectionScreen, unlockDatabase: unlock, now: time.Now}
+	app.fileSelector = NewFileSelectModel(app.databases, nil, FileSelectActions{OpenDatabases: app.openDatabases})
+	app.Update(unlock.Handle(types.Database{Name: "test.kdbx", Path: "test.kdbx"}, []byte("secret"))())
+
+	for _, key := range "git" {
+		app.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{key}})
+	}
+
+	app.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	app.Update(tea.KeyMsg{Type: tea.KeyCtrlO})
+
+	if app.screen != EntryHistoryScreen {
+		t.Fatalf("Expected the history screen, got %d", app.screen)
+	}
+
+	view := app.View()
+	if !strings.Contains(view, "Username, Password changed") || !strings.Contains(view, "octocat → hubot") {
+		t.Errorf("Expected the changes of the previous version, got:\n%s", view)
+	}
+
+	if strings.Contains(view, "first") {
+		t.Error("Expected passwords to be masked")
+	}
+
+	app.Update(tea.KeyMsg{Type: tea.KeyCtrlP})
+
+	if !strings.Contains(app.View(), "first → second") {
+		t.Error("Expected revealed passwords in the diff")
+	}
+
+	// Restoring asks for confirmation
+	_, cmd := app.Update(tea.KeyMsg{Type: tea.KeyCtrlR})
+	if cmd != nil {
+		t.Fatal("Expected the first Ctrl+R to ask for confirmation")
+	}
+
+	_, cmd = app.Update(tea.KeyMsg{Type: tea.KeyCtrlR})
+	if cmd == nil {
+		t.Fatal("Expected the second Ctrl+R to restore the version")
+	}
+
+	app.Update(cmd())
+
+	if app.screen != EntryDetailsScreen {
+		t.Fatalf("Expected the details screen after restoring, got %d", app.screen)
+	}
+
+	restored := app.detailsModel.entry
+	if restored.Password != "first" || restored.Username != "octocat" {
+		t.Errorf("Expected the previous version to be restored, got %+v", restored)
+	}
+
+	if versions := keepass.History(restored); len(versions) != 2 || versions[0].Password != "second" {
+		t.Errorf("Expected the replaced version in history, got %d versions", len(versions))
+	}
+}
+
+func TestEntryTextPrintable(t *testing.T) {
+	entry := types.Entry{
+		Title:    "Git\x1b]0;hacked\x07Hub",
+		Username: "octo\x1b[2Jcat",
+		URL:      "https://github.com\x1b[8m",
+		Group:    "Work\r",
+		Notes:    "first\r\n\x1b]52;c;aGFja2Vk\x07\tsecond",
+		Fields:   []types.Field{{Key: "IP\x1b[5m", Value: "10.0.0.1\x1b[0m"}},
+	}
+
+	details := NewDetailsModel(clipboard.New(), types.DefaultConfig(), entry, DetailsActions{})
+	if view := details.View(); strings.ContainsAny(view, "\x1b\x07\r") || !strings.Contains(view, "\uFFFD]52;c;aGFja2Vk\uFFFD\tsecond") {
+		t.Errorf("Expected control characters but tabs to be replaced in the details, got %q", view)
+	}
+
+	search := NewSearchModel(clipboard.New(), types.DefaultConfig(), []types.Entry{entry}, []string{"test"}, SearchActions{})
+	search.searchInput = "octo"
+	search.search()
+
+	if view := search.View(); len(search.filteredItems) != 1 || strings.ContainsAny(view, "\x1b\x07\r") {
+		t.Errorf("Expected control characters to be replaced in the results, got %q", view)
+	}
+}
+
+func TestDetailsModelAttachments(t *testing.T) {
+	entry := types.Entry{
+		Title:  "Server",
+		Fields: []types.Field{{Key: "IP", Value: "10.0.0.1"}},
+		Attachments: []types.Attachment{
+			{Name: "id_ed25519.pub", Size: 2048},
+			{Name: "recovery.pdf", Size: 3},
+		},
+	}
+	contents := map[string][]byte{
+		"id_ed25519.pub": []byte("ssh-ed25519 AAAA octocat\r\n\x1b]52;c;aGFja2Vk\x07\tcomment\n"),
+		"recovery.pdf":   {0x25, 0x00, 0xff},
+	}
+	readAttachment := func(entry types.Entry, name string) ([]byte, error) {
+		return contents[name], nil
+	}
+	model := NewDetailsModel(clipboard.New(), types.DefaultConfig(), entry, DetailsActions{ReadAttachment: readAttachment})
+
+	if !strings.Contains(model.View(), "id_ed25519.pub (2.0 KiB)") {
+		t.Error("Expected attachments to be listed with their size")
+	}
+
+	// Attachments are selected after the custom fields
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	if !strings.Contains(model.View(), "ssh-ed25519 AAAA octocat") {
+		t.Error("Expected the text attachment to be previewed")
+	}
+
+	if strings.ContainsAny(model.View(), "\x1b\x07\r") || !strings.Contains(model.View(), "\uFFFD]52;c;aGFja2Vk\uFFFD\tcomment") {
+		t.Errorf("Expected control characters but tabs to be replaced in the preview, got %q", model.View())
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyTab})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	if !strings.Contains(model.View(), "recovery.pdf is not a text file") {
+		t.Error("Expected binary attachments not to be previewed")
+	}
+
+	// Saving asks for a path, then writes the file for the user only
+	t.Setenv("ATTACHMENTS", t.TempDir())
+	path := filepath.Join(os.Getenv("ATTACHMENTS"), "recovery.pdf")
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	model.savePath.SetValue("$ATTACHMENTS/recovery.pdf")
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the attachment to be saved: %v", err)
+	}
+
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	if model.savePath.Focused() {
+		t.Error("Expected the path input to close after saving")
+	}
+
+	// Existing files are not overwritten
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	model.savePath.SetValue(path)
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	if !strings.Contains(model.View(), "Failed to save recovery.pdf") {
+		t.Error("Expected saving over an existing file to fail")
+	}
+
+	if !model.cancelSave() || model.savePath.Focused() {
+		t.Error("Expected escape to cancel saving")
+	}
+}
+
+func TestSSHKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	settings := `<EntrySettings><AllowUseOfSshKey>true</AllowUseOfSshKey><Location>` +
+		`<SelectedType>attachment</SelectedType><AttachmentName>id_ed25519</AttachmentName></Location></EntrySettings>`
+
+	database := testor.NewDatabase(gokeepasslib.NewPasswordCredentials("secret"))
+	entry := gokeepasslib.NewEntry()
+	entry.Values = append(entry.Values, gokeepasslib.ValueData{Key: "Title", Value: gokeepasslib.V{Content: "GitHub"}})
+	entry.Binaries = append(entry.Binaries,
+		database.AddBinary([]byte(settings)).CreateReference(sshagent.SettingsAttachment),
+		database.AddBinary(pem.EncodeToMemory(block)).CreateReference("id_ed25519"),
+	)
+	database.Content.Root.Groups[0].Entries = append(database.Content.Root.Groups[0].Entries, entry)
+
+	testor.WriteDatabase(t, filepath.Join(dir, "test.kdbx"), database)
+
+	sshAgent, err := sshagent.Serve(filepath.Join(dir, "ssh", "agent.sock"))
+	if err != nil {
+		t.Fatalf("Serve() failed: %v", err)
+	}
+
+	unlock := NewUnlockDatabase(keepass.NewLoader(keepass.DirFS(dir)), nil, 0)
+	app := &AppModel{screen: FileSelectionScreen, unlockDatabase: unlock, sshAgent: sshAgent, now: time.Now}
+	app.fileSelector = NewFileSelectModel(app.databases, nil, FileSelectActions{OpenDatabases: app.openDatabases})
+
+	msg := unlock.Handle(types.Database{Name: "test.kdbx", Path: "test.kdbx"}, []byte("secret"))()
+	app.Update(msg)
+
+	unlocked := msg.(DatabaseUnlocked)
+	app.Update(app.addSSHKeys(unlocked.Database, unlocked.Vault, unlocked.Entries)())
+
+	if !strings.Contains(app.View(), "Added 1 SSH keys of test.kdbx to the agent") {
+		t.Errorf("Expected the added key to be shown, got:\n%s", app.View())
+	}
+
+	conn, err := net.Dial("unix", sshAgent.Socket())
+	if err != nil {
+		t.Fatalf("Failed to connect to the agent: %v", err)
+	}
+	defer conn.Close()
+
+	client := agent.NewClient(conn)
+
+	if count := listSSHKeys(t, client); count != 1 {
+		t.Fatalf("Expected the key in the agent, got %d keys", count)
+	}
+
+	app.lock("Locked")
+
+	if count := listSSHKeys(t, client); count != 0 {
+		t.Errorf("Expected the key to be removed on lock, got %d keys", count)
+	}
+
+	app.Close()
+}
+
+func listSSHKeys(t *testing.T, client agent.Agent) int {
+	t.Helper()
+
+	keys, err := client.List()
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+
+	return len(keys)
+}
+
+func TestDatabaseInfo(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	loader := keepass.NewLoader(keepass.DirFS(dir))
+	app := &AppModel{
+		screen:         FileSelectionScreen,
+		databases:      types.DatabaseList{Databases: []types.Database{{Name: "test.kdbx", Path: "test.kdbx"}}},
+		keepassLoader:  loader,
+		unlockDatabase: NewUnlockDatabase(loader, nil, 0),
+		now:            time.Now,
+	}
+	app.fileSelector = NewFileSelectModel(app.databases, nil, FileSelectActions{OpenDatabases: app.openDatabases, ShowInfo: app.switchDatabaseHeaderScreen})
+
+	// Locked databases only show their header
+	app.Update(testor.KeyMsgRune('i'))
+
+	if app.screen != DatabaseInfoScreen {
+		t.Fatalf("Expected the info screen, got %d", app.screen)
+	}
+
+	view := app.View()
+	if !strings.Contains(view, "Argon2d, 2 iterations, 1 MiB, 2 threads") || !strings.Contains(view, "Unlock the database") {
+		t.Errorf("Expected the header of the locked database, got %q", view)
+	}
+
+	app.Update(tea.KeyMsg{Type: tea.KeyEsc})
+
+	if app.screen != FileSelectionScreen {
+		t.Fatalf("Expected Esc to go back to the file selection, got %d", app.screen)
+	}
+
+	app.Update(app.unlockDatabase.Handle(app.databases.Databases[0], []byte("secret"))())
+	app.Update(tea.KeyMsg{Type: tea.KeyCtrlD})
+
+	if app.screen != DatabaseInfoScreen || !strings.Contains(app.View(), "1 entries in 0 groups") {
+		t.Errorf("Expected the info of the unlocked database, got %q", app.View())
+	}
+
+	app.Update(tea.KeyMsg{Type: tea.KeyEsc})
+
+	if app.screen != MainSearchScreen {
+		t.Errorf("Expected Esc to go back to the search, got %d", app.screen)
+	}
+}
+
+func TestDatabaseSettings(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptyDatabase(t, dir)
+
+	secretStore := memorySecretStore{}
+	loader := keepass.NewLoader(keepass.DirFS(dir))
+	app := &AppModel{
+		screen:         FileSelectionScreen,
+		databases:      types.DatabaseList{Databases: []types.Database{{Name: "test.kdbx", Path: "test.kdbx"}}},
+		keepassLoader:  loader,
+		unlockDatabase: NewUnlockDatabase(loader, secretStore, 0),
+		now:            time.Now,
+	}
+
+	app.Update(app.unlockDatabase.Handle(app.databases.Databases[0], []byte("secret"))())
+	app.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+
+	if app.screen != DatabaseSettingsScreen || !strings.Contains(app.View(), "now Argon2d, 2 iterations, 1 MiB, 2 threads") {
+		t.Fatalf("Expected the settings of the database, got %q", app.View())
+	}
+
+	typeText := func(text string) {
+		for _, r := range text {
+			app.Update(testor.KeyMsgRune(r))
+		}
+	}
+
+	typeText("changed")
+	app.Update(tea.KeyMsg{Type: tea.KeyTab})
+	typeText("typo")
+
+	if _, cmd := app.Update(tea.KeyMsg{Type: tea.KeyCtrlS}); cmd != nil || !strings.Contains(app.View(), "Passwords do not match") {
+		t.Fatalf("Expected mismatching passwords to be refused, got %q", app.View())
+	}
+
+	app.settingsModel.inputs[settingsConfirmField].SetValue("changed")
+
+	// Switching to AES-KDF hides the Argon2 settings, the benchmark tunes its rounds
+	app.Update(tea.KeyMsg{Type: tea.KeyCtrlK})
+
+	if strings.Contains(app.View(), "Memory") || !strings.Contains(app.View(), "Rounds") {
+		t.Errorf("Expected the AES-KDF settings, got %q", app.View())
+	}
+
+	app.settingsModel.inputs[settingsUnlockTimeField].SetValue("10")
+
+	_, cmd := app.Update(tea.KeyMsg{Type: tea.KeyCtrlB})
+	app.Update(cmd())
+
+	if rounds := app.settingsModel.inputs[settingsCostField].Value(); rounds == "6000000" || !strings.Contains(app.View(), "Tuned to AES-KDF") {
+		t.Errorf("Expected the rounds to be tuned, got %s in %q", rounds, app.View())
+	}
+
+	_, cmd = app.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	app.Update(cmd())
+
+	if app.screen != MainSearchScreen || !strings.Contains(app.View(), "Master key of test.kdbx changed") {
+		t.Fatalf("Expected to go back to the search, got %q", app.View())
+	}
+
+	if string(secretStore["test.kdbx"]) != "changed" {
+		t.Errorf("Expected the new password to be stored, got %q", secretStore["test.kdbx"])
+	}
+
+	reloaded, err := loader.Load("test.kdbx", keepass.PasswordCredentials([]byte("changed")))
+	if err != nil {
+		t.Fatalf("Expected the new password to unlock the database: %v", err)
+	}
 
-	// Verify cmd2 is nil (no further commands after screen switch)
-	if cmd2 != nil {
-		t.Error("Expected no command after switching to password screen")
+	if kdf := reloaded.Info().KDF; kdf.Type != keepass.AESKDF {
+		t.Errorf("Expected AES-KDF, got %v", kdf)
 	}
 }
@@ -4,8 +4,10 @@ import (
 	"log"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/keepass"
 	"github.com/martinlehoux/kagapass/internal/types"
 	"github.com/martinlehoux/kagapass/internal/ui/status"
 	"github.com/martinlehoux/kagapass/internal/ui/style"
@@ -14,21 +16,29 @@ import (
 // PasswordModel handles the password input screen.
 type PasswordModel struct {
 	// Commands
-	unlockDatabase *UnlockDatabase
-	exit           func()
+	unlock func(database types.Database, password []byte) tea.Cmd
+	exit   func()
 
 	database types.Database
 	password string
+	keyFile  textinput.Model
 	status   status.Status
 }
 
-func NewPasswordModel(unlockDatabase *UnlockDatabase, exit func(), database types.Database) *PasswordModel {
+func NewPasswordModel(unlock func(database types.Database, password []byte) tea.Cmd, exit func(), database types.Database) *PasswordModel {
+	keyFile := textinput.New()
+	keyFile.Prompt = ""
+	keyFile.Placeholder = "(none)"
+	keyFile.Width = 40
+	keyFile.SetValue(database.KeyFile)
+
 	return &PasswordModel{
-		unlockDatabase: unlockDatabase,
-		exit:           exit,
-		database:       database,
-		password:       "",
-		status:         status.Status{},
+		unlock:   unlock,
+		exit:     exit,
+		database: database,
+		password: "",
+		keyFile:  keyFile,
+		status:   status.Status{},
 	}
 }
 
@@ -38,9 +48,27 @@ func (m *PasswordModel) Update(msg tea.Msg) (*PasswordModel, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "enter":
-			if m.password != "" {
-				return m, m.unlockDatabase.Handle(m.database, []byte(m.password))
+			return m.submit()
+		case "tab", "shift+tab":
+			// Switch between the password and the key file
+			if m.keyFile.Focused() {
+				m.keyFile.Blur()
+			} else {
+				m.keyFile.Focus()
 			}
+
+			return m, nil
+		}
+
+		if m.keyFile.Focused() {
+			var cmd tea.Cmd
+
+			m.keyFile, cmd = m.keyFile.Update(msg)
+
+			return m, cmd
+		}
+
+		switch msg.String() {
 		case "esc":
 			m.exit()
 		case "backspace":
@@ -57,11 +85,30 @@ func (m *PasswordModel) Update(msg tea.Msg) (*PasswordModel, tea.Cmd) {
 		}
 	case DatabaseUnlockFailed:
 		log.Printf("Failed to unlock database: %s", msg.Error)
+		m.status = status.Error("Failed to unlock database")
 	}
 
 	return m, nil
 }
 
+// submit unlocks with the typed password and key file, either of which may
+// be empty but not both.
+func (m *PasswordModel) submit() (*PasswordModel, tea.Cmd) {
+	m.database.KeyFile = strings.TrimSpace(m.keyFile.Value())
+	if m.password == "" && m.database.KeyFile == "" {
+		return m, nil
+	}
+
+	// A missing key file is reported by the unlock
+	if path, err := keepass.CanonicalPath(m.database.KeyFile); err == nil {
+		m.database.KeyFile = path
+	}
+
+	m.status = status.Status{}
+
+	return m, m.unlock(m.database, []byte(m.password))
+}
+
 // View implements tea.Model.
 func (m *PasswordModel) View() string {
 	var b strings.Builder
@@ -93,10 +140,23 @@ func (m *PasswordModel) View() string {
 		Padding(0, 1).
 		Width(30)
 
+	if m.keyFile.Focused() {
+		inputStyle = inputStyle.Foreground(lipgloss.Color("#626262"))
+	}
+
 	b.WriteString(inputStyle.Render(maskedPassword) + "\n\n")
 
+	// Key file input
+	keyFileLabel := "Key File:"
+	if m.keyFile.Focused() {
+		keyFileLabel = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Render(keyFileLabel)
+	}
+
+	b.WriteString(keyFileLabel + "\n")
+	b.WriteString(m.keyFile.View() + "\n\n")
+
 	// Footer
-	footer := "[Enter] Unlock  [Esc] Back  [Ctrl+L] Clear"
+	footer := "[Enter] Unlock  [Tab] Password/Key file  [Esc] Back  [Ctrl+L] Clear"
 	b.WriteString(lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#626262")).
 		Render(footer))
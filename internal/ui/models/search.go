@@ -8,51 +8,98 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/martinlehoux/kagapass/internal/clipboard"
+	"github.com/martinlehoux/kagapass/internal/search"
 	"github.com/martinlehoux/kagapass/internal/types"
 	"github.com/martinlehoux/kagapass/internal/ui/status"
 	"github.com/martinlehoux/kagapass/internal/ui/style"
-	"github.com/sahilm/fuzzy"
+	"github.com/tobischo/gokeepasslib/v3"
 )
 
+// SearchActions are what the search screen asks the application to do.
+type SearchActions struct {
+	ViewDetails      func(entry types.Entry) tea.Cmd
+	EditEntry        func(entry types.Entry, isNew bool)
+	GeneratePassword func()
+	BrowseGroups     func()
+	ShowInfo         func()
+	DatabaseSettings func(database types.Database)
+}
+
 // SearchModel handles the main search interface.
 type SearchModel struct {
+	actions SearchActions
+
 	searchInput      string
 	entries          []types.Entry
-	filteredItems    []fuzzy.Match
+	filteredItems    []search.Match
 	cursor           int
 	clipboardManager *clipboard.Clipboard
+	config           types.Config
 	status           status.Status
-	dbName           string
+	// searchSeq identifies the last keystroke, only its debounce tick searches
+	searchSeq     int
+	searchPending bool
+	// dbNames lists the unlocked databases, results are tagged with their
+	// source when there are several
+	dbNames []string
+	// scope restricts the search to a group and its subgroups, when set
+	scope *searchScope
+}
 
-	// Actions
-	viewDetails func(entry types.Entry)
+// searchScope is a group subtree the search is restricted to.
+type searchScope struct {
+	database types.Database
+	group    types.Group
+	groups   map[gokeepasslib.UUID]bool
 }
 
 // NewSearchModel creates a new search model.
-func NewSearchModel(clipboard *clipboard.Clipboard, entries []types.Entry, viewDetails func(entry types.Entry), dbName string) *SearchModel {
+func NewSearchModel(
+	clipboard *clipboard.Clipboard,
+	config types.Config,
+	entries []types.Entry,
+	dbNames []string,
+	actions SearchActions,
+) *SearchModel {
 	return &SearchModel{
+		actions:          actions,
 		clipboardManager: clipboard,
+		config:           config,
 		entries:          entries,
-		dbName:           dbName,
+		dbNames:          dbNames,
 		searchInput:      "",
 		cursor:           0,
-		viewDetails:      viewDetails,
-		filteredItems:    []fuzzy.Match{},
+		scope:            nil,
+		filteredItems:    []search.Match{},
 		status:           status.Status{},
+		searchSeq:        0,
+		searchPending:    false,
 	}
 }
 
+// searchDebounceMsg runs the search typed before it, unless the search model
+// that scheduled it has been typed in since.
+type searchDebounceMsg struct {
+	search *SearchModel
+	seq    int
+}
+
 // Update implements tea.Model.
 func (m *SearchModel) Update(msg tea.Msg) (*SearchModel, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		// Act on the results of everything typed so far
+		if !editsSearch(msg.String()) {
+			m.flushSearch()
+		}
+
 		switch msg.String() {
 		case "up", "k":
 			if m.cursor > 0 {
 				m.cursor--
 			}
 		case "down", "j":
-			if m.cursor < len(m.filteredItems)-1 {
+			if m.cursor < m.visibleResults()-1 {
 				m.cursor++
 			}
 		case "enter":
@@ -60,7 +107,8 @@ func (m *SearchModel) Update(msg tea.Msg) (*SearchModel, tea.Cmd) {
 				entryIndex := m.filteredItems[m.cursor].Index
 				if entryIndex < len(m.entries) {
 					entry := m.entries[entryIndex]
-					m.viewDetails(entry)
+
+					return m, m.actions.ViewDetails(entry)
 				}
 			}
 		case "ctrl+b":
@@ -68,16 +116,7 @@ func (m *SearchModel) Update(msg tea.Msg) (*SearchModel, tea.Cmd) {
 				entryIndex := m.filteredItems[m.cursor].Index
 				if entryIndex < len(m.entries) {
 					entry := m.entries[entryIndex]
-					if m.clipboardManager != nil && entry.Username != "" {
-						err := m.clipboardManager.Copy(entry.Username, 30*time.Second)
-						if err != nil {
-							m.status = status.Error("Failed to copy username")
-						} else {
-							m.status = status.Success("Username copied to clipboard (will clear in 30s)")
-						}
-					} else {
-						m.status = status.Error("No username to copy")
-					}
+					m.status = copyToClipboard(m.clipboardManager, m.config, "username", entry.Username)
 				}
 			}
 		case "ctrl+c":
@@ -85,46 +124,139 @@ func (m *SearchModel) Update(msg tea.Msg) (*SearchModel, tea.Cmd) {
 				entryIndex := m.filteredItems[m.cursor].Index
 				if entryIndex < len(m.entries) {
 					entry := m.entries[entryIndex]
-					if m.clipboardManager != nil && entry.Password != "" {
-						err := m.clipboardManager.Copy(entry.Password, 30*time.Second)
-						if err != nil {
-							m.status = status.Error("Failed to copy password")
-						} else {
-							m.status = status.Success("Password copied to clipboard (will clear in 30s)")
-						}
-					} else {
-						m.status = status.Error("No password to copy")
-					}
+					m.status = copyToClipboard(m.clipboardManager, m.config, "password", entry.Password)
+				}
+			}
+		case "ctrl+t":
+			if len(m.filteredItems) > 0 && m.cursor < len(m.filteredItems) {
+				entryIndex := m.filteredItems[m.cursor].Index
+				if entryIndex < len(m.entries) {
+					m.status = copyTOTPCode(m.clipboardManager, m.entries[entryIndex])
+				}
+			}
+		case "ctrl+e":
+			if len(m.filteredItems) > 0 && m.cursor < len(m.filteredItems) {
+				entryIndex := m.filteredItems[m.cursor].Index
+				if entryIndex < len(m.entries) {
+					m.actions.EditEntry(m.entries[entryIndex], false)
+				}
+			}
+		case "ctrl+n":
+			entry := types.Entry{} //nolint:exhaustruct // New entries start blank
+			// New entries go to the database of the selected result, if any
+			if len(m.filteredItems) > 0 && m.cursor < len(m.filteredItems) {
+				entryIndex := m.filteredItems[m.cursor].Index
+				if entryIndex < len(m.entries) {
+					entry.Database = m.entries[entryIndex].Database
+				}
+			}
+
+			// and to the group the search is restricted to
+			if m.scope != nil {
+				entry.Database = m.scope.database
+				entry.Group = m.scope.group.Path
+			}
+
+			m.actions.EditEntry(entry, true)
+		case "ctrl+g":
+			m.actions.GeneratePassword()
+		case "ctrl+o":
+			m.actions.BrowseGroups()
+		case "ctrl+d":
+			m.actions.ShowInfo()
+		case "ctrl+s":
+			// Settings of the database of the selected result, if any
+			database := types.Database{} //nolint:exhaustruct // The first unlocked database
+			if len(m.filteredItems) > 0 && m.cursor < len(m.filteredItems) {
+				entryIndex := m.filteredItems[m.cursor].Index
+				if entryIndex < len(m.entries) {
+					database = m.entries[entryIndex].Database
 				}
 			}
+
+			m.actions.DatabaseSettings(database)
 		case "ctrl+l":
 			m.searchInput = ""
-			m.search()
+
+			return m, m.scheduleSearch()
 		case "backspace":
 			if len(m.searchInput) > 0 {
 				m.searchInput = m.searchInput[:len(m.searchInput)-1]
+
+				return m, m.scheduleSearch()
+			} else if m.scope != nil {
+				// Leave the group on an empty search
+				m.scope = nil
 				m.search()
 			}
 		default:
 			// Handle regular typing
 			if len(msg.String()) == 1 {
 				m.searchInput += msg.String()
-				m.search()
+
+				return m, m.scheduleSearch()
 			}
 		}
+	case searchDebounceMsg:
+		// Ticks of a previous search screen or keystroke are dropped
+		if msg.search == m && msg.seq == m.searchSeq && m.searchPending {
+			m.search()
+		}
 	}
 
 	return m, nil
 }
 
+// editsSearch reports whether the key changes the search input rather than
+// acting on the results.
+func editsSearch(key string) bool {
+	return key == "backspace" || key == "ctrl+l" || (len(key) == 1 && key != "j" && key != "k")
+}
+
+// scheduleSearch searches once no key has been typed for the configured
+// debounce delay, or right away without one.
+func (m *SearchModel) scheduleSearch() tea.Cmd {
+	debounce := time.Duration(m.config.SearchDebounceMs) * time.Millisecond
+	if debounce <= 0 {
+		m.search()
+
+		return nil
+	}
+
+	m.searchSeq++
+	m.searchPending = true
+	seq := m.searchSeq
+
+	return tea.Tick(debounce, func(time.Time) tea.Msg {
+		return searchDebounceMsg{search: m, seq: seq}
+	})
+}
+
+// flushSearch runs a search still waiting for its debounce delay.
+func (m *SearchModel) flushSearch() {
+	if m.searchPending {
+		m.search()
+	}
+}
+
+// visibleResults returns how many results are shown, at most the configured
+// maximum.
+func (m *SearchModel) visibleResults() int {
+	if m.config.MaxSearchResults > 0 {
+		return min(len(m.filteredItems), m.config.MaxSearchResults)
+	}
+
+	return len(m.filteredItems)
+}
+
 // View implements tea.Model.
 func (m *SearchModel) View() string {
 	var b strings.Builder
 
 	// Header
 	titleText := "KagaPass"
-	if m.dbName != "" {
-		titleText += " - " + m.dbName
+	if len(m.dbNames) > 0 {
+		titleText += " - " + strings.Join(m.dbNames, ", ")
 	}
 
 	b.WriteString(style.ViewTitle.Render(titleText) + "\n\n")
@@ -134,29 +266,29 @@ func (m *SearchModel) View() string {
 
 	// Search input
 	searchLabel := "Search: "
+	if m.scope != nil {
+		searchLabel = "Search in " + printable(m.scopeName()) + ": "
+	}
+
 	searchValue := m.searchInput + "_" // Add cursor
 	b.WriteString(searchLabel + searchValue + "\n")
 	b.WriteString(strings.Repeat("─", 60) + "\n\n")
 
 	// Results
-	maxResults := 10
-
 	if len(m.entries) == 0 {
 		b.WriteString("No entries in database.\n")
 		b.WriteString("Make sure the database was unlocked successfully.\n")
 	} else if len(m.filteredItems) == 0 {
-		if m.searchInput == "" {
+		if m.scope != nil && m.searchInput == "" {
+			b.WriteString("No entries in this group.\n")
+		} else if m.searchInput == "" {
 			totalEntries := len(m.entries)
 			b.WriteString(fmt.Sprintf("Database contains %d entries. Start typing to search...\n", totalEntries))
 		} else {
 			b.WriteString("No entries found matching your search.\n")
 		}
 	} else {
-		for i, match := range m.filteredItems {
-			if i >= maxResults {
-				break
-			}
-
+		for i, match := range m.filteredItems[:m.visibleResults()] {
 			cursor := " "
 			if m.cursor == i {
 				cursor = "▶"
@@ -166,38 +298,49 @@ func (m *SearchModel) View() string {
 				entry := m.entries[match.Index]
 
 				// Format entry display
-				title := entry.Title
+				title := printable(entry.Title)
 				if title == "" {
 					title = "(No Title)"
 				}
 
 				line := fmt.Sprintf("  %s %s", cursor, title)
+				groupStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#626262"))
+
+				if m.cursor == i {
+					titleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4"))
+					line = fmt.Sprintf("  %s %s", cursor, titleStyle.Render(title))
+					groupStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#9B9B9B"))
+				}
 
 				// Add group path if it exists
 				if entry.Group != "" {
-					groupStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#626262"))
-					line += " " + groupStyle.Render(fmt.Sprintf("(%s)", entry.Group))
+					line += " " + groupStyle.Render(fmt.Sprintf("(%s)", printable(entry.Group)))
 				}
 
-				if m.cursor == i {
-					titleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4"))
-					line = fmt.Sprintf("  %s %s", cursor, titleStyle.Render(title))
+				// Add source database when searching several
+				if len(m.dbNames) > 1 {
+					line += " " + groupStyle.Render(fmt.Sprintf("[%s]", printable(entry.Database.Name)))
+				}
 
-					if entry.Group != "" {
-						groupStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#9B9B9B"))
-						line += " " + groupStyle.Render(fmt.Sprintf("(%s)", entry.Group))
-					}
+				// Add the field that matched when it is not the title
+				if match.Field != search.TitleField {
+					line += " " + groupStyle.Render(fmt.Sprintf("· %s: %s", match.Field, matchedValue(match.Value)))
 				}
 
 				b.WriteString(line + "\n")
 			}
 		}
+
+		if hidden := len(m.filteredItems) - m.visibleResults(); hidden > 0 {
+			b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#626262")).
+				Render(fmt.Sprintf("  … and %d more, refine your search", hidden)) + "\n")
+		}
 	}
 
 	b.WriteString("\n")
 
 	// Footer
-	footer := "[Ctrl+B] Copy User  [Ctrl+C] Copy Pass  [Enter] Details  [Esc] Files"
+	footer := "[Ctrl+B] Copy User  [Ctrl+C] Copy Pass  [Ctrl+T] Copy TOTP  [Enter] Details  [Ctrl+E] Edit  [Ctrl+N] New  [Ctrl+G] Generate  [Ctrl+O] Groups  [Ctrl+D] Info  [Ctrl+S] Settings  [Esc] Files"
 	b.WriteString(lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#626262")).
 		Render(footer))
@@ -205,23 +348,107 @@ func (m *SearchModel) View() string {
 	return b.String()
 }
 
+// SetEntries replaces the searched entries and re-runs the current search,
+// keeping the cursor on the selected entry when it is still listed.
+func (m *SearchModel) SetEntries(entries []types.Entry) {
+	var selected *types.Entry
+
+	if m.cursor < len(m.filteredItems) {
+		entry := m.entries[m.filteredItems[m.cursor].Index]
+		selected = &entry
+	}
+
+	cursor := m.cursor
+	m.entries = entries
+	m.search()
+
+	if selected != nil && selected.Raw.UUID != (gokeepasslib.UUID{}) {
+		for i, match := range m.filteredItems[:m.visibleResults()] {
+			entry := m.entries[match.Index]
+			if entry.Raw.UUID == selected.Raw.UUID && entry.Database.Path == selected.Database.Path {
+				m.cursor = i
+
+				return
+			}
+		}
+	}
+
+	m.cursor = min(cursor, max(m.visibleResults()-1, 0))
+}
+
+// SetScope restricts the search to the group and its subgroups, listing all
+// their entries until something is typed.
+func (m *SearchModel) SetScope(database types.Database, group types.Group) {
+	groups := map[gokeepasslib.UUID]bool{}
+	for _, child := range group.All() {
+		groups[child.UUID] = true
+	}
+
+	m.scope = &searchScope{database: database, group: group, groups: groups}
+	m.searchInput = ""
+	m.search()
+}
+
+func (m *SearchModel) scopeName() string {
+	if m.scope.group.Path == "" {
+		return m.scope.database.Name
+	}
+
+	return m.scope.group.Path
+}
+
 // search performs fuzzy search on entries.
 func (m *SearchModel) search() {
-	if m.searchInput == "" {
-		m.filteredItems = []fuzzy.Match{}
-		m.cursor = 0
+	m.cursor = 0
+	m.searchPending = false
+
+	if m.scope == nil {
+		m.filteredItems = search.Find(m.searchInput, m.entries)
 
 		return
 	}
 
-	// Create search targets
-	targets := make([]string, len(m.entries))
+	// Search the entries of the scope, then map matches back to all entries
+	var (
+		entries []types.Entry
+		indexes []int
+	)
+
 	for i, entry := range m.entries {
-		targets[i] = entry.Title
+		if entry.Database.Path == m.scope.database.Path && m.scope.groups[entry.GroupUUID] {
+			entries = append(entries, entry)
+			indexes = append(indexes, i)
+		}
+	}
+
+	var matches []search.Match
+
+	if strings.TrimSpace(m.searchInput) == "" {
+		for i, entry := range entries {
+			matches = append(matches, search.Match{Index: i, Field: search.TitleField, Value: entry.Title, Score: 0})
+		}
+	} else {
+		matches = search.Find(m.searchInput, entries)
+	}
+
+	for i := range matches {
+		matches[i].Index = indexes[matches[i].Index]
 	}
 
-	// Perform fuzzy search
-	matches := fuzzy.Find(m.searchInput, targets)
 	m.filteredItems = matches
-	m.cursor = 0
+}
+
+// matchedValue shortens a matched value to its first line.
+func matchedValue(value string) string {
+	const maxLength = 40
+
+	value, _, multiline := strings.Cut(value, "\n")
+	value = printable(strings.TrimSuffix(value, "\r"))
+	if runes := []rune(value); len(runes) > maxLength {
+		return string(runes[:maxLength-1]) + "…"
+	} else if multiline {
+		return value + "…"
+	}
+
+	return value
 }
@@ -0,0 +1,370 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/martinlehoux/kagapass/internal/ui/status"
+	"github.com/martinlehoux/kagapass/internal/ui/style"
+)
+
+const (
+	settingsPasswordField = iota
+	settingsConfirmField
+	settingsKeyFileField
+	// settingsCostField holds the rounds of AES-KDF or the iterations of Argon2
+	settingsCostField
+	settingsMemoryField
+	settingsParallelismField
+	settingsUnlockTimeField
+	settingsFieldCount
+)
+
+// defaultUnlockTime is the unlock time the benchmark targets by default, as in
+// KeePassXC.
+const defaultUnlockTime = time.Second
+
+// SettingsModel handles the database settings screen, changing the master key
+// and the KDF settings of an unlocked database.
+type SettingsModel struct {
+	// Commands
+	save      func(credentials keepass.Credentials, kdf keepass.KDF) tea.Cmd
+	benchmark func(kdf keepass.KDF, target time.Duration) tea.Cmd
+
+	database types.Database
+	header   keepass.Header
+	kdfType  string
+	inputs   [settingsFieldCount]textinput.Model
+	focus    int
+	status   status.Status
+}
+
+// NewSettingsModel creates a settings model for the database, starting from
+// its current key file and KDF settings.
+func NewSettingsModel(
+	save func(credentials keepass.Credentials, kdf keepass.KDF) tea.Cmd,
+	benchmark func(kdf keepass.KDF, target time.Duration) tea.Cmd,
+	database types.Database,
+	header keepass.Header,
+) *SettingsModel {
+	m := &SettingsModel{
+		save:      save,
+		benchmark: benchmark,
+		database:  database,
+		header:    header,
+		kdfType:   "",
+		inputs:    [settingsFieldCount]textinput.Model{},
+		focus:     settingsPasswordField,
+		status:    status.Status{},
+	}
+
+	for i := range m.inputs {
+		m.inputs[i] = textinput.New()
+		m.inputs[i].Prompt = ""
+		m.inputs[i].Width = 40
+	}
+
+	for _, field := range []int{settingsPasswordField, settingsConfirmField} {
+		m.inputs[field].EchoMode = textinput.EchoPassword
+		m.inputs[field].EchoCharacter = '•'
+		m.inputs[field].Placeholder = "(unchanged)"
+	}
+
+	m.inputs[settingsKeyFileField].Placeholder = "(none)"
+	m.inputs[settingsKeyFileField].SetValue(database.KeyFile)
+	m.inputs[settingsUnlockTimeField].SetValue(strconv.FormatInt(defaultUnlockTime.Milliseconds(), 10))
+
+	m.setKDF(header.KDF)
+	m.focusField(settingsPasswordField)
+
+	return m
+}
+
+// Update implements tea.Model.
+func (m *SettingsModel) Update(msg tea.Msg) (*SettingsModel, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "tab", "down":
+			m.focusField(m.nextField(1))
+
+			return m, nil
+		case "shift+tab", "up":
+			m.focusField(m.nextField(-1))
+
+			return m, nil
+		case "ctrl+k":
+			m.switchKDF()
+
+			return m, nil
+		case "ctrl+b":
+			return m.runBenchmark()
+		case "ctrl+s":
+			return m.submit()
+		case "ctrl+p":
+			for _, field := range []int{settingsPasswordField, settingsConfirmField} {
+				if m.inputs[field].EchoMode == textinput.EchoPassword {
+					m.inputs[field].EchoMode = textinput.EchoNormal
+				} else {
+					m.inputs[field].EchoMode = textinput.EchoPassword
+				}
+			}
+
+			return m, nil
+		}
+	case KDFBenchmarked:
+		if msg.Error != nil {
+			m.status = status.Error("Benchmark failed: " + msg.Error.Error())
+
+			return m, nil
+		}
+
+		m.setKDF(msg.KDF)
+		m.status = status.Success("Tuned to " + msg.KDF.String())
+
+		return m, nil
+	case MasterKeyChangeFailed:
+		m.status = status.Error(msg.Error.Error())
+
+		return m, nil
+	}
+
+	m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+
+	return m, cmd
+}
+
+// View implements tea.Model.
+func (m *SettingsModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(style.ViewTitle.Render("Database Settings") + "\n\n")
+
+	b.WriteString(m.status.Render() + "\n\n")
+
+	b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#626262")).
+		Render(fmt.Sprintf("Database: %s (KDBX %s, now %s)", m.database.Name, m.header.Version, m.header.KDF)) + "\n\n")
+
+	labelStyle := lipgloss.NewStyle().Width(14)
+	focusedLabelStyle := labelStyle.Foreground(lipgloss.Color("#7D56F4"))
+
+	for i, input := range m.inputs {
+		if !m.visible(i) {
+			continue
+		}
+
+		if i == settingsCostField {
+			b.WriteString("\n" + labelStyle.Render("KDF:") + m.kdfType + "\n")
+		}
+
+		label := m.label(i) + ":"
+		if m.focus == i {
+			b.WriteString(focusedLabelStyle.Render(label))
+		} else {
+			b.WriteString(labelStyle.Render(label))
+		}
+
+		b.WriteString(input.View() + "\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#626262")).
+		Render("Leave the password empty to keep it. Argon2id is not offered, the KeePass library cannot derive keys with it.") + "\n\n")
+
+	// Footer
+	footer := "[Tab] Next field  [Ctrl+P] Toggle Pass  [Ctrl+K] Switch KDF  [Ctrl+B] Benchmark  [Ctrl+S] Save  [Esc] Cancel"
+	b.WriteString(lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#626262")).
+		Render(footer))
+
+	return b.String()
+}
+
+func (m *SettingsModel) label(field int) string {
+	switch field {
+	case settingsPasswordField:
+		return "New password"
+	case settingsConfirmField:
+		return "Confirm"
+	case settingsKeyFileField:
+		return "Key file"
+	case settingsCostField:
+		if m.kdfType == keepass.AESKDF {
+			return "Rounds"
+		}
+
+		return "Iterations"
+	case settingsMemoryField:
+		return "Memory (MiB)"
+	case settingsParallelismField:
+		return "Threads"
+	default:
+		return "Unlock (ms)"
+	}
+}
+
+// visible reports whether the field applies to the KDF, AES-KDF having no
+// memory or parallelism.
+func (m *SettingsModel) visible(field int) bool {
+	return m.kdfType != keepass.AESKDF || (field != settingsMemoryField && field != settingsParallelismField)
+}
+
+// nextField returns the next visible field in the direction, wrapping around.
+func (m *SettingsModel) nextField(direction int) int {
+	field := m.focus
+	for {
+		field = (field + direction + settingsFieldCount) % settingsFieldCount
+		if m.visible(field) {
+			return field
+		}
+	}
+}
+
+func (m *SettingsModel) focusField(field int) {
+	for i := range m.inputs {
+		m.inputs[i].Blur()
+	}
+
+	m.focus = field
+	m.inputs[field].Focus()
+}
+
+// setKDF fills the KDF fields in with the settings.
+func (m *SettingsModel) setKDF(kdf keepass.KDF) {
+	m.kdfType = kdf.Type
+
+	if kdf.Type == keepass.AESKDF {
+		m.inputs[settingsCostField].SetValue(strconv.FormatUint(kdf.Rounds, 10))
+
+		return
+	}
+
+	m.inputs[settingsCostField].SetValue(strconv.FormatUint(kdf.Iterations, 10))
+	m.inputs[settingsMemoryField].SetValue(strconv.FormatUint(max(1, kdf.Memory/(1024*1024)), 10))
+	m.inputs[settingsParallelismField].SetValue(strconv.FormatUint(uint64(kdf.Parallelism), 10))
+}
+
+// switchKDF switches between AES-KDF and Argon2d, with the default settings of
+// the new one. KDBX 3 databases only support AES-KDF.
+func (m *SettingsModel) switchKDF() {
+	if !strings.HasPrefix(m.header.Version, "4.") {
+		m.status = status.Error(fmt.Sprintf("KDBX %s databases only support %s", m.header.Version, keepass.AESKDF))
+
+		return
+	}
+
+	kdfType := keepass.Argon2d
+	if m.kdfType == keepass.Argon2d {
+		kdfType = keepass.AESKDF
+	}
+
+	m.setKDF(keepass.DefaultKDF(kdfType))
+	m.status = status.Success("Switched to " + kdfType + ", benchmark to tune it")
+
+	if !m.visible(m.focus) {
+		m.focusField(settingsCostField)
+	}
+}
+
+// runBenchmark tunes the rounds or iterations for the unlock time.
+func (m *SettingsModel) runBenchmark() (*SettingsModel, tea.Cmd) {
+	kdf, err := m.kdf()
+	if err != nil {
+		m.status = status.Error(err.Error())
+
+		return m, nil
+	}
+
+	target, err := m.number(settingsUnlockTimeField)
+	if err != nil {
+		m.status = status.Error(err.Error())
+
+		return m, nil
+	}
+
+	m.status = status.Success("Benchmarking...")
+
+	return m, m.benchmark(kdf, time.Duration(target)*time.Millisecond)
+}
+
+// kdf reads the KDF settings from the fields.
+func (m *SettingsModel) kdf() (keepass.KDF, error) {
+	kdf := keepass.KDF{Type: m.kdfType, Rounds: 0, Iterations: 0, Memory: 0, Parallelism: 0}
+
+	cost, err := m.number(settingsCostField)
+	if err != nil {
+		return kdf, err
+	}
+
+	if m.kdfType == keepass.AESKDF {
+		kdf.Rounds = cost
+
+		return kdf, nil
+	}
+
+	memory, err := m.number(settingsMemoryField)
+	if err != nil {
+		return kdf, err
+	}
+
+	parallelism, err := m.number(settingsParallelismField)
+	if err != nil {
+		return kdf, err
+	}
+
+	kdf.Iterations = cost
+	kdf.Memory = memory * 1024 * 1024
+	kdf.Parallelism = uint32(min(parallelism, 255))
+
+	return kdf, nil
+}
+
+// number parses the positive number typed in the field.
+func (m *SettingsModel) number(field int) (uint64, error) {
+	value, err := strconv.ParseUint(strings.TrimSpace(m.inputs[field].Value()), 10, 64)
+	if err != nil || value == 0 {
+		return 0, fmt.Errorf("%s must be a positive number", m.label(field))
+	}
+
+	return value, nil
+}
+
+// submit validates the form and hands the new master key over to be saved.
+func (m *SettingsModel) submit() (*SettingsModel, tea.Cmd) {
+	password := m.inputs[settingsPasswordField].Value()
+	if password != m.inputs[settingsConfirmField].Value() {
+		m.status = status.Error("Passwords do not match")
+
+		return m, nil
+	}
+
+	kdf, err := m.kdf()
+	if err != nil {
+		m.status = status.Error(err.Error())
+
+		return m, nil
+	}
+
+	credentials := keepass.Credentials{Password: nil, KeyFile: strings.TrimSpace(m.inputs[settingsKeyFileField].Value())}
+	if password != "" {
+		credentials.Password = []byte(password)
+	}
+
+	// A missing key file is reported by the change
+	if path, err := keepass.CanonicalPath(credentials.KeyFile); err == nil {
+		credentials.KeyFile = path
+	}
+
+	m.status = status.Success("Re-encrypting...")
+
+	return m, m.save(credentials, kdf)
+}
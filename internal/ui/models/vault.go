@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/martinlehoux/kagapass/internal/keepass"
+	"github.com/martinlehoux/kagapass/internal/types"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// Vault is an unlocked database shared by the screens and the commands they
+// start: a keepass.Vault when unlocked in memory, or an agent.Vault when the
+// agent holds it.
+type Vault interface {
+	Entries() ([]types.Entry, error)
+	Groups() (types.Group, error)
+	Info() (keepass.Info, error)
+	Attachment(uuid gokeepasslib.UUID, name string) ([]byte, error)
+	SaveEntry(entry types.Entry, isNew bool) (types.Entry, []types.Entry, error)
+	RestoreVersion(uuid gokeepasslib.UUID, modified time.Time) (types.Entry, []types.Entry, error)
+	DeleteEntry(uuid gokeepasslib.UUID) ([]types.Entry, error)
+	ChangeMasterKey(credentials keepass.Credentials, kdf keepass.KDF) error
+	Changed() (bool, error)
+	Reload() ([]types.Entry, bool, error)
+	Close()
+}
@@ -1,17 +1,28 @@
 package main
 
 import (
+	"os"
+
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/martinlehoux/kagapass/internal/cli"
 	"github.com/martinlehoux/kagapass/internal/ui/models"
 )
 
 func main() {
+	// Any argument selects a non-interactive subcommand
+	if len(os.Args) > 1 {
+		os.Exit(cli.Main(os.Args[1:]))
+	}
+
 	app, err := models.NewAppModel()
 	kcore.Expect(err, "error initializing app")
 
 	p := tea.NewProgram(app, tea.WithAltScreen())
+	app.SendClipboardClears(p)
 
 	_, err = p.Run()
+
+	app.Close()
 	kcore.Expect(err, "error running app")
 }